@@ -0,0 +1,152 @@
+package analysis
+
+import (
+	"os"
+	"qa/archive"
+	"qa/tapjio"
+	"sort"
+	"time"
+)
+
+// DurationSample is a single archived observation of how long a test took.
+type DurationSample struct {
+	Duration float64
+	Status   tapjio.Status
+	Coderef  string
+	Start    string
+}
+
+// DurationHistory remembers how long each test took across previously archived runs. It
+// can be used to estimate how long a test will take the next time it runs.
+type DurationHistory struct {
+	samples map[tapjio.TestFilter][]DurationSample
+	suite   *tapjio.SuiteBeginEvent
+}
+
+func NewDurationHistory() *DurationHistory {
+	return &DurationHistory{
+		samples: make(map[tapjio.TestFilter][]DurationSample),
+	}
+}
+
+// LoadDurationHistory reads the TAP-J files archived in the numberDays days leading up to
+// today.
+func LoadDurationHistory(archiveBaseDir string, numberDays int) (*DurationHistory, error) {
+	history := NewDurationHistory()
+
+	files, err := archive.Files(archiveBaseDir, numberDays, time.Now())
+	if err != nil {
+		return nil, err
+	}
+
+	err = archive.DecodeFiles(files, history.Visitor())
+	if err != nil {
+		return nil, err
+	}
+
+	return history, nil
+}
+
+// ReadDurationHistory reads the given TAP-J files, e.g. saved by an earlier run with -save-tapj.
+func ReadDurationHistory(files []string) (*DurationHistory, error) {
+	history := NewDurationHistory()
+
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			return nil, err
+		}
+
+		err = tapjio.DecodeReader(f, history.Visitor())
+		f.Close()
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return history, nil
+}
+
+func (self *DurationHistory) Visitor() tapjio.Visitor {
+	return &tapjio.DecodingCallbacks{
+		OnSuiteBegin: func(suite tapjio.SuiteBeginEvent) error {
+			self.suite = &suite
+			return nil
+		},
+		OnTestFinish: func(test tapjio.TestFinishEvent) error {
+			self.TestFinish(test)
+			return nil
+		},
+	}
+}
+
+func (self *DurationHistory) TestFinish(test tapjio.TestFinishEvent) {
+	// Only count tests that actually ran to completion.
+	if test.Filter == "" || (test.Status != tapjio.Pass && test.Status != tapjio.Fail) {
+		return
+	}
+
+	sample := DurationSample{Duration: test.Time, Status: test.Status}
+	if self.suite != nil {
+		sample.Coderef = self.suite.Coderef
+		sample.Start = self.suite.Start
+	}
+
+	self.samples[test.Filter] = append(self.samples[test.Filter], sample)
+}
+
+// Len returns the number of tests with at least one recorded duration.
+func (self *DurationHistory) Len() int {
+	return len(self.samples)
+}
+
+// Filters returns every test with at least one recorded duration, sorted.
+func (self *DurationHistory) Filters() []tapjio.TestFilter {
+	names := make([]string, 0, len(self.samples))
+	for filter := range self.samples {
+		names = append(names, filter.String())
+	}
+	sort.Strings(names)
+
+	filters := make([]tapjio.TestFilter, len(names))
+	for ix, name := range names {
+		filters[ix] = tapjio.TestFilter(name)
+	}
+
+	return filters
+}
+
+// Samples returns every recorded duration for the given test, oldest first.
+func (self *DurationHistory) Samples(filter tapjio.TestFilter) []DurationSample {
+	return self.samples[filter]
+}
+
+// EstimateDuration returns the median of the recorded durations for the given test, if any.
+func (self *DurationHistory) EstimateDuration(filter tapjio.TestFilter) (float64, bool) {
+	samples := self.samples[filter]
+	if len(samples) == 0 {
+		return 0, false
+	}
+
+	durations := make([]float64, len(samples))
+	for ix, sample := range samples {
+		durations[ix] = sample.Duration
+	}
+
+	return Median(durations), true
+}
+
+// Median returns the median of the given values. The given slice is sorted in place.
+func Median(values []float64) float64 {
+	n := len(values)
+	if n == 0 {
+		return 0
+	}
+
+	sort.Float64s(values)
+	if n%2 == 1 {
+		return values[n/2]
+	}
+
+	return (values[n/2-1] + values[n/2]) / 2
+}
@@ -0,0 +1,191 @@
+package analysis
+
+import (
+	"math"
+	"sort"
+
+	"qa/tapjio"
+)
+
+// A Regression is a test that has become significantly slower than it used to be.
+type Regression struct {
+	Filter tapjio.TestFilter
+	Label  string
+
+	// The median durations, in seconds, of the test's baseline and of its latest samples.
+	Baseline float64
+	Recent   float64
+
+	// How many (MAD-estimated) standard deviations Recent is above Baseline.
+	Score float64
+
+	// How many runs in a row the test has been slow, up to and including the latest, and
+	// the coderef and start of the suite in which it first was.
+	Runs    int
+	Coderef string
+	Start   string
+}
+
+// Slowdown is how many times slower the test has become.
+func (self Regression) Slowdown() float64 {
+	if self.Baseline <= 0 {
+		return math.Inf(1)
+	}
+
+	return self.Recent / self.Baseline
+}
+
+// RegressionDetector finds tests whose latest durations are significantly slower than their
+// own history. Each test's baseline is the median of its earlier passing durations, and its
+// spread the median absolute deviation (MAD) from that, so a few outliers in the history
+// don't hide a slowdown or cause one.
+type RegressionDetector struct {
+	History *DurationHistory
+
+	// How many of a test's latest samples are compared to the ones before them.
+	RecentSamples int
+
+	// How many earlier samples a test needs before it has a baseline.
+	MinBaselineSamples int
+
+	// How far over the baseline, in MAD-estimated standard deviations, counts as slower.
+	Threshold float64
+
+	// Slowdowns must also be at least this many times the baseline, and this many seconds
+	// longer than it, to be worth mentioning.
+	MinSlowdown float64
+	MinIncrease float64
+}
+
+func NewRegressionDetector(history *DurationHistory) *RegressionDetector {
+	return &RegressionDetector{
+		History:            history,
+		RecentSamples:      3,
+		MinBaselineSamples: 5,
+		Threshold:          3.5,
+		MinSlowdown:        1.5,
+		MinIncrease:        0.02,
+	}
+}
+
+// MedianAbsoluteDeviation returns the median of how far each value is from median.
+func MedianAbsoluteDeviation(values []float64, median float64) float64 {
+	deviations := make([]float64, len(values))
+	for ix, value := range values {
+		deviations[ix] = math.Abs(value - median)
+	}
+
+	return Median(deviations)
+}
+
+func passingSamples(samples []DurationSample) []DurationSample {
+	var passing []DurationSample
+	for _, sample := range samples {
+		if sample.Status == tapjio.Pass {
+			passing = append(passing, sample)
+		}
+	}
+
+	return passing
+}
+
+func sampleDurations(samples []DurationSample) []float64 {
+	durations := make([]float64, len(samples))
+	for ix, sample := range samples {
+		durations[ix] = sample.Duration
+	}
+
+	return durations
+}
+
+// detect compares the last recent samples to the ones before them.
+func (self *RegressionDetector) detect(filter tapjio.TestFilter, samples []DurationSample, recent int) *Regression {
+	if recent < 1 || len(samples) < recent+self.MinBaselineSamples {
+		return nil
+	}
+
+	split := len(samples) - recent
+	baseline := sampleDurations(samples[:split])
+	median := Median(baseline)
+
+	// 1.4826 scales the MAD of normally distributed values to their standard deviation. A
+	// test that always took exactly as long still has some spread, so the score stays finite.
+	spread := math.Max(1.4826*MedianAbsoluteDeviation(baseline, median), 0.001)
+
+	slow := func(duration float64) bool {
+		return (duration-median)/spread >= self.Threshold &&
+			duration >= median*self.MinSlowdown &&
+			duration-median >= self.MinIncrease
+	}
+
+	latest := Median(sampleDurations(samples[split:]))
+	if !slow(latest) {
+		return nil
+	}
+
+	// The slowdown began with the first slow sample among the latest, unless the samples just
+	// before them were slow too.
+	began := split
+	for began < len(samples)-1 && !slow(samples[began].Duration) {
+		began++
+	}
+	for began > 0 && slow(samples[began-1].Duration) {
+		began--
+	}
+
+	return &Regression{
+		Filter:   filter,
+		Label:    filter.String(),
+		Baseline: median,
+		Recent:   latest,
+		Score:    (latest - median) / spread,
+		Runs:     len(samples) - began,
+		Coderef:  samples[began].Coderef,
+		Start:    samples[began].Start,
+	}
+}
+
+type regressionsBySlowdown []Regression
+
+func (r regressionsBySlowdown) Len() int      { return len(r) }
+func (r regressionsBySlowdown) Swap(i, j int) { r[i], r[j] = r[j], r[i] }
+func (r regressionsBySlowdown) Less(i, j int) bool {
+	return r[i].Slowdown() > r[j].Slowdown()
+}
+
+// Regressions returns every test in the history whose latest passing durations are
+// significantly slower than the earlier ones, the biggest slowdowns first.
+func (self *RegressionDetector) Regressions() []Regression {
+	var regressions []Regression
+	for _, filter := range self.History.Filters() {
+		regression := self.detect(filter, passingSamples(self.History.Samples(filter)), self.RecentSamples)
+		if regression != nil {
+			regressions = append(regressions, *regression)
+		}
+	}
+
+	sort.Stable(regressionsBySlowdown(regressions))
+	return regressions
+}
+
+// CheckTest compares a test that just passed to its history, or returns nil if it's not
+// significantly slower than usual.
+func (self *RegressionDetector) CheckTest(test tapjio.TestFinishEvent, suite *tapjio.SuiteBeginEvent) *Regression {
+	if test.Status != tapjio.Pass || test.Filter == "" {
+		return nil
+	}
+
+	sample := DurationSample{Duration: test.Time, Status: test.Status}
+	if suite != nil {
+		sample.Coderef = suite.Coderef
+		sample.Start = suite.Start
+	}
+
+	samples := append(passingSamples(self.History.Samples(test.Filter)), sample)
+	regression := self.detect(test.Filter, samples, 1)
+	if regression != nil {
+		regression.Label = tapjio.TestLabel(test.Label, test.Cases)
+	}
+
+	return regression
+}
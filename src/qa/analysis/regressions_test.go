@@ -0,0 +1,63 @@
+package analysis
+
+import (
+	"testing"
+
+	"qa/tapjio"
+)
+
+func newTestHistory(durations ...float64) *DurationHistory {
+	history := NewDurationHistory()
+	for ix, duration := range durations {
+		history.suite = &tapjio.SuiteBeginEvent{Coderef: string('a' + rune(ix))}
+		history.TestFinish(tapjio.TestFinishEvent{Filter: "UserTest#test_saves", Status: tapjio.Pass, Time: duration})
+	}
+
+	return history
+}
+
+func TestRegressionsIgnoreOutliers(t *testing.T) {
+	// A couple of slow runs in the baseline, or among the latest, aren't a slowdown.
+	history := newTestHistory(0.1, 0.11, 2, 0.1, 0.12, 0.1, 3, 0.11, 0.1, 4, 0.1)
+	regressions := NewRegressionDetector(history).Regressions()
+	if len(regressions) != 0 {
+		t.Fatalf("Expected no regressions, got %#v", regressions)
+	}
+}
+
+func TestRegressionsFindWhereSlowdownBegan(t *testing.T) {
+	history := newTestHistory(0.1, 0.11, 2, 0.1, 0.12, 0.1, 0.5, 0.55, 0.5, 0.52)
+	regressions := NewRegressionDetector(history).Regressions()
+	if len(regressions) != 1 {
+		t.Fatalf("Expected a regression, got %#v", regressions)
+	}
+
+	regression := regressions[0]
+	if regression.Coderef != "g" || regression.Runs != 4 || regression.Recent != 0.52 {
+		t.Fatalf("Expected a slowdown since g, 4 runs ago, got %#v", regression)
+	}
+	if regression.Slowdown() < 4 {
+		t.Fatalf("Expected about a 5x slowdown, got %v", regression.Slowdown())
+	}
+}
+
+func TestRegressionsCheckTest(t *testing.T) {
+	detector := NewRegressionDetector(newTestHistory(0.1, 0.11, 0.1, 0.12, 0.1))
+	suite := &tapjio.SuiteBeginEvent{Coderef: "now"}
+
+	test := tapjio.TestFinishEvent{Label: "test_saves", Filter: "UserTest#test_saves", Status: tapjio.Pass, Time: 0.105}
+	if regression := detector.CheckTest(test, suite); regression != nil {
+		t.Fatalf("Expected no regression, got %#v", regression)
+	}
+
+	test.Time = 1
+	regression := detector.CheckTest(test, suite)
+	if regression == nil || regression.Coderef != "now" || regression.Label != "test_saves" {
+		t.Fatalf("Expected a regression, got %#v", regression)
+	}
+
+	test.Status = tapjio.Fail
+	if regression := detector.CheckTest(test, suite); regression != nil {
+		t.Fatalf("Expected failures to be skipped, got %#v", regression)
+	}
+}
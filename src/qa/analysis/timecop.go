@@ -37,6 +37,12 @@ type Outcome struct {
 }
 
 func (self *TimeCop) TestFinish(test tapjio.TestFinishEvent) {
+	// Attempts that were retried took time, but their outcome is decided by a later attempt.
+	if test.Retried {
+		self.TotalDuration += test.Time
+		return
+	}
+
 	o := Outcome{
 		Duration: test.Time,
 		Label:    tapjio.TestLabel(test.Label, test.Cases),
@@ -0,0 +1,101 @@
+package archive
+
+import (
+	"fmt"
+	"math/rand"
+	"os"
+	"path"
+	"path/filepath"
+	"qa/tapjio"
+	"strings"
+	"time"
+)
+
+const tapjExtension = ".tapj"
+
+const nonceLexicon = "abcdefghijklmnopqrstuvwxyz"
+const nonceLength = 8
+
+func randomString(r *rand.Rand, lexicon string, length int) string {
+	bytes := make([]byte, length)
+	lexiconLen := len(lexicon)
+	for i := 0; i < length; i++ {
+		bytes[i] = lexicon[r.Intn(lexiconLen)]
+	}
+
+	return string(bytes)
+}
+
+// NewEmitter returns a visitor that writes TAP-J to a new file in the archive at baseDir,
+// filed under the day of the given time.
+func NewEmitter(baseDir string, when time.Time) (tapjio.Visitor, error) {
+	dir := path.Join(baseDir, when.Format("2006-01-02"))
+	os.MkdirAll(dir, 0755)
+	r := rand.New(rand.NewSource(time.Now().UnixNano()))
+	nonce := randomString(r, nonceLexicon, nonceLength)
+
+	file, err := os.Create(path.Join(dir, fmt.Sprintf("%d-%s%s", when.Unix(), nonce, tapjExtension)))
+	if err != nil {
+		return nil, err
+	}
+
+	return tapjio.NewTapjEmitCloser(file), nil
+}
+
+// Files returns the TAP-J files found in the archive at baseDir for the numberDays days
+// leading up to and including untilDate. Files are returned oldest day first. This mirrors
+// the date window used by tapj-discover.rb.
+func Files(baseDir string, numberDays int, untilDate time.Time) ([]string, error) {
+	var files []string
+
+	day := untilDate.AddDate(0, 0, 1-numberDays)
+	for !day.After(untilDate) {
+		dir := path.Join(baseDir, day.Format("2006-01-02"))
+		day = day.AddDate(0, 0, 1)
+
+		if info, err := os.Stat(dir); err != nil || !info.IsDir() {
+			continue
+		}
+
+		err := filepath.Walk(dir, func(p string, info os.FileInfo, err error) error {
+			if err != nil {
+				return err
+			}
+
+			if !info.IsDir() && strings.HasSuffix(p, tapjExtension) {
+				files = append(files, p)
+			}
+
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return files, nil
+}
+
+// DecodeFiles decodes each of the given TAP-J files in order, visiting their events with
+// the given visitor. Files that can't be opened or that end abruptly (e.g. because the run
+// that produced them was interrupted) are skipped once the readable events are visited.
+func DecodeFiles(files []string, visitor tapjio.Visitor) error {
+	for _, file := range files {
+		f, err := os.Open(file)
+		if err != nil {
+			continue
+		}
+
+		tapjio.DecodeReader(f, &tapjio.DecodingCallbacks{
+			OnSuiteBegin:  visitor.SuiteBegin,
+			OnTestBegin:   visitor.TestBegin,
+			OnTestFinish:  visitor.TestFinish,
+			OnTrace:       visitor.TraceEvent,
+			OnAwaitAttach: visitor.AwaitAttach,
+			OnSuiteFinish: visitor.SuiteFinish,
+		})
+		f.Close()
+	}
+
+	return visitor.End(nil)
+}
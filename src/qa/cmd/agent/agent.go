@@ -0,0 +1,60 @@
+package agent
+
+import (
+	"errors"
+	"flag"
+	"os"
+
+	"qa/cmd"
+	"qa/cmd/run"
+	qarun "qa/run"
+	"qa/runner"
+	"qa/runner/remote"
+	"qa/tapjio"
+)
+
+// Usage:
+//     agent [-jobs n] [flags] coordinator-host:port
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	f := run.DefineFlags(env.Vars, flags)
+	name := flags.String("name", "", "Name to give the coordinator for this agent. Defaults to the hostname")
+	err := f.Parse(flags, env.Dir, argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 1 {
+		return errors.New("Usage: agent [flags] coordinator-host:port")
+	}
+
+	if *name == "" {
+		*name, err = os.Hostname()
+		if err != nil {
+			return err
+		}
+	}
+
+	f.ApplyImpliedDefaults()
+	srv, err := f.Listen()
+	if err != nil {
+		return err
+	}
+	defer srv.Close()
+
+	workerEnvs := f.WorkerEnvs()
+	agent := &remote.Agent{
+		Name:       *name,
+		WorkerEnvs: workerEnvs,
+		Log:        env.Stderr,
+		StartContext: func(runnerName string, patterns []string, filters []tapjio.TestFilter) (runner.Context, error) {
+			runnerConfig := f.NewRunnerConfig(env, runnerName, patterns)
+			runnerConfig.Filters = filters
+			return qarun.StartContext(srv, workerEnvs, runnerConfig)
+		},
+	}
+
+	return agent.Serve(flags.Arg(0))
+}
@@ -0,0 +1,326 @@
+package bisect
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strconv"
+
+	"qa/cmd"
+	"qa/cmd/run"
+	"qa/reporting"
+	qarun "qa/run"
+	"qa/runner"
+	"qa/tapjio"
+)
+
+// Usage:
+//     bisect [flags] run.tapj failing-test-filter
+
+type recordedTest struct {
+	Filter tapjio.TestFilter
+	File   tapjio.FilePath
+}
+
+type recordedRun struct {
+	Seed       int
+	Runner     string
+	Worker     string
+	Target     recordedTest
+	Candidates []recordedTest
+}
+
+// readRecordedRun finds the first failure of target in the TAP-J file at path, along with
+// the tests that ran before it on the same worker.
+func readRecordedRun(path string, target tapjio.TestFilter) (*recordedRun, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var found *recordedRun
+	var seed int
+	var earlier []tapjio.TestFinishEvent
+	err = tapjio.DecodeReader(f, &tapjio.DecodingCallbacks{
+		OnSuiteBegin: func(event tapjio.SuiteBeginEvent) error {
+			seed = event.Seed
+			earlier = nil
+			return nil
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			if found != nil || event.Filter == "" || event.Status == tapjio.NotRun {
+				return nil
+			}
+
+			if event.Filter != target {
+				earlier = append(earlier, event)
+				return nil
+			}
+
+			if event.Status != tapjio.Fail && event.Status != tapjio.Error {
+				return nil
+			}
+
+			found = &recordedRun{
+				Seed:   seed,
+				Runner: event.Runner,
+				Worker: event.Worker,
+				Target: recordedTest{Filter: event.Filter, File: event.File},
+			}
+
+			seen := make(map[tapjio.TestFilter]bool)
+			for _, test := range earlier {
+				if test.Worker != event.Worker || test.Runner != event.Runner || seen[test.Filter] {
+					continue
+				}
+				seen[test.Filter] = true
+				found.Candidates = append(found.Candidates, recordedTest{Filter: test.Filter, File: test.File})
+			}
+
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	if found == nil {
+		return nil, fmt.Errorf("%s: no failure recorded for %s", path, target)
+	}
+
+	return found, nil
+}
+
+// split divides tests into n contiguous chunks of roughly equal size.
+func split(tests []recordedTest, n int) [][]recordedTest {
+	chunks := make([][]recordedTest, n)
+	for ix := 0; ix < n; ix++ {
+		chunks[ix] = tests[ix*len(tests)/n : (ix+1)*len(tests)/n]
+	}
+
+	return chunks
+}
+
+func complement(chunks [][]recordedTest, skip int) []recordedTest {
+	var tests []recordedTest
+	for ix, chunk := range chunks {
+		if ix != skip {
+			tests = append(tests, chunk...)
+		}
+	}
+
+	return tests
+}
+
+// minimize uses delta debugging to find a minimal subset of candidates for which fails
+// returns true. It assumes that fails(candidates) is true.
+func minimize(candidates []recordedTest, fails func([]recordedTest) (bool, error)) ([]recordedTest, error) {
+	n := 2
+	for len(candidates) >= 2 {
+		if n > len(candidates) {
+			n = len(candidates)
+		}
+
+		chunks := split(candidates, n)
+		reduced := false
+		for _, chunk := range chunks {
+			failed, err := fails(chunk)
+			if err != nil {
+				return nil, err
+			}
+
+			if failed {
+				candidates = chunk
+				n = 2
+				reduced = true
+				break
+			}
+		}
+
+		// With two chunks, each complement is just the other chunk.
+		if !reduced && n > 2 {
+			for ix := range chunks {
+				rest := complement(chunks, ix)
+				failed, err := fails(rest)
+				if err != nil {
+					return nil, err
+				}
+
+				if failed {
+					candidates = rest
+					n--
+					reduced = true
+					break
+				}
+			}
+		}
+
+		if reduced {
+			continue
+		}
+
+		if n == len(candidates) {
+			break
+		}
+
+		n *= 2
+	}
+
+	return candidates, nil
+}
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	f := run.DefineFlags(env.Vars, flags)
+	err := f.Parse(flags, env.Dir, argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() != 2 {
+		return errors.New("Usage: bisect [flags] run.tapj failing-test-filter")
+	}
+
+	target := tapjio.TestFilter(flags.Arg(1))
+	recorded, err := readRecordedRun(flags.Arg(0), target)
+	if err != nil {
+		return err
+	}
+
+	if recorded.Runner == "" {
+		return fmt.Errorf("%s: no runner recorded for %s", flags.Arg(0), target)
+	}
+
+	// Run everything on a single worker, with the recorded seed unless another was given.
+	if !f.GivenOnCommandLine("seed") {
+		flags.Set("seed", strconv.Itoa(recorded.Seed))
+	}
+	flags.Set("jobs", "1")
+	flags.Set("runs", "1")
+	flags.Set("squash", "all")
+	flags.Set("retries", "0")
+	flags.Set("fail-fast", "0")
+	f.ApplyImpliedDefaults()
+
+	newRunnerConfig := func(tests []recordedTest) runner.Config {
+		var patterns []string
+		var filters []tapjio.TestFilter
+		seenFiles := make(map[tapjio.FilePath]bool)
+		all := append(append([]recordedTest{}, tests...), recorded.Target)
+		for _, test := range all {
+			filters = append(filters, test.Filter)
+			if !seenFiles[test.File] {
+				seenFiles[test.File] = true
+				patterns = append(patterns, test.File.String())
+			}
+		}
+
+		config := f.NewRunnerConfig(env, recorded.Runner, patterns)
+		config.Filters = filters
+		// Frameworks shuffle the tests they load by seed, so the target could otherwise run
+		// before the candidates it's being tried after.
+		config.RunLast = target
+		return config
+	}
+
+	runEnv, err := f.NewEnv(env, []runner.Config{newRunnerConfig(recorded.Candidates)})
+	if err != nil {
+		return err
+	}
+	defer runEnv.Server.Close()
+
+	// The target has to share a worker with the candidates to be polluted by them, so it stays
+	// in their runner, which goes after any others.
+	runEnv.Arrange = func(runners []runner.TestRunner) []runner.TestRunner {
+		var arranged, last []runner.TestRunner
+		for _, testRunner := range runners {
+			hasTarget := false
+			for _, filter := range testRunner.Filters() {
+				if filter == target {
+					hasTarget = true
+				}
+			}
+
+			if hasTarget {
+				last = append(last, testRunner)
+			} else {
+				arranged = append(arranged, testRunner)
+			}
+		}
+
+		return append(arranged, last...)
+	}
+
+	trials := 0
+	fails := func(tests []recordedTest) (bool, error) {
+		trials++
+		fmt.Fprintf(env.Stderr, "Trial %d: running %s with %d other %s... ",
+			trials, target, len(tests), reporting.MaybePlural(len(tests), "test", "tests"))
+
+		var status tapjio.Status
+		runEnv.RunnerConfigs = []runner.Config{newRunnerConfig(tests)}
+		runEnv.Visitor = &tapjio.DecodingCallbacks{
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				if event.Filter == target {
+					status = event.Status
+				}
+				return nil
+			},
+		}
+
+		_, err := qarun.Run(runEnv)
+		if err != nil {
+			fmt.Fprintln(env.Stderr)
+			return false, err
+		}
+
+		if status == "" {
+			fmt.Fprintln(env.Stderr)
+			return false, fmt.Errorf("%s did not run", target)
+		}
+
+		fmt.Fprintln(env.Stderr, status)
+		return status == tapjio.Fail || status == tapjio.Error, nil
+	}
+
+	workerDescription := "worker " + recorded.Worker
+	if recorded.Worker == "" {
+		workerDescription = "any worker (none were recorded)"
+	}
+	fmt.Fprintf(env.Stderr, "%d %s ran before %s on %s, with seed %s.\n",
+		len(recorded.Candidates), reporting.MaybePlural(len(recorded.Candidates), "test", "tests"),
+		target, workerDescription, flags.Lookup("seed").Value.String())
+
+	failed, err := fails(recorded.Candidates)
+	if err != nil {
+		return err
+	}
+	if !failed {
+		fmt.Fprintf(env.Stderr, "%s does not fail when re-run after the same tests.\n", target)
+		return &cmd.QuietError{Status: 1}
+	}
+
+	failed, err = fails(nil)
+	if err != nil {
+		return err
+	}
+	if failed {
+		fmt.Fprintf(env.Stderr, "%s fails when run on its own, so it doesn't depend on other tests.\n", target)
+		return &cmd.QuietError{Status: 1}
+	}
+
+	minimal, err := minimize(recorded.Candidates, fails)
+	if err != nil {
+		return err
+	}
+
+	fmt.Fprintf(env.Stderr, "After %d trials, %s fails when run after:\n", trials, target)
+	for _, test := range minimal {
+		fmt.Fprintln(env.Stdout, test.Filter)
+	}
+
+	return nil
+}
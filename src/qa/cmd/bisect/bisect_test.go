@@ -0,0 +1,82 @@
+package bisect
+
+import (
+	"io/ioutil"
+	"os"
+	"qa/tapjio"
+	"testing"
+	"time"
+)
+
+func tests(filters ...tapjio.TestFilter) []recordedTest {
+	var tests []recordedTest
+	for _, filter := range filters {
+		tests = append(tests, recordedTest{Filter: filter})
+	}
+
+	return tests
+}
+
+func TestMinimize(t *testing.T) {
+	candidates := tests("a", "b", "c", "d", "e", "f", "g", "h", "i")
+
+	trials := 0
+	minimal, err := minimize(candidates, func(subset []recordedTest) (bool, error) {
+		trials++
+		sawC, sawG := false, false
+		for _, test := range subset {
+			sawC = sawC || test.Filter == "c"
+			sawG = sawG || test.Filter == "g"
+		}
+		return sawC && sawG, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(minimal) != 2 || minimal[0].Filter != "c" || minimal[1].Filter != "g" {
+		t.Fatalf("Expected [c g], got %v after %d trials", minimal, trials)
+	}
+}
+
+func TestReadRecordedRun(t *testing.T) {
+	f, err := ioutil.TempFile("", "bisect")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.Remove(f.Name())
+
+	emitter := tapjio.NewTapjEmitCloser(f)
+	emitter.SuiteBegin(*tapjio.NewSuiteBeginEvent(time.Now(), 5, 42))
+	for _, event := range []tapjio.TestFinishEvent{
+		{Filter: "a", Status: tapjio.Pass, Worker: "0"},
+		{Filter: "b", Status: tapjio.Pass, Worker: "1"},
+		{Filter: "c", Status: tapjio.Pass, Worker: "0"},
+		{Filter: "target", Status: tapjio.Fail, Worker: "0", File: "target_test.rb"},
+		{Filter: "d", Status: tapjio.Pass, Worker: "0"},
+	} {
+		event.Type = "test"
+		event.Runner = "minitest"
+		emitter.TestFinish(event)
+	}
+	emitter.End(nil)
+
+	recorded, err := readRecordedRun(f.Name(), "target")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if recorded.Seed != 42 || recorded.Runner != "minitest" || recorded.Target.File != "target_test.rb" {
+		t.Fatalf("Unexpected recorded run: %#v", recorded)
+	}
+
+	expected := tests("a", "c")
+	if len(recorded.Candidates) != len(expected) {
+		t.Fatalf("Expected candidates %v, got %v", expected, recorded.Candidates)
+	}
+	for ix, test := range expected {
+		if recorded.Candidates[ix] != test {
+			t.Fatalf("Expected candidates %v, got %v", expected, recorded.Candidates)
+		}
+	}
+}
@@ -0,0 +1,27 @@
+package config
+
+import (
+	"flag"
+	"fmt"
+
+	"qa/cmd"
+	"qa/cmd/run"
+)
+
+// Usage:
+//     config [-profile name] [flags] [runner-spec...]
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	f := run.DefineFlags(env.Vars, flags)
+	err := f.Parse(flags, env.Dir, argv[1:])
+	if err != nil {
+		return err
+	}
+
+	f.ApplyImpliedDefaults()
+	fmt.Fprint(env.Stdout, f.DescribeConfig(flags, f.RunnerSpecs(flags.Args())))
+
+	return nil
+}
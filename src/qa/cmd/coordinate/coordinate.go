@@ -0,0 +1,65 @@
+package coordinate
+
+import (
+	"flag"
+	"fmt"
+
+	"qa/cmd"
+	"qa/cmd/run"
+	qarun "qa/run"
+	"qa/runner/remote"
+)
+
+// Usage:
+//     coordinate [-agents n] -listen-address 0.0.0.0:port [flags] runner-spec...
+//
+// Then, on each machine with a checkout of the project:
+//     agent [-jobs n] [flags] coordinator-host:port
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	f := run.DefineFlags(env.Vars, flags)
+	agents := flags.Int("agents", 1, "Wait for this many agents to connect before starting. Agents that connect later are turned away")
+	err := f.Parse(flags, env.Dir, argv[1:])
+	if err != nil {
+		return err
+	}
+
+	f.ApplyImpliedDefaults()
+	runEnv, err := f.NewEnv(env, f.ParseRunnerConfigs(env, f.RunnerSpecs(flags.Args())))
+	if err != nil {
+		return err
+	}
+	defer runEnv.Server.Close()
+
+	coordinator, err := remote.NewCoordinator(runEnv.Server, env.Stderr)
+	if err != nil {
+		return err
+	}
+	defer coordinator.Close()
+
+	fmt.Fprintf(env.Stderr, "Waiting for %d agents on %s\n", *agents, runEnv.Server.Address())
+	workers, err := coordinator.WaitForAgents(*agents)
+	if err != nil {
+		return err
+	}
+
+	// Agents give their own workers their own envs, so these only say how many workers there are.
+	runEnv.WorkerEnvs = make([]map[string]string, workers)
+	for ix := range runEnv.WorkerEnvs {
+		runEnv.WorkerEnvs[ix] = map[string]string{}
+	}
+	runEnv.StartContext = coordinator.StartContext
+
+	passed, err := qarun.Run(runEnv)
+	if err != nil {
+		return err
+	}
+
+	if !passed {
+		return &cmd.QuietError{Status: 1}
+	}
+
+	return nil
+}
@@ -0,0 +1,189 @@
+package importer
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"qa/archive"
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+// Usage:
+//     import tap [-archive dir] [-file name] [-date 2006-01-02] results.tap...
+//     import junit [-archive dir] [-suite-label label] [-suite-coderef ref] TEST-results.xml...
+//
+// Each file given is added to the archive as a suite of its own, for use with qa flaky.
+
+// A format reads the tests in a file, visiting a TestFinishEvent for each. It returns when the
+// tests started, or the zero time if the file doesn't say.
+type format func(path string, file tapjio.FilePath, visitor tapjio.Visitor) (time.Time, error)
+
+var formats = map[string]format{
+	"tap":   importTap,
+	"junit": importJunit,
+}
+
+func importTap(path string, file tapjio.FilePath, visitor tapjio.Visitor) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	return time.Time{}, tapjio.DecodeTap(f, file, visitor)
+}
+
+func importJunit(path string, file tapjio.FilePath, visitor tapjio.Visitor) (time.Time, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return time.Time{}, err
+	}
+	defer f.Close()
+
+	return tapjio.DecodeJunit(f, file, visitor)
+}
+
+func formatNames() string {
+	var names []string
+	for name := range formats {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return strings.Join(names, ", ")
+}
+
+// importFile adds the tests in the file at path to the archive at archiveDir, as the given
+// suite. If the suite has no start, the file's own timestamp is used, then its modification
+// time. The suite's label defaults to the file's name.
+func importFile(read format, archiveDir string, path string, file tapjio.FilePath, suite tapjio.SuiteBeginEvent) (*tapjio.ResultTally, error) {
+	var tests []tapjio.TestFinishEvent
+	fileStart, err := read(path, file, &tapjio.DecodingCallbacks{
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			tests = append(tests, event)
+			return nil
+		},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	var start time.Time
+	if suite.Start != "" {
+		start, err = time.ParseInLocation("2006-01-02 15:04:05", suite.Start, time.Local)
+		if err != nil {
+			return nil, err
+		}
+	} else if !fileStart.IsZero() {
+		start = fileStart.Local()
+	} else {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		start = info.ModTime()
+	}
+
+	visitor, err := archive.NewEmitter(archiveDir, start)
+	if err != nil {
+		return nil, err
+	}
+
+	count := 0
+	for _, test := range tests {
+		if !test.Retried {
+			count++
+		}
+	}
+
+	begin := tapjio.NewSuiteBeginEvent(start, count, 0)
+	begin.Label = suite.Label
+	if begin.Label == "" {
+		begin.Label = filepath.Base(path)
+	}
+	begin.Coderef = suite.Coderef
+	err = visitor.SuiteBegin(*begin)
+	if err != nil {
+		visitor.End(err)
+		return nil, err
+	}
+
+	final := tapjio.NewSuiteFinishEvent(begin)
+	for _, test := range tests {
+		final.Time += test.Time
+		final.Counts.IncrementFor(test)
+		err = visitor.TestFinish(test)
+		if err != nil {
+			visitor.End(err)
+			return nil, err
+		}
+	}
+
+	err = visitor.SuiteFinish(*final)
+	if err != nil {
+		visitor.End(err)
+		return nil, err
+	}
+
+	return final.Counts, visitor.End(nil)
+}
+
+func Main(env *cmd.Env, argv []string) error {
+	if len(argv) < 2 || formats[argv[1]] == nil {
+		return fmt.Errorf("Usage: import <format> [flags] file...\nFormats: %s", formatNames())
+	}
+	read := formats[argv[1]]
+
+	flags := flag.NewFlagSet(argv[0]+" "+argv[1], flag.ContinueOnError)
+	archiveDir := flags.String("archive", env.Vars["QA_ARCHIVE"], "Base directory of the archive to import into")
+	file := flags.String("file", "", "Test file to record each test as being in. Tests are identified by their description alone if not given")
+	date := flags.String("date", "", "Day to file results under, e.g. 2006-01-02. Defaults to the time each file gives, or its modification time")
+	suiteLabel := flags.String("suite-label", "", "Set label for each suite. Defaults to the name of its file")
+	suiteCoderef := flags.String("suite-coderef", "", "Set coderef for each suite (useful for flakiness detection)")
+	err := flags.Parse(argv[2:])
+	if err != nil {
+		return err
+	}
+
+	if *archiveDir == "" {
+		return errors.New("No archive given. Use -archive or set QA_ARCHIVE")
+	}
+	if flags.NArg() == 0 {
+		return fmt.Errorf("Usage: import %s [flags] file...", argv[1])
+	}
+	if !filepath.IsAbs(*archiveDir) {
+		*archiveDir = filepath.Join(env.Dir, *archiveDir)
+	}
+
+	suite := tapjio.SuiteBeginEvent{Label: *suiteLabel, Coderef: *suiteCoderef}
+	if *date != "" {
+		day, err := time.ParseInLocation("2006-01-02", *date, time.Local)
+		if err != nil {
+			return err
+		}
+		suite.Start = day.Format("2006-01-02 15:04:05")
+	}
+
+	for _, path := range flags.Args() {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(env.Dir, path)
+		}
+
+		counts, err := importFile(read, *archiveDir, path, tapjio.FilePath(*file), suite)
+		if err != nil {
+			return err
+		}
+
+		fmt.Fprintf(env.Stderr, "Imported %d tests from %s (%d passed, %d failed, %d errored)\n",
+			counts.Total, path, counts.Pass, counts.Fail, counts.Error)
+	}
+
+	return nil
+}
@@ -0,0 +1,124 @@
+package importer
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"qa/archive"
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+func TestImportTap(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	tap := "TAP version 13\n1..3\nok 1 - one\nnot ok 2 - two\nok 3 - three # SKIP\n"
+	err = ioutil.WriteFile(filepath.Join(dir, "results.tap"), []byte(tap), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := &bytes.Buffer{}
+	env := &cmd.Env{Dir: dir, Stderr: stderr}
+	err = Main(env, []string{"import", "tap", "-archive", "archive", "-date", "2016-03-04", "-file", "t/math.t", "results.tap"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	day, _ := time.ParseInLocation("2006-01-02", "2016-03-04", time.Local)
+	files, err := archive.Files(filepath.Join(dir, "archive"), 1, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected one archived file, got %v", files)
+	}
+
+	var suites []tapjio.SuiteFinishEvent
+	var tests []tapjio.TestFinishEvent
+	err = archive.DecodeFiles(files, &tapjio.DecodingCallbacks{
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			tests = append(tests, event)
+			return nil
+		},
+		OnSuiteFinish: func(event tapjio.SuiteFinishEvent) error {
+			suites = append(suites, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(suites) != 1 || suites[0].Counts.Total != 3 || suites[0].Counts.Fail != 1 {
+		t.Fatalf("Expected a suite of 3 tests with 1 failure, got %#v", suites)
+	}
+	if len(tests) != 3 || tests[1].Filter != "t/math.t#two" || tests[1].Status != tapjio.Fail {
+		t.Fatalf("Unexpected tests: %#v", tests)
+	}
+}
+
+func TestImportJunit(t *testing.T) {
+	dir, err := ioutil.TempDir("", "import")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	junit := `<testsuite name="MathTest" timestamp="2016-03-04T05:06:07">
+  <testcase name="adds" classname="MathTest"/>
+  <testcase name="subtracts" classname="MathTest"><failure message="off by one"/></testcase>
+</testsuite>`
+	err = ioutil.WriteFile(filepath.Join(dir, "TEST-MathTest.xml"), []byte(junit), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stderr := &bytes.Buffer{}
+	env := &cmd.Env{Dir: dir, Stderr: stderr}
+	err = Main(env, []string{"import", "junit", "-archive", "archive", "-suite-label", "nightly", "-suite-coderef", "abc123", "TEST-MathTest.xml"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Filed under the day the testsuite gives, rather than the file's modification time.
+	day, _ := time.ParseInLocation("2006-01-02", "2016-03-04", time.Local)
+	files, err := archive.Files(filepath.Join(dir, "archive"), 1, day)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files) != 1 {
+		t.Fatalf("Expected one archived file, got %v", files)
+	}
+
+	var suites []tapjio.SuiteBeginEvent
+	var tests []tapjio.TestFinishEvent
+	err = archive.DecodeFiles(files, &tapjio.DecodingCallbacks{
+		OnSuiteBegin: func(event tapjio.SuiteBeginEvent) error {
+			suites = append(suites, event)
+			return nil
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			tests = append(tests, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(suites) != 1 || suites[0].Label != "nightly" || suites[0].Coderef != "abc123" || suites[0].Start != "2016-03-04 05:06:07" {
+		t.Fatalf("Unexpected suites: %#v", suites)
+	}
+	if len(tests) != 2 || tests[1].Filter != "MathTest#subtracts" || tests[1].Status != tapjio.Fail || len(tests[1].Cases) != 1 {
+		t.Fatalf("Unexpected tests: %#v", tests)
+	}
+}
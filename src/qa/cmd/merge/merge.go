@@ -0,0 +1,256 @@
+package merge
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+// Usage:
+//     merge shard1.tapj shard2.tapj ... > merged.tapj
+
+// Problems describes ways in which the merged streams don't add up to a single suite.
+type Problems struct {
+	Messages []string
+}
+
+func (self *Problems) add(format string, args ...interface{}) {
+	self.Messages = append(self.Messages, fmt.Sprintf(format, args...))
+}
+
+func (self *Problems) Len() int {
+	return len(self.Messages)
+}
+
+type mergedFile struct {
+	path  string
+	suite *tapjio.SuiteBeginEvent
+	final *tapjio.SuiteFinishEvent
+	tally *tapjio.ResultTally
+	tests map[string]bool
+}
+
+func readSuiteBegin(path string) (*tapjio.SuiteBeginEvent, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var raw json.RawMessage
+	err = json.NewDecoder(f).Decode(&raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	err, event := tapjio.UnmarshalEvent(raw)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	suite, ok := event.(*tapjio.SuiteBeginEvent)
+	if !ok {
+		return nil, errors.New(path + ": does not start with a suite event")
+	}
+
+	return suite, nil
+}
+
+func testKey(event tapjio.TestFinishEvent) string {
+	if event.Filter != "" {
+		return event.Filter.String()
+	}
+
+	return tapjio.TestLabel(event.Label, event.Cases)
+}
+
+// Merge combines the TAP-J streams in the given files into a single suite, visiting it with
+// the given visitor. Each file must contain a single suite. Tests are visited in the order
+// the files are given.
+func Merge(paths []string, visitor tapjio.Visitor) (*Problems, error) {
+	problems := &Problems{}
+	files := make([]*mergedFile, len(paths))
+
+	var merged *tapjio.SuiteBeginEvent
+	shardCount := 0
+	shards := make(map[int][]string)
+	for ix, path := range paths {
+		suite, err := readSuiteBegin(path)
+		if err != nil {
+			return nil, err
+		}
+		files[ix] = &mergedFile{path: path, suite: suite, tally: &tapjio.ResultTally{}, tests: make(map[string]bool)}
+
+		if merged == nil {
+			s := *suite
+			merged = &s
+			merged.Count = 0
+			merged.Shard = 0
+			merged.ShardCount = 0
+		} else if suite.Start < merged.Start {
+			merged.Start = suite.Start
+		}
+		merged.Count += suite.Count
+
+		if suite.ShardCount > 0 {
+			if shardCount == 0 {
+				shardCount = suite.ShardCount
+			} else if shardCount != suite.ShardCount {
+				problems.add("%s is shard %d/%d, but expected %d shards",
+					path, suite.Shard, suite.ShardCount, shardCount)
+			}
+			shards[suite.Shard] = append(shards[suite.Shard], path)
+		}
+	}
+
+	if merged == nil {
+		return nil, errors.New("No TAP-J files given")
+	}
+
+	for shard := 1; shard <= shardCount; shard++ {
+		switch seen := shards[shard]; len(seen) {
+		case 0:
+			problems.add("Missing shard %d/%d", shard, shardCount)
+		case 1:
+		default:
+			problems.add("Shard %d/%d given more than once: %v", shard, shardCount, seen)
+		}
+	}
+
+	err := visitor.SuiteBegin(*merged)
+	if err != nil {
+		return nil, visitEnd(visitor, err)
+	}
+
+	final := tapjio.NewSuiteFinishEvent(merged)
+	var budgetTime float64
+	seenIn := make(map[string]*mergedFile)
+	var duplicates []string
+	for _, file := range files {
+		file := file
+		f, err := os.Open(file.path)
+		if err != nil {
+			return nil, visitEnd(visitor, err)
+		}
+
+		err = tapjio.DecodeReader(f, &tapjio.DecodingCallbacks{
+			OnSuiteBegin: func(event tapjio.SuiteBeginEvent) error {
+				if file.final != nil {
+					return errors.New(file.path + ": contains more than one suite")
+				}
+				return nil
+			},
+			OnTrace:     visitor.TraceEvent,
+			OnTestBegin: visitor.TestBegin,
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				key := testKey(event)
+				file.tests[key] = true
+				if other, ok := seenIn[key]; !ok {
+					seenIn[key] = file
+				} else if other != file {
+					duplicates = append(duplicates,
+						fmt.Sprintf("Duplicate test %s in %s and %s", key, other.path, file.path))
+				}
+
+				file.tally.IncrementFor(event)
+				return visitor.TestFinish(event)
+			},
+			OnSuiteFinish: func(event tapjio.SuiteFinishEvent) error {
+				file.final = &event
+				return nil
+			},
+		})
+		f.Close()
+		if err != nil {
+			return nil, visitEnd(visitor, err)
+		}
+
+		counts := file.tally
+		if file.final == nil {
+			problems.add("%s ended without a final event", file.path)
+		} else {
+			counts = file.final.Counts
+			if file.final.Time > final.Time {
+				final.Time = file.final.Time
+			}
+
+			// A shard over its suite budget fails the merged suite too. Keep the worst overrun,
+			// preferring ones that fail over ones that only warn.
+			if budget := file.final.Budget; budget != nil && (final.Budget == nil ||
+				final.Budget.Warning && !budget.Warning ||
+				final.Budget.Warning == budget.Warning && file.final.Time > budgetTime) {
+				final.Budget = budget
+				budgetTime = file.final.Time
+			}
+
+			for stat, value := range file.final.Stats {
+				if stat == tapjio.EstimatedMakespanStat || stat == tapjio.MakespanStat {
+					if value > final.Stats[stat] {
+						final.Stats[stat] = value
+					}
+				} else {
+					final.IncrementStat(stat, value)
+				}
+			}
+		}
+		final.Counts.IncrementAll(counts)
+
+		if len(file.tests) < file.suite.Count {
+			problems.add("%s reported %d of %d tests", file.path, len(file.tests), file.suite.Count)
+		}
+	}
+
+	sort.Strings(duplicates)
+	problems.Messages = append(problems.Messages, duplicates...)
+
+	err = visitor.SuiteFinish(*final)
+	if err != nil {
+		return nil, visitEnd(visitor, err)
+	}
+
+	return problems, visitor.End(nil)
+}
+
+func visitEnd(visitor tapjio.Visitor, reason error) error {
+	err := visitor.End(reason)
+	if reason == nil {
+		return err
+	}
+
+	return reason
+}
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+	strict := flags.Bool("strict", false, "Exit with a non-zero status if tests or shards are duplicated or missing")
+
+	err := flags.Parse(argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("Usage: merge [-strict] file.tapj...")
+	}
+
+	problems, err := Merge(flags.Args(), tapjio.NewTapjEmitter(env.Stdout))
+	if err != nil {
+		return err
+	}
+
+	for _, message := range problems.Messages {
+		fmt.Fprintln(env.Stderr, message)
+	}
+
+	if *strict && problems.Len() > 0 {
+		return &cmd.QuietError{Status: 1}
+	}
+
+	return nil
+}
@@ -0,0 +1,150 @@
+package merge
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"qa/tapjio"
+	"strings"
+	"testing"
+	"time"
+)
+
+// writeShard writes a shard's results. If budget is given, the shard took twice as long.
+func writeShard(t *testing.T, dir string, shard, missing int, statuses map[tapjio.TestFilter]tapjio.Status, budget *tapjio.BudgetOverrun) string {
+	path := filepath.Join(dir, fmt.Sprintf("%d.tapj", shard))
+	f, err := os.Create(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	emitter := tapjio.NewTapjEmitCloser(f)
+	suite := tapjio.NewSuiteBeginEvent(time.Now(), len(statuses)+missing, 1)
+	suite.Shard = shard
+	suite.ShardCount = 3
+	emitter.SuiteBegin(*suite)
+
+	final := tapjio.NewSuiteFinishEvent(suite)
+	for filter, status := range statuses {
+		event := tapjio.TestFinishEvent{
+			Type:   "test",
+			Label:  filter.String(),
+			Filter: filter,
+			Status: status,
+			Cases:  []tapjio.CaseEvent{{Type: "case", Label: "Shard", Level: 0}},
+		}
+		emitter.TestFinish(event)
+		final.Counts.Increment(status)
+	}
+	final.IncrementStat("some-stat", 2)
+	if budget != nil {
+		final.Budget = budget
+		final.Time = budget.Budget * 2
+	}
+	emitter.SuiteFinish(*final)
+
+	if err := emitter.End(nil); err != nil {
+		t.Fatal(err)
+	}
+
+	return path
+}
+
+func TestMerge(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := []string{
+		writeShard(t, dir, 1, 0, map[tapjio.TestFilter]tapjio.Status{"a": tapjio.Pass, "b": tapjio.Fail}, nil),
+		writeShard(t, dir, 2, 1, map[tapjio.TestFilter]tapjio.Status{"b": tapjio.Fail, "c": tapjio.Pass}, nil),
+	}
+
+	var suites []tapjio.SuiteBeginEvent
+	var finals []tapjio.SuiteFinishEvent
+	tests := 0
+	problems, err := Merge(paths, &tapjio.DecodingCallbacks{
+		OnSuiteBegin: func(event tapjio.SuiteBeginEvent) error {
+			suites = append(suites, event)
+			return nil
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			tests++
+			return nil
+		},
+		OnSuiteFinish: func(event tapjio.SuiteFinishEvent) error {
+			finals = append(finals, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(suites) != 1 || suites[0].Count != 5 || suites[0].ShardCount != 0 {
+		t.Fatalf("Expected a single unsharded suite of 5 tests, got %#v", suites)
+	}
+
+	if tests != 4 {
+		t.Fatalf("Expected 4 tests, got %d", tests)
+	}
+
+	if len(finals) != 1 {
+		t.Fatalf("Expected a single final event, got %#v", finals)
+	}
+
+	expected := tapjio.ResultTally{Total: 4, Pass: 2, Fail: 2}
+	if *finals[0].Counts != expected {
+		t.Fatalf("Expected counts %#v, got %#v", expected, *finals[0].Counts)
+	}
+
+	if finals[0].Stats["some-stat"] != 4 {
+		t.Fatalf("Expected stats to be summed, got %#v", finals[0].Stats)
+	}
+
+	messages := strings.Join(problems.Messages, "\n")
+	if !strings.Contains(messages, "Missing shard 3/3") {
+		t.Fatalf("Expected missing shard to be flagged, got %q", messages)
+	}
+	if !strings.Contains(messages, "Duplicate test b") {
+		t.Fatalf("Expected duplicate test to be flagged, got %q", messages)
+	}
+	if !strings.Contains(messages, "reported 2 of 3 tests") {
+		t.Fatalf("Expected missing test to be flagged, got %q", messages)
+	}
+}
+
+func TestMergeSuiteBudget(t *testing.T) {
+	dir, err := ioutil.TempDir("", "merge")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	paths := []string{
+		writeShard(t, dir, 1, 0, map[tapjio.TestFilter]tapjio.Status{"a": tapjio.Pass}, &tapjio.BudgetOverrun{Budget: 60, Warning: true}),
+		writeShard(t, dir, 2, 0, map[tapjio.TestFilter]tapjio.Status{"b": tapjio.Pass}, &tapjio.BudgetOverrun{Budget: 10}),
+		writeShard(t, dir, 3, 0, map[tapjio.TestFilter]tapjio.Status{"c": tapjio.Pass}, &tapjio.BudgetOverrun{Budget: 20}),
+	}
+
+	var finals []tapjio.SuiteFinishEvent
+	_, err = Merge(paths, &tapjio.DecodingCallbacks{
+		OnSuiteFinish: func(event tapjio.SuiteFinishEvent) error {
+			finals = append(finals, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(finals) != 1 || finals[0].Budget == nil || finals[0].Budget.Budget != 20 || finals[0].Budget.Warning {
+		t.Fatalf("Expected the slowest shard's failing overrun, got %#v", finals)
+	}
+	if finals[0].Passed() {
+		t.Fatalf("Expected the merged suite to fail for going over budget")
+	}
+}
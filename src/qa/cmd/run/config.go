@@ -0,0 +1,310 @@
+package run
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// ProjectConfigName is the name of the file that qa looks for in the current directory and
+// its parents. It looks like:
+//
+//	{
+//	  "runners": ["rspec", "minitest:test/**/*_test.rb"],
+//	  "flags": {"jobs": 8, "archive": "tmp/qa", "warmup": false},
+//	  "profiles": {
+//	    "ci": {"flags": {"jobs": 16, "worker-env": ["PORT={{10000+worker}}"]}}
+//	  }
+//	}
+//
+// Keys in "flags" are the names of command-line flags. Flags given on the command line
+// override the selected profile, which overrides the top-level settings. Relative paths in the
+// file, including the patterns of its runners, are relative to the directory it's in.
+const ProjectConfigName = ".qa.json"
+
+// projectPathFlags are the flags whose values are paths, which a project config file gives
+// relative to its own directory.
+var projectPathFlags = map[string]bool{
+	"archive":          true,
+	"audit-dir":        true,
+	"chdir":            true,
+	"heapdump":         true,
+	"memprofile":       true,
+	"save-flamegraph":  true,
+	"save-html":        true,
+	"save-icegraph":    true,
+	"save-junit":       true,
+	"save-markdown":    true,
+	"save-palette":     true,
+	"save-stacktraces": true,
+	"save-tapj":        true,
+	"save-trace":       true,
+	"shard-timings":    true,
+	"worker-env-file":  true,
+}
+
+type projectProfile struct {
+	Runners []string               `json:"runners,omitempty"`
+	Flags   map[string]interface{} `json:"flags,omitempty"`
+}
+
+type projectConfig struct {
+	projectProfile
+	Profiles map[string]*projectProfile `json:"profiles,omitempty"`
+}
+
+// findProjectConfig returns the path of the closest project config file at or above dir,
+// or the empty string if there isn't one.
+func findProjectConfig(dir string) (string, error) {
+	if dir == "" {
+		var err error
+		dir, err = os.Getwd()
+		if err != nil {
+			return "", err
+		}
+	}
+
+	dir, err := filepath.Abs(dir)
+	if err != nil {
+		return "", err
+	}
+
+	for {
+		path := filepath.Join(dir, ProjectConfigName)
+		if _, err := os.Stat(path); err == nil {
+			return path, nil
+		} else if !os.IsNotExist(err) {
+			return "", err
+		}
+
+		parent := filepath.Dir(dir)
+		if parent == dir {
+			return "", nil
+		}
+		dir = parent
+	}
+}
+
+func readProjectConfig(path string) (*projectConfig, error) {
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	config := &projectConfig{}
+	err = json.Unmarshal(b, config)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+
+	return config, nil
+}
+
+// flagValues converts a JSON value from a project config file into the strings that would
+// be given on the command line. Lists are used for flags that may be given more than once.
+func flagValues(value interface{}) ([]string, error) {
+	switch v := value.(type) {
+	case string:
+		return []string{v}, nil
+	case bool:
+		return []string{strconv.FormatBool(v)}, nil
+	case float64:
+		return []string{strconv.FormatFloat(v, 'f', -1, 64)}, nil
+	case []interface{}:
+		var values []string
+		for _, element := range v {
+			elementValues, err := flagValues(element)
+			if err != nil {
+				return nil, err
+			}
+			values = append(values, elementValues...)
+		}
+		return values, nil
+	}
+
+	return nil, fmt.Errorf("unsupported value %v", value)
+}
+
+// applyProjectFlags sets each flag in values that wasn't already given on the command line.
+func applyProjectFlags(flags *flag.FlagSet, values map[string]interface{}, source string, dir string, given map[string]bool, sources map[string]string) error {
+	var names []string
+	for name := range values {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		if flags.Lookup(name) == nil || name == "config" || name == "profile" {
+			return fmt.Errorf("%s: unknown flag %s", source, name)
+		}
+
+		if given[name] {
+			continue
+		}
+
+		strs, err := flagValues(values[name])
+		if err != nil {
+			return fmt.Errorf("%s: flag %s: %v", source, name, err)
+		}
+
+		for _, s := range strs {
+			if projectPathFlags[name] && s != "" && !filepath.IsAbs(s) {
+				s = filepath.Join(dir, s)
+			}
+
+			err = flags.Set(name, s)
+			if err != nil {
+				return fmt.Errorf("%s: flag %s: %v", source, name, err)
+			}
+		}
+		sources[name] = source
+	}
+
+	return nil
+}
+
+// Parse parses the command-line arguments, then fills in any flags and runners they don't
+// mention from the project config file.
+func (f *runFlags) Parse(flags *flag.FlagSet, dir string, args []string) error {
+	err := flags.Parse(args)
+	if err != nil {
+		return err
+	}
+
+	given := make(map[string]bool)
+	flags.Visit(func(fl *flag.Flag) {
+		given[fl.Name] = true
+		f.sources[fl.Name] = "command line"
+	})
+
+	path := *f.config
+	if path == "" {
+		path, err = findProjectConfig(dir)
+		if err != nil {
+			return err
+		}
+	} else if !filepath.IsAbs(path) {
+		path, err = filepath.Abs(filepath.Join(dir, path))
+		if err != nil {
+			return err
+		}
+	}
+
+	if path == "" {
+		if *f.profile != "" {
+			return errors.New("No " + ProjectConfigName + " found for profile " + *f.profile)
+		}
+		return nil
+	}
+
+	config, err := readProjectConfig(path)
+	if err != nil {
+		return err
+	}
+	f.configPath = path
+
+	profiles := []*projectProfile{&config.projectProfile}
+	sources := []string{path}
+	if *f.profile != "" {
+		profile, ok := config.Profiles[*f.profile]
+		if !ok {
+			return fmt.Errorf("%s: no profile named %s", path, *f.profile)
+		}
+		profiles = append([]*projectProfile{profile}, profiles...)
+		sources = append([]string{path + " (profile " + *f.profile + ")"}, sources...)
+	}
+
+	for ix, profile := range profiles {
+		if f.configRunners == nil && len(profile.Runners) > 0 {
+			f.configRunners = profile.Runners
+			f.sources["runners"] = sources[ix]
+		}
+
+		err = applyProjectFlags(flags, profile.Flags, sources[ix], filepath.Dir(path), given, f.sources)
+		if err != nil {
+			return err
+		}
+
+		// Less specific settings neither override these nor add to them.
+		for name := range profile.Flags {
+			given[name] = true
+		}
+	}
+
+	return nil
+}
+
+// GivenOnCommandLine returns whether the named flag was given on the command line, rather than
+// set by the project config file or left as is.
+func (f *runFlags) GivenOnCommandLine(name string) bool {
+	return f.sources[name] == "command line"
+}
+
+// RunnerSpecs returns the runner specs given on the command line, or those from the project
+// config file if there weren't any. Runners from the config file are run from its directory.
+func (f *runFlags) RunnerSpecs(args []string) []string {
+	if len(args) > 0 {
+		f.sources["runners"] = "command line"
+		return args
+	}
+
+	if len(f.configRunners) > 0 {
+		f.runnersDir = filepath.Dir(f.configPath)
+	}
+
+	return f.configRunners
+}
+
+// FrameworkPatterns returns the patterns given on the command line, or those for the named
+// framework in the project config file if there weren't any. Patterns from the config file
+// are run from its directory.
+func (f *runFlags) FrameworkPatterns(frameworkName string, args []string) []string {
+	if len(args) > 0 {
+		return args
+	}
+
+	var patterns []string
+	for _, runnerSpec := range f.configRunners {
+		split := strings.Split(runnerSpec, ":")
+		if split[0] == frameworkName {
+			patterns = append(patterns, split[1:]...)
+		}
+	}
+
+	if len(patterns) > 0 {
+		f.runnersDir = filepath.Dir(f.configPath)
+	}
+
+	return patterns
+}
+
+// DescribeConfig returns the effective value of every flag, along with where it came from.
+func (f *runFlags) DescribeConfig(flags *flag.FlagSet, runnerSpecs []string) string {
+	var lines []string
+	if f.configPath == "" {
+		lines = append(lines, "# No "+ProjectConfigName+" found")
+	} else {
+		lines = append(lines, "# Using "+f.configPath)
+	}
+
+	describe := func(setting, value, source string) {
+		if source == "" {
+			source = "default"
+		}
+		lines = append(lines, fmt.Sprintf("%-40s # %s", setting+"="+value, source))
+	}
+
+	describe("runners", strings.Join(runnerSpecs, " "), f.sources["runners"])
+	flags.VisitAll(func(fl *flag.Flag) {
+		describe("-"+fl.Name, fl.Value.String(), f.sources[fl.Name])
+	})
+
+	return strings.Join(lines, "\n") + "\n"
+}
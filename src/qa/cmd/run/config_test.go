@@ -0,0 +1,98 @@
+package run
+
+import (
+	"flag"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"qa/cmd"
+)
+
+const testProjectConfig = `{
+  "runners": ["rspec", "minitest:test/**/*_test.rb"],
+  "flags": {"jobs": 3, "warmup": false, "squash": "none", "worker-env": ["A={{worker}}"], "archive": "tmp/qa"},
+  "profiles": {
+    "ci": {"runners": ["test-unit"], "flags": {"jobs": 16, "worker-env": ["B={{worker+1}}"]}}
+  }
+}`
+
+func parseWithProjectConfig(t *testing.T, dir string, args ...string) (*runFlags, *flag.FlagSet) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	f := DefineFlags(nil, flags)
+	err := f.Parse(flags, dir, args)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return f, flags
+}
+
+func TestProjectConfig(t *testing.T) {
+	root, err := ioutil.TempDir("", "config")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(root)
+
+	err = ioutil.WriteFile(filepath.Join(root, ProjectConfigName), []byte(testProjectConfig), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	dir := filepath.Join(root, "a", "b")
+	err = os.MkdirAll(dir, 0755)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	f, flags := parseWithProjectConfig(t, dir, "-squash", "file")
+	if *f.executionFlags.jobs != 3 || *f.executionFlags.warmup {
+		t.Fatalf("Expected settings from %s, got jobs=%d warmup=%v", ProjectConfigName, *f.executionFlags.jobs, *f.executionFlags.warmup)
+	}
+	if flags.Lookup("squash").Value.String() != "file" {
+		t.Fatalf("Expected command line to override config, got squash=%s", flags.Lookup("squash").Value)
+	}
+	if !f.GivenOnCommandLine("squash") || f.GivenOnCommandLine("jobs") {
+		t.Fatalf("Expected only squash to be given on the command line, got sources %v", f.sources)
+	}
+	if specs := f.RunnerSpecs(flags.Args()); !reflect.DeepEqual(specs, []string{"rspec", "minitest:test/**/*_test.rb"}) {
+		t.Fatalf("Expected runners from config, got %v", specs)
+	}
+	if patterns := f.FrameworkPatterns("minitest", nil); !reflect.DeepEqual(patterns, []string{"test/**/*_test.rb"}) {
+		t.Fatalf("Expected minitest patterns from config, got %v", patterns)
+	}
+
+	// Paths in the config are relative to its directory, not the one qa runs in.
+	if archive := *f.outputFlags.archiveBaseDir; archive != filepath.Join(root, "tmp", "qa") {
+		t.Fatalf("Expected archive relative to %s, got %s", ProjectConfigName, archive)
+	}
+	env := &cmd.Env{Dir: dir}
+	if configs := f.ParseRunnerConfigs(env, f.RunnerSpecs(nil)); configs[0].Dir != root || configs[0].FileLister.Dir() != root {
+		t.Fatalf("Expected runners from config to run from %s, got %s", root, configs[0].Dir)
+	}
+
+	f, _ = parseWithProjectConfig(t, dir, "-config", filepath.Join("..", "..", ProjectConfigName), "-jobs", "2")
+	if f.configPath != filepath.Join(root, ProjectConfigName) || *f.outputFlags.archiveBaseDir != filepath.Join(root, "tmp", "qa") {
+		t.Fatalf("Expected -config relative to %s, got %s", dir, f.configPath)
+	}
+
+	f, flags = parseWithProjectConfig(t, dir, "-profile", "ci", "rspec:spec/a_spec.rb")
+	if *f.executionFlags.jobs != 16 {
+		t.Fatalf("Expected profile to override config, got jobs=%d", *f.executionFlags.jobs)
+	}
+	if env := f.executionFlags.WorkerEnvs()[2]; env["B"] != "3" || env["A"] != "" {
+		t.Fatalf("Expected worker env from profile only, got %v", env)
+	}
+	if specs := f.RunnerSpecs(flags.Args()); !reflect.DeepEqual(specs, []string{"rspec:spec/a_spec.rb"}) {
+		t.Fatalf("Expected runners from command line, got %v", specs)
+	}
+
+	flags = flag.NewFlagSet("test", flag.ContinueOnError)
+	f = DefineFlags(nil, flags)
+	if err := f.Parse(flags, dir, []string{"-profile", "nope"}); err == nil {
+		t.Fatal("Expected an error for an unknown profile")
+	}
+}
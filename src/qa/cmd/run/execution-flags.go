@@ -5,7 +5,10 @@ import (
 	"flag"
 	"fmt"
 	"runtime"
+	"sort"
+	"strconv"
 	"strings"
+	"time"
 
 	"qa/cmd"
 	"qa/run"
@@ -34,6 +37,19 @@ type executionFlags struct {
 	warmup              *bool
 	eagerLoad           *bool
 	seed                *int
+	scheduleHistoryDays *int
+	failFast            *int
+	retries             *int
+	testTimeout         *time.Duration
+	runnerTimeout       *time.Duration
+	shard               *shardValue
+	shardTimings        *string
+	workerMaxRSS        *int64
+	workerEnvTemplates  *[]*workerEnvTemplate
+	runnerCommands      map[string][]string
+	budgets             *[]*runner.Budget
+	suiteBudget         *time.Duration
+	budgetWarn          *bool
 }
 
 type squashPolicyValue struct {
@@ -72,16 +88,220 @@ func (v *squashPolicyValue) Set(s string) error {
 	return nil
 }
 
+// failFastValue is a number of failures that may also be given like a boolean flag, where
+// -fail-fast means -fail-fast=1.
+type failFastValue struct {
+	value *int
+}
+
+func (v *failFastValue) IsBoolFlag() bool {
+	return true
+}
+
+func (v *failFastValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+
+	return strconv.Itoa(*v.value)
+}
+
+func (v *failFastValue) Set(s string) error {
+	switch s {
+	case "true":
+		*v.value = 1
+	case "false":
+		*v.value = 0
+	default:
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 {
+			return errors.New("Invalid fail-fast count: " + s)
+		}
+		*v.value = n
+	}
+
+	return nil
+}
+
+// shardValue is a 1-based shard index and a shard count, given as e.g. 3/8.
+type shardValue struct {
+	index int
+	count int
+}
+
+func (v *shardValue) String() string {
+	if v.count == 0 {
+		return ""
+	}
+
+	return fmt.Sprintf("%d/%d", v.index, v.count)
+}
+
+func (v *shardValue) Set(s string) error {
+	split := strings.Split(s, "/")
+	if len(split) != 2 {
+		return errors.New("Invalid shard, expected i/n: " + s)
+	}
+
+	index, err := strconv.Atoi(split[0])
+	if err != nil {
+		return errors.New("Invalid shard, expected i/n: " + s)
+	}
+
+	count, err := strconv.Atoi(split[1])
+	if err != nil {
+		return errors.New("Invalid shard, expected i/n: " + s)
+	}
+
+	if count < 1 || index < 1 || index > count {
+		return errors.New("Invalid shard, expected 1 <= i <= n: " + s)
+	}
+
+	v.index = index
+	v.count = count
+	return nil
+}
+
+// runnerCommandValue maps runner names to the commands of external runners, each given as
+// name=command. The command is split on whitespace, and may include leading arguments.
+type runnerCommandValue struct {
+	commands map[string][]string
+}
+
+func (v *runnerCommandValue) String() string {
+	var specs []string
+	for name, command := range v.commands {
+		specs = append(specs, name+"="+strings.Join(command, " "))
+	}
+	sort.Strings(specs)
+
+	return strings.Join(specs, " ")
+}
+
+func (v *runnerCommandValue) Set(s string) error {
+	split := strings.SplitN(s, "=", 2)
+	if len(split) != 2 || strings.TrimSpace(split[0]) == "" || len(strings.Fields(split[1])) == 0 {
+		return errors.New("Invalid runner command, expected name=command: " + s)
+	}
+
+	v.commands[strings.TrimSpace(split[0])] = strings.Fields(split[1])
+	return nil
+}
+
+// budgetValue collects the duration budgets of tests, each given as pattern=duration.
+type budgetValue struct {
+	budgets *[]*runner.Budget
+}
+
+func (v *budgetValue) String() string {
+	if v.budgets == nil {
+		return ""
+	}
+
+	var specs []string
+	for _, budget := range *v.budgets {
+		specs = append(specs, budget.Pattern+"="+budget.Duration.String())
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func (v *budgetValue) Set(s string) error {
+	split := strings.LastIndex(s, "=")
+	if split <= 0 {
+		return errors.New("Invalid budget, expected pattern=duration: " + s)
+	}
+
+	duration, err := time.ParseDuration(s[split+1:])
+	if err != nil || duration <= 0 {
+		return errors.New("Invalid budget, expected pattern=duration: " + s)
+	}
+
+	budget, err := runner.NewBudget(s[:split], duration)
+	if err != nil {
+		return errors.New("Invalid budget " + s + ": " + err.Error())
+	}
+
+	*v.budgets = append(*v.budgets, budget)
+	return nil
+}
+
+// byteSizeValue is a number of bytes, optionally given with a K, M, or G suffix.
+type byteSizeValue struct {
+	value *int64
+}
+
+func (v *byteSizeValue) String() string {
+	if v.value == nil {
+		return ""
+	}
+
+	return strconv.FormatInt(*v.value, 10)
+}
+
+func (v *byteSizeValue) Set(s string) error {
+	multiplier := int64(1)
+	number := strings.TrimSuffix(strings.ToUpper(s), "B")
+	switch {
+	case strings.HasSuffix(number, "K"):
+		multiplier = 1 << 10
+	case strings.HasSuffix(number, "M"):
+		multiplier = 1 << 20
+	case strings.HasSuffix(number, "G"):
+		multiplier = 1 << 30
+	}
+	if multiplier != 1 {
+		number = number[:len(number)-1]
+	}
+
+	n, err := strconv.ParseInt(number, 10, 64)
+	if err != nil || n < 0 {
+		return errors.New("Invalid size: " + s)
+	}
+
+	*v.value = n * multiplier
+	return nil
+}
+
 func defineExecutionFlags(vars map[string]string, flags *flag.FlagSet) *executionFlags {
 	squashPolicyValue := &squashPolicyValue{new(runner.SquashPolicy)}
 	*squashPolicyValue.value = runner.SquashByFile
 	flags.Var(squashPolicyValue, "squash", "One of: all, none, file")
 
+	failFastValue := &failFastValue{new(int)}
+	flags.Var(failFastValue, "fail-fast", "Stop after the first failure, or after N failures with -fail-fast=N")
+
+	workerMaxRSSValue := &byteSizeValue{new(int64)}
+	flags.Var(workerMaxRSSValue, "worker-max-rss", "Replace a worker with a fresh one once its resident set size grows past this, e.g. 2G. 0 disables")
+
+	workerEnvValue := &workerEnvValue{new([]*workerEnvTemplate)}
+	flags.Var(workerEnvValue, "worker-env", "Set an environment variable that differs for each worker, e.g. PORT={{10000+worker}}. May be given more than once")
+	flags.Var(&workerEnvFileValue{*workerEnvValue}, "worker-env-file", "Read -worker-env templates from the given file, one NAME=template per line")
+
+	runnerCommandValue := &runnerCommandValue{make(map[string][]string)}
+	flags.Var(runnerCommandValue, "runner-command", "Run tests for the named runner with an external command, e.g. mytool=bin/qa-mytool. May be given more than once")
+
+	shardValue := &shardValue{}
+	flags.Var(shardValue, "shard", "Only run the i-th of n deterministic parts of the suite, e.g. 3/8. Parts are balanced by test count, or by -shard-timings")
+
+	budgetValue := &budgetValue{new([]*runner.Budget)}
+	flags.Var(budgetValue, "budget", "Fail tests matching a file or filter pattern that take longer than a duration, e.g. 'spec/unit/**=200ms'. May be given more than once; the first match applies")
+
 	return &executionFlags{
+		budgets:             budgetValue.budgets,
+		suiteBudget:         flags.Duration("suite-budget", 0, "Fail the run if a suite takes longer than this. 0 disables"),
+		budgetWarn:          flags.Bool("budget-warn", false, "Only warn about tests and suites over their -budget or -suite-budget, rather than failing them"),
+		shard:               shardValue,
+		shardTimings:        flags.String("shard-timings", "", "Balance -shard parts by the test durations in this TAP-J file, e.g. saved by an earlier run. Every shard must be given the same file"),
+		workerMaxRSS:        workerMaxRSSValue.value,
+		workerEnvTemplates:  workerEnvValue.templates,
+		runnerCommands:      runnerCommandValue.commands,
 		seed:                flags.Int("seed", -1, "Set seed to use"),
 		jobs:                flags.Int("jobs", runtime.NumCPU(), "Set number of jobs"),
 		runs:                flags.Int("runs", 1, "Set number of times to run tests"),
+		retries:             flags.Int("retries", 0, "Re-run failing tests in a fresh worker up to N more times. Tests that pass on retry are reported as flaky"),
 		squashPolicy:        squashPolicyValue.value,
+		failFast:            failFastValue.value,
 		listenNetwork:       flags.String("listen-network", "tcp", "Specify unix or tcp socket for worker coordination"),
 		listenAddress:       flags.String("listen-address", "127.0.0.1:0", "Listen address for worker coordination"),
 		debugErrorClass:     flags.String("debug-error-class", "", "Specify which, if any, error class to debug"),
@@ -97,6 +317,9 @@ func defineExecutionFlags(vars map[string]string, flags *flag.FlagSet) *executio
 		warmup:              flags.Bool("warmup", true, "Use a variety of experimental heuristics to warm up worker caches"),
 		filter:              flags.String("filter", "", "Specify a single test filter to run"),
 		eagerLoad:           flags.Bool("eager-load", false, "Use a variety of experimental heuristics to eager load code"),
+		testTimeout:         flags.Duration("test-timeout", 0, "Report a test as an error and move on if it runs longer than this. 0 disables"),
+		runnerTimeout:       flags.Duration("runner-timeout", 0, "Give up on the tests in a file (or other squashed group) if they run longer than this. 0 disables"),
+		scheduleHistoryDays: flags.Int("schedule-history-days", 7, "Order tests, and point out ones slower than they used to be, using durations archived in the last N days, if -archive is given. 0 disables"),
 	}
 }
 
@@ -107,16 +330,33 @@ func (f *executionFlags) Listen() (*server.Server, error) {
 func (f *executionFlags) WorkerEnvs() []map[string]string {
 	workerEnvs := []map[string]string{}
 	for i := 0; i < *f.jobs; i++ {
-		workerEnvs = append(workerEnvs,
-			map[string]string{
-				"QA_WORKER":       fmt.Sprintf("%d", i),
-				"TEST_ENV_NUMBER": fmt.Sprintf("%d", i),
-			})
+		workerEnv := map[string]string{
+			"QA_WORKER":       fmt.Sprintf("%d", i),
+			"TEST_ENV_NUMBER": fmt.Sprintf("%d", i),
+		}
+		for _, template := range *f.workerEnvTemplates {
+			workerEnv[template.name] = template.expand(i)
+		}
+		workerEnvs = append(workerEnvs, workerEnv)
 	}
 
 	return workerEnvs
 }
 
+// Budgets returns the duration budgets given, if any.
+func (f *executionFlags) Budgets(dir string) *runner.Budgets {
+	if len(*f.budgets) == 0 && *f.suiteBudget <= 0 {
+		return nil
+	}
+
+	return &runner.Budgets{
+		Tests:    *f.budgets,
+		Suite:    *f.suiteBudget,
+		WarnOnly: *f.budgetWarn,
+		Dir:      dir,
+	}
+}
+
 func (f *executionFlags) NewRunnerConfig(env *cmd.Env, runnerName string, patterns []string) runner.Config {
 	var filters []tapjio.TestFilter
 	if *f.filter != "" {
@@ -140,7 +380,11 @@ func (f *executionFlags) NewRunnerConfig(env *cmd.Env, runnerName string, patter
 		// "ActiveRecord::ConnectionAdapters::SchemaCache#clear!",
 		// "ActiveRecord::ConnectionAdapters::SchemaCache#clear_table_cache!",
 		},
-		Filters: filters,
+		Filters:       filters,
+		TestTimeout:   *f.testTimeout,
+		RunnerTimeout: *f.runnerTimeout,
+		WorkerMaxRSS:  *f.workerMaxRSS,
+		Command:       f.runnerCommands[runnerName],
 		PassthroughConfig: map[string](interface{}){
 			"eagerLoad":           *f.eagerLoad,
 			"warmup":              *f.warmup,
@@ -163,7 +407,10 @@ func (f *executionFlags) ParseRunnerConfigs(env *cmd.Env, runnerSpecs []string)
 		runnerName := runnerSpecSplit[0]
 		var patterns []string
 		if len(runnerSpecSplit) == 1 {
-			patterns = []string{run.DefaultGlob(runnerName)}
+			// External runners have no default, so they're given no files to look through.
+			if glob := run.DefaultGlob(runnerName); glob != "" {
+				patterns = []string{glob}
+			}
 		} else {
 			patterns = runnerSpecSplit[1:]
 		}
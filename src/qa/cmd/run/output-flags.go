@@ -6,12 +6,14 @@ import (
 	"fmt"
 	"io/ioutil"
 	"log"
-	"math/rand"
 	"os"
 	"path"
 	"time"
 
+	"qa/analysis"
+	"qa/archive"
 	"qa/cmd"
+	"qa/dashboard"
 	"qa/reporting"
 	"qa/tapjio"
 )
@@ -24,45 +26,22 @@ func maybeJoin(p string, dir string) string {
 	return p
 }
 
-const archiveNonceLexicon = "abcdefghijklmnopqrstuvwxyz"
-const archiveNonceLength = 8
-
-func randomString(r *rand.Rand, lexicon string, length int) string {
-	bytes := make([]byte, length)
-	lexiconLen := len(lexicon)
-	for i := 0; i < length; i++ {
-		bytes[i] = lexicon[r.Intn(lexiconLen)]
-	}
-
-	return string(bytes)
-}
-
-func newArchiveTapjEmitter(archiveBaseDir string) (tapjio.Visitor, error) {
-	now := time.Now()
-	tapjArchiveDir := path.Join(archiveBaseDir, now.Format("2006-01-02"))
-	os.MkdirAll(tapjArchiveDir, 0755)
-	r := rand.New(rand.NewSource(now.UnixNano()))
-	nonce := randomString(r, archiveNonceLexicon, archiveNonceLength)
-
-	tapjArchiveFilePath := path.Join(tapjArchiveDir, fmt.Sprintf("%d-%s.tapj", now.Unix(), nonce))
-	tapjArchiveFile, err := os.Create(tapjArchiveFilePath)
-	if err != nil {
-		return nil, err
-	}
-
-	return tapjio.NewTapjEmitCloser(tapjArchiveFile), nil
-}
-
 type outputFlags struct {
 	archiveBaseDir      *string
 	auditDir            *string
 	quiet               *bool
 	saveTapj            *string
+	saveJunit           *string
+	saveHtml            *string
+	saveMarkdown        *string
+	markdownMaxBytes    *int
+	markdownLinkBase    *string
 	saveTrace           *string
 	saveStacktraces     *string
 	saveFlamegraph      *string
 	saveIcegraph        *string
 	savePalette         *string
+	serveUi             *string
 	format              *string
 	showUpdatingSummary *bool
 	showIndividualTests *bool
@@ -77,12 +56,18 @@ func defineOutputFlags(vars map[string]string, flags *flag.FlagSet) *outputFlags
 		auditDir:            flags.String("audit-dir", "", "Directory to save any generated audits, e.g. TAP-J, JSON, SVG, etc."),
 		quiet:               flags.Bool("quiet", false, "Whether or not to print anything at all"),
 		saveTapj:            flags.String("save-tapj", "", "Path to save TAP-J"),
+		saveJunit:           flags.String("save-junit", "", "Path to save JUnit XML"),
+		saveHtml:            flags.String("save-html", "", "Path to save a self-contained HTML report"),
+		saveMarkdown:        flags.String("save-markdown", "", "Path to save a Markdown summary, e.g. for a pull request comment"),
+		markdownMaxBytes:    flags.Int("markdown-max-bytes", 65000, "Markdown summary leaves out failures beyond this size. 0 for no limit"),
+		markdownLinkBase:    flags.String("markdown-link-base", "", "Markdown summary links failures to files under this URL, e.g. https://github.com/org/repo/blob/<commit>/"),
 		saveTrace:           flags.String("save-trace", "", "Path to save trace JSON"),
 		saveStacktraces:     flags.String("save-stacktraces", "", "Path to save stacktraces.txt, implies -sample-stack"),
 		saveFlamegraph:      flags.String("save-flamegraph", "", "Path to save flamegraph SVG, implies -sample-stack"),
 		saveIcegraph:        flags.String("save-icegraph", "", "Path to save icegraph SVG, implies -sample-stack"),
 		savePalette:         flags.String("save-palette", "palette.map", "Path to save (flame|ice)graph palette"),
-		format:              flags.String("format", "pretty", "Set output format"),
+		serveUi:             flags.String("serve-ui", "", "Address to serve a live dashboard of the run at, e.g. localhost:7358"),
+		format:              flags.String("format", "pretty", "Set output format. One of: pretty, tapj, junit, markdown, annotations"),
 		showUpdatingSummary: flags.Bool("pretty-overwrite", true, "Pretty reporter shows live updating summary. Forces -pretty-quite-pass=false, -pretty-quiet-omit=false"),
 		showIndividualTests: flags.Bool("pretty-show-individual-tests", true, "Pretty reporter shows output for individual tests"),
 		elidePass:           flags.Bool("pretty-quiet-pass", true, "Pretty reporter elides passing tests without (std)output"),
@@ -91,8 +76,13 @@ func defineOutputFlags(vars map[string]string, flags *flag.FlagSet) *outputFlags
 	}
 }
 
-func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds bool, svgTitleSuffix string) (tapjio.Visitor, error) {
+// newVisitor returns a visitor for every output asked for. Given the archived durations of
+// earlier runs, pretty output points out tests that have become slower than they used to be.
+func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds bool, svgTitleSuffix string, history *analysis.DurationHistory) (tapjio.Visitor, error) {
 	saveTapj := *f.saveTapj
+	saveJunit := *f.saveJunit
+	saveHtml := *f.saveHtml
+	saveMarkdown := *f.saveMarkdown
 	saveTrace := *f.saveTrace
 	saveStacktraces := *f.saveStacktraces
 	saveFlamegraph := *f.saveFlamegraph
@@ -106,6 +96,9 @@ func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds
 		os.MkdirAll(auditDir, 0755)
 
 		saveTapj = maybeJoin(saveTapj, auditDir)
+		saveJunit = maybeJoin(saveJunit, auditDir)
+		saveHtml = maybeJoin(saveHtml, auditDir)
+		saveMarkdown = maybeJoin(saveMarkdown, auditDir)
 		saveTrace = maybeJoin(saveTrace, auditDir)
 		saveStacktraces = maybeJoin(saveStacktraces, auditDir)
 		saveFlamegraph = maybeJoin(saveFlamegraph, auditDir)
@@ -120,6 +113,15 @@ func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds
 		switch *f.format {
 		case "tapj":
 			visitors = append(visitors, tapjio.NewTapjEmitter(env.Stdout))
+		case "junit":
+			visitors = append(visitors, tapjio.NewJunitEmitter(env.Stdout))
+		case "markdown":
+			markdown := reporting.NewMarkdownEmitter(env.Stdout)
+			markdown.MaxBytes = *f.markdownMaxBytes
+			markdown.LinkBase = *f.markdownLinkBase
+			visitors = append(visitors, markdown)
+		case "annotations":
+			visitors = append(visitors, reporting.NewAnnotations(env.Stdout))
 		case "pretty":
 			pretty := reporting.NewPretty(env.Stdout, jobs, runs, varyingSeeds)
 			pretty.ShowIndividualTests = *f.showIndividualTests
@@ -136,6 +138,9 @@ func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds
 				pretty.ElideQuietPass = false
 				pretty.ElideQuietOmit = false
 			}
+			if history != nil && history.Len() > 0 {
+				pretty.Regressions = analysis.NewRegressionDetector(history)
+			}
 			visitors = append(visitors, pretty)
 		default:
 			return nil, errors.New(fmt.Sprintf("Unknown format: %v", *f.format))
@@ -150,10 +155,46 @@ func (f *outputFlags) newVisitor(env *cmd.Env, jobs int, runs int, varyingSeeds
 		visitors = append(visitors, tapjio.NewTapjEmitCloser(tapjFile))
 	}
 
+	if saveJunit != "" {
+		junitFile, err := os.Create(saveJunit)
+		if err != nil {
+			return nil, err
+		}
+		visitors = append(visitors, tapjio.NewJunitEmitCloser(junitFile))
+	}
+
+	if saveHtml != "" {
+		htmlFile, err := os.Create(saveHtml)
+		if err != nil {
+			return nil, err
+		}
+		visitors = append(visitors, reporting.NewHtmlEmitCloser(htmlFile))
+	}
+
+	if saveMarkdown != "" {
+		markdownFile, err := os.Create(saveMarkdown)
+		if err != nil {
+			return nil, err
+		}
+		markdown := reporting.NewMarkdownEmitCloser(markdownFile)
+		markdown.MaxBytes = *f.markdownMaxBytes
+		markdown.LinkBase = *f.markdownLinkBase
+		visitors = append(visitors, markdown)
+	}
+
+	if *f.serveUi != "" {
+		ui, err := dashboard.Listen(*f.serveUi)
+		if err != nil {
+			return nil, err
+		}
+		fmt.Fprintf(env.Stderr, "Watch the run at %s\n", ui.URL())
+		visitors = append(visitors, ui)
+	}
+
 	archiveBaseDir := *f.archiveBaseDir
 	if archiveBaseDir != "" {
 		archiveBaseDir = maybeJoin(archiveBaseDir, env.Dir)
-		visitor, err := newArchiveTapjEmitter(archiveBaseDir)
+		visitor, err := archive.NewEmitter(archiveBaseDir, time.Now())
 		if err != nil {
 			return nil, err
 		}
@@ -0,0 +1,161 @@
+package run
+
+import (
+	"bytes"
+	"flag"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+// Usage:
+//     report [-format pretty|tapj|junit|markdown|annotations] [-save-html report.html] run.tapj...
+//
+// Replays saved or archived TAP-J through the same reporters a run uses, so e.g. a CI artifact
+// can be read with pretty output, snippets, locals and all. TAP-J is read from the files given,
+// or from stdin if there aren't any. Only the files asked for are written when a -save flag (or
+// -html) is given without -format.
+
+// replayedRuns describes the runs in some TAP-J, as newVisitor expects to be told.
+type replayedRuns struct {
+	runs    int
+	workers map[string]bool
+	seeds   map[int]bool
+}
+
+func (self *replayedRuns) visitor() tapjio.Visitor {
+	return &tapjio.DecodingCallbacks{
+		OnSuiteBegin: func(event tapjio.SuiteBeginEvent) error {
+			self.runs++
+			self.seeds[event.Seed] = true
+			return nil
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			if event.Worker != "" {
+				self.workers[event.Worker] = true
+			}
+			return nil
+		},
+	}
+}
+
+func (self *replayedRuns) jobs() int {
+	if len(self.workers) == 0 {
+		return 1
+	}
+
+	return len(self.workers)
+}
+
+// forwardTo passes every event but End along to visitor, so several streams can be replayed as
+// one.
+func forwardTo(visitor tapjio.Visitor) tapjio.Visitor {
+	return &tapjio.DecodingCallbacks{
+		OnSuiteBegin:  visitor.SuiteBegin,
+		OnTestBegin:   visitor.TestBegin,
+		OnTestFinish:  visitor.TestFinish,
+		OnTrace:       visitor.TraceEvent,
+		OnAwaitAttach: visitor.AwaitAttach,
+		OnSuiteFinish: visitor.SuiteFinish,
+	}
+}
+
+func Report(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	// A replay usually comes from an archive already, so it's only archived when asked.
+	f := defineOutputFlags(nil, flags)
+	html := flags.String("html", "", "Path to save a self-contained HTML report, same as -save-html")
+
+	err := flags.Parse(argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if *html != "" {
+		*f.saveHtml = maybeJoin(*html, env.Dir)
+	}
+
+	formatGiven := false
+	saving := *html != ""
+	flags.Visit(func(given *flag.Flag) {
+		if given.Name == "format" {
+			formatGiven = true
+		}
+		if strings.HasPrefix(given.Name, "save-") && given.Name != "save-palette" {
+			saving = true
+		}
+	})
+	if saving && !formatGiven {
+		*f.quiet = true
+	}
+
+	// Each stream is read twice: once to learn how many runs and jobs there were, and again to
+	// report on it.
+	var streams [][]byte
+	var paths []string
+	if flags.NArg() == 0 {
+		b, err := ioutil.ReadAll(env.Stdin)
+		if err != nil {
+			return err
+		}
+		streams = append(streams, b)
+	}
+	for _, path := range flags.Args() {
+		if !filepath.IsAbs(path) {
+			path = filepath.Join(env.Dir, path)
+		}
+		paths = append(paths, path)
+	}
+
+	open := func(ix int) (io.ReadCloser, error) {
+		if streams != nil {
+			return ioutil.NopCloser(bytes.NewReader(streams[ix])), nil
+		}
+		return os.Open(paths[ix])
+	}
+	count := len(streams) + len(paths)
+
+	runs := &replayedRuns{workers: make(map[string]bool), seeds: make(map[int]bool)}
+	for ix := 0; ix < count; ix++ {
+		reader, err := open(ix)
+		if err != nil {
+			return err
+		}
+		err = tapjio.DecodeReader(reader, runs.visitor())
+		reader.Close()
+		if err != nil {
+			return err
+		}
+	}
+
+	visitor, err := f.newVisitor(env, runs.jobs(), runs.runs, len(runs.seeds) > 1, "", nil)
+	if err != nil {
+		return err
+	}
+
+	for ix := 0; ix < count; ix++ {
+		var reader io.ReadCloser
+		reader, err = open(ix)
+		if err != nil {
+			break
+		}
+		err = tapjio.DecodeReader(reader, forwardTo(visitor))
+		reader.Close()
+		if err != nil {
+			break
+		}
+	}
+
+	endErr := visitor.End(err)
+	if err == nil {
+		err = endErr
+	}
+
+	return err
+}
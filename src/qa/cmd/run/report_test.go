@@ -0,0 +1,107 @@
+package run
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+func TestReport(t *testing.T) {
+	dir, err := ioutil.TempDir("", "report")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	exception := &tapjio.TestException{
+		Class:   "RuntimeError",
+		Message: "expected <b>",
+		Backtrace: []tapjio.BacktraceLocation{
+			{File: "test/math_test.rb", Line: 5, Method: "test_adds", Variables: map[string]string{"sum": "3"}},
+		},
+		Snippets: map[string]map[string]string{"test/math_test.rb": {"5": "assert_equal 2, sum"}},
+	}
+
+	tapj := &bytes.Buffer{}
+	emitter := tapjio.NewTapjEmitter(tapj)
+	suite := tapjio.NewSuiteBeginEvent(time.Now(), 3, 1)
+	suite.Label = "nightly"
+	emitter.SuiteBegin(*suite)
+	final := tapjio.NewSuiteFinishEvent(suite)
+	for _, event := range []tapjio.TestFinishEvent{
+		{Type: "test", Label: "test_adds", Status: tapjio.Error, Time: 0.5, Exception: exception, Stdout: "adding"},
+		{Type: "test", Label: "test_adds_again", Status: tapjio.Error, Time: 0.25, Exception: exception},
+		{Type: "test", Label: "test_subtracts", Status: tapjio.Pass, Time: 1.5},
+	} {
+		emitter.TestFinish(event)
+		final.Counts.IncrementFor(event)
+	}
+	emitter.SuiteFinish(*final)
+	emitter.End(nil)
+
+	err = ioutil.WriteFile(filepath.Join(dir, "run.tapj"), tapj.Bytes(), 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	stdout := &bytes.Buffer{}
+	env := &cmd.Env{Dir: dir, Stdout: stdout, Stderr: &bytes.Buffer{}}
+	err = Report(env, []string{"report", "-html", "report.html", "run.tapj"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	b, err := ioutil.ReadFile(filepath.Join(dir, "report.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html := string(b)
+
+	for _, expected := range []string{
+		"<title>qa: nightly</title>",
+		"2 &times; RuntimeError: </span>expected &lt;b&gt;",
+		"test/math_test.rb:5 in test_adds",
+		"<td>sum</td><td>= 3</td>",
+		`<span class="focused">   5  assert_equal 2, sum</span>`,
+		"<pre>adding</pre>",
+		`data-value="1.5"`,
+	} {
+		if !strings.Contains(html, expected) {
+			t.Fatalf("Expected report to contain %q:\n%s", expected, html)
+		}
+	}
+	if strings.Contains(html, "<script src") || strings.Contains(html, "<link") {
+		t.Fatalf("Expected report to have no external assets:\n%s", html)
+	}
+	if stdout.Len() > 0 {
+		t.Fatalf("Expected nothing else to be written without -format, got:\n%s", stdout.String())
+	}
+
+	// The same TAP-J can be replayed with any other reporter.
+	err = Report(env, []string{"report", "-format", "pretty", "-pretty-overwrite=false", filepath.Join(dir, "run.tapj")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{"Will run 3 tests using 1 job", "sum = 3", "assert_equal 2, sum", "Ran 3 tests"} {
+		if !strings.Contains(stdout.String(), expected) {
+			t.Fatalf("Expected pretty output to contain %q:\n%s", expected, stdout.String())
+		}
+	}
+
+	stdout.Reset()
+	env.Stdin = bytes.NewReader(tapj.Bytes())
+	err = Report(env, []string{"report", "-format", "markdown"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(stdout.String(), "### ❌ nightly: 1 pass, 2 errors (3 tests") {
+		t.Fatalf("Expected a Markdown summary of stdin, got:\n%s", stdout.String())
+	}
+}
@@ -6,10 +6,12 @@ import (
 	"fmt"
 	"math/big"
 	"path/filepath"
+	"qa/analysis"
 	"qa/cmd"
 	"qa/debug"
 	"qa/run"
 	"qa/runner"
+	"qa/runner/server"
 	"qa/tapjio"
 )
 
@@ -24,6 +26,15 @@ type runFlags struct {
 
 	memprofile *string
 	heapdump   *string
+
+	config        *string
+	profile       *string
+	configPath    string
+	configRunners []string
+	sources       map[string]string
+
+	// If set, the directory to run runners from, since they came from the project config file.
+	runnersDir string
 }
 
 func DefineFlags(vars map[string]string, flags *flag.FlagSet) *runFlags {
@@ -36,6 +47,9 @@ func DefineFlags(vars map[string]string, flags *flag.FlagSet) *runFlags {
 		watch:          flags.Bool("watch", false, "Watch test files for changes and continuously re-run tests"),
 		memprofile:     flags.String("memprofile", "", "write memory profile to `file`"),
 		heapdump:       flags.String("heapdump", "", "write heap dump to `file`"),
+		config:         flags.String("config", "", "Read settings from the given file, instead of the closest "+ProjectConfigName),
+		profile:        flags.String("profile", "", "Use the named profile from the project config file, e.g. ci"),
+		sources:        make(map[string]string),
 	}
 }
 
@@ -82,12 +96,30 @@ func (f *runFlags) ApplyImpliedDefaults() {
 	}
 }
 
+// Listen starts the server that workers report their results to.
+func (f *runFlags) Listen() (*server.Server, error) {
+	return f.executionFlags.Listen()
+}
+
+func (f *runFlags) WorkerEnvs() []map[string]string {
+	return f.executionFlags.WorkerEnvs()
+}
+
+func (f *runFlags) runnerEnv(env *cmd.Env) *cmd.Env {
+	e := f.cloneAndAdjustEnv(env)
+	if f.runnersDir != "" {
+		e.Dir = f.runnersDir
+	}
+
+	return e
+}
+
 func (f *runFlags) NewRunnerConfig(env *cmd.Env, runnerName string, patterns []string) runner.Config {
-	return f.executionFlags.NewRunnerConfig(f.cloneAndAdjustEnv(env), runnerName, patterns)
+	return f.executionFlags.NewRunnerConfig(f.runnerEnv(env), runnerName, patterns)
 }
 
 func (f *runFlags) ParseRunnerConfigs(env *cmd.Env, runnerSpecs []string) []runner.Config {
-	return f.executionFlags.ParseRunnerConfigs(f.cloneAndAdjustEnv(env), runnerSpecs)
+	return f.executionFlags.ParseRunnerConfigs(f.runnerEnv(env), runnerSpecs)
 }
 
 func (f *runFlags) NewEnv(env *cmd.Env, runnerConfigs []runner.Config) (*run.Env, error) {
@@ -101,7 +133,34 @@ func (f *runFlags) NewEnv(env *cmd.Env, runnerConfigs []runner.Config) (*run.Env
 		*executionFlags.jobs, runs, runnerConfigs)
 
 	e := f.cloneAndAdjustEnv(env)
-	visitor, err := outputFlags.newVisitor(e, *executionFlags.jobs, runs, varyingSeeds, svgTitleSuffix)
+
+	var history *analysis.DurationHistory
+	var durationEstimator runner.DurationEstimator
+	historyDays := *executionFlags.scheduleHistoryDays
+	if historyDays > 0 && *outputFlags.archiveBaseDir != "" {
+		var err error
+		history, err = analysis.LoadDurationHistory(maybeJoin(*outputFlags.archiveBaseDir, e.Dir), historyDays)
+		if err != nil {
+			return nil, err
+		}
+
+		if history.Len() > 0 {
+			durationEstimator = history
+		}
+	}
+
+	// Shards can't balance by their own archives, since each one only archives its own tests.
+	var shardDurationEstimator runner.DurationEstimator
+	if *executionFlags.shardTimings != "" {
+		shardHistory, err := analysis.ReadDurationHistory([]string{maybeJoin(*executionFlags.shardTimings, e.Dir)})
+		if err != nil {
+			return nil, err
+		}
+
+		shardDurationEstimator = shardHistory
+	}
+
+	visitor, err := outputFlags.newVisitor(e, *executionFlags.jobs, runs, varyingSeeds, svgTitleSuffix, history)
 	if err != nil {
 		return nil, err
 	}
@@ -161,14 +220,21 @@ func (f *runFlags) NewEnv(env *cmd.Env, runnerConfigs []runner.Config) (*run.Env
 			}
 			return int(bigSeed.Int64())
 		},
-		SuiteLabel:    *f.suiteLabel,
-		SuiteCoderef:  *f.suiteCoderef,
-		Runs:          *executionFlags.runs,
-		Memprofile:    *f.memprofile,
-		Heapdump:      *f.heapdump,
-		WorkerEnvs:    executionFlags.WorkerEnvs(),
-		RunnerConfigs: runnerConfigs,
-		Visitor:       visitor,
-		Server:        srv,
+		SuiteLabel:             *f.suiteLabel,
+		SuiteCoderef:           *f.suiteCoderef,
+		Runs:                   *executionFlags.runs,
+		FailFast:               *executionFlags.failFast,
+		Retries:                *executionFlags.retries,
+		Budgets:                executionFlags.Budgets(f.runnerEnv(env).Dir),
+		Shard:                  executionFlags.shard.index,
+		ShardCount:             executionFlags.shard.count,
+		ShardDurationEstimator: shardDurationEstimator,
+		Memprofile:             *f.memprofile,
+		Heapdump:               *f.heapdump,
+		WorkerEnvs:             executionFlags.WorkerEnvs(),
+		RunnerConfigs:          runnerConfigs,
+		DurationEstimator:      durationEstimator,
+		Visitor:                visitor,
+		Server:                 srv,
 	}, nil
 }
@@ -1,6 +1,7 @@
 package run
 
 import (
+	"errors"
 	"flag"
 	"io"
 	"io/ioutil"
@@ -12,6 +13,7 @@ import (
 	"qa/runner"
 	"qa/tapjio"
 	"qa/watch"
+	"strings"
 	"sync"
 	"syscall"
 )
@@ -149,13 +151,13 @@ func Main(env *cmd.Env, argv []string) error {
 	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
 
 	f := DefineFlags(env.Vars, flags)
-	err := flags.Parse(argv[1:])
+	err := f.Parse(flags, env.Dir, argv[1:])
 	if err != nil {
 		return err
 	}
 
 	f.ApplyImpliedDefaults()
-	runnerConfigs := f.ParseRunnerConfigs(env, flags.Args())
+	runnerConfigs := f.ParseRunnerConfigs(env, f.RunnerSpecs(flags.Args()))
 	runEnv, err := f.NewEnv(env, runnerConfigs)
 	if err != nil {
 		return err
@@ -168,14 +170,14 @@ func FrameworkWithVisitor(frameworkName string, env *cmd.Env, argv []string, vis
 	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
 
 	f := DefineFlags(env.Vars, flags)
-	err := flags.Parse(argv[1:])
+	err := f.Parse(flags, env.Dir, argv[1:])
 	if err != nil {
 		return err
 	}
 
 	f.ApplyImpliedDefaults()
 
-	runnerArgs := flags.Args()
+	runnerArgs := f.FrameworkPatterns(frameworkName, flags.Args())
 	if len(runnerArgs) == 0 {
 		runnerArgs = []string{run.DefaultGlob(frameworkName)}
 	}
@@ -197,3 +199,40 @@ func FrameworkWithVisitor(frameworkName string, env *cmd.Env, argv []string, vis
 func Framework(frameworkName string, env *cmd.Env, argv []string) error {
   return FrameworkWithVisitor(frameworkName, env, argv, nil)
 }
+
+// Tap runs test files with commands that write TAP. Each argument is a command and a glob,
+// like 'bats {file}':test/*.bats.
+func Tap(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	f := DefineFlags(env.Vars, flags)
+	err := f.Parse(flags, env.Dir, argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if flags.NArg() == 0 {
+		return errors.New("Usage: tap [flags] 'command {file}':glob...")
+	}
+
+	f.ApplyImpliedDefaults()
+
+	var runnerConfigs []runner.Config
+	for _, spec := range flags.Args() {
+		split := strings.LastIndex(spec, ":")
+		if split <= 0 || split == len(spec)-1 {
+			return errors.New("Expected 'command {file}':glob, got " + spec)
+		}
+
+		runnerConfig := f.NewRunnerConfig(env, "tap", []string{spec[split+1:]})
+		runnerConfig.TapCommand = spec[:split]
+		runnerConfigs = append(runnerConfigs, runnerConfig)
+	}
+
+	runEnv, err := f.NewEnv(env, runnerConfigs)
+	if err != nil {
+		return err
+	}
+
+	return gogogo(env, runEnv, f.Watch())
+}
@@ -0,0 +1,157 @@
+package run
+
+import (
+	"bufio"
+	"errors"
+	"os"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// workerEnvTemplate is an environment variable whose value depends on which worker it is
+// given to, like PORT={{10000+worker}}. Expressions within {{ }} are sums of integers and
+// worker, optionally multiplied together, e.g. {{worker}}, {{worker+1}}, or {{5432+100*worker}}.
+type workerEnvTemplate struct {
+	spec  string
+	name  string
+	parts []workerEnvPart
+}
+
+// workerEnvPart is either a literal string or the expression scale*worker+offset.
+type workerEnvPart struct {
+	literal    string
+	expression bool
+	scale      int
+	offset     int
+}
+
+var workerEnvExpressionRe = regexp.MustCompile(`{{([^}]*)}}`)
+
+func parseWorkerEnvTemplate(s string) (*workerEnvTemplate, error) {
+	split := strings.SplitN(s, "=", 2)
+	name := strings.TrimSpace(split[0])
+	if len(split) != 2 || name == "" {
+		return nil, errors.New("Invalid worker env, expected NAME=template: " + s)
+	}
+
+	template := &workerEnvTemplate{spec: s, name: name}
+	value := split[1]
+	end := 0
+	for _, match := range workerEnvExpressionRe.FindAllStringSubmatchIndex(value, -1) {
+		part, err := parseWorkerExpression(value[match[2]:match[3]])
+		if err != nil {
+			return nil, errors.New("Invalid worker env " + name + ": " + err.Error())
+		}
+
+		template.parts = append(template.parts, workerEnvPart{literal: value[end:match[0]]}, *part)
+		end = match[1]
+	}
+	template.parts = append(template.parts, workerEnvPart{literal: value[end:]})
+
+	return template, nil
+}
+
+func parseWorkerExpression(expression string) (*workerEnvPart, error) {
+	part := &workerEnvPart{expression: true}
+	for _, term := range strings.Split(expression, "+") {
+		factor := 1
+		workers := 0
+		for _, s := range strings.Split(term, "*") {
+			s = strings.TrimSpace(s)
+			if s == "worker" {
+				workers++
+				continue
+			}
+
+			n, err := strconv.Atoi(s)
+			if err != nil {
+				return nil, errors.New("unsupported expression {{" + expression + "}}")
+			}
+			factor *= n
+		}
+
+		switch workers {
+		case 0:
+			part.offset += factor
+		case 1:
+			part.scale += factor
+		default:
+			return nil, errors.New("unsupported expression {{" + expression + "}}")
+		}
+	}
+
+	return part, nil
+}
+
+func (self *workerEnvTemplate) expand(worker int) string {
+	var values []string
+	for _, part := range self.parts {
+		if part.expression {
+			values = append(values, strconv.Itoa(part.scale*worker+part.offset))
+		} else {
+			values = append(values, part.literal)
+		}
+	}
+
+	return strings.Join(values, "")
+}
+
+// workerEnvValue collects the templates given with repeated -worker-env flags.
+type workerEnvValue struct {
+	templates *[]*workerEnvTemplate
+}
+
+func (v *workerEnvValue) String() string {
+	if v.templates == nil {
+		return ""
+	}
+
+	var specs []string
+	for _, template := range *v.templates {
+		specs = append(specs, template.spec)
+	}
+
+	return strings.Join(specs, " ")
+}
+
+func (v *workerEnvValue) Set(s string) error {
+	template, err := parseWorkerEnvTemplate(s)
+	if err != nil {
+		return err
+	}
+
+	*v.templates = append(*v.templates, template)
+	return nil
+}
+
+// workerEnvFileValue reads templates from a file with one NAME=template per line. Blank lines
+// and lines starting with # are ignored.
+type workerEnvFileValue struct {
+	workerEnvValue
+}
+
+func (v *workerEnvFileValue) Set(path string) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		err = v.workerEnvValue.Set(line)
+		if err != nil {
+			return errors.New(path + ":" + strconv.Itoa(lineNumber) + ": " + err.Error())
+		}
+	}
+
+	return scanner.Err()
+}
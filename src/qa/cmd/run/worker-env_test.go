@@ -0,0 +1,43 @@
+package run
+
+import (
+	"testing"
+)
+
+func TestWorkerEnvTemplate(t *testing.T) {
+	cases := []struct {
+		spec     string
+		name     string
+		expected []string
+	}{
+		{"DATABASE_URL=postgres://localhost/app_test_{{worker}}", "DATABASE_URL",
+			[]string{"postgres://localhost/app_test_0", "postgres://localhost/app_test_2"}},
+		{"PORT={{10000+worker}}", "PORT", []string{"10000", "10002"}},
+		{"REDIS_DB={{ worker + 1 }}", "REDIS_DB", []string{"1", "3"}},
+		{"HOSTS=a:{{100*worker+1}},b:{{100*worker+2}}", "HOSTS", []string{"a:1,b:2", "a:201,b:202"}},
+		{"PLAIN=a=b", "PLAIN", []string{"a=b", "a=b"}},
+	}
+
+	for _, c := range cases {
+		template, err := parseWorkerEnvTemplate(c.spec)
+		if err != nil {
+			t.Fatalf("%s: %v", c.spec, err)
+		}
+
+		if template.name != c.name {
+			t.Fatalf("%s: expected name %s, got %s", c.spec, c.name, template.name)
+		}
+
+		for ix, worker := range []int{0, 2} {
+			if actual := template.expand(worker); actual != c.expected[ix] {
+				t.Fatalf("%s: expected %q for worker %d, got %q", c.spec, c.expected[ix], worker, actual)
+			}
+		}
+	}
+
+	for _, spec := range []string{"PORT", "=1", "PORT={{worker*worker}}", "PORT={{job}}", "PORT={{worker-1}}"} {
+		if _, err := parseWorkerEnvTemplate(spec); err == nil {
+			t.Fatalf("%s: expected an error", spec)
+		}
+	}
+}
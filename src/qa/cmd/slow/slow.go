@@ -0,0 +1,56 @@
+package slow
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"path/filepath"
+
+	"qa/analysis"
+	"qa/cmd"
+	"qa/reporting"
+)
+
+// Usage:
+//     slow [-archive dir] [-days 30] [-recent 3] [-threshold 3.5] [-min-slowdown 1.5]
+//
+// Lists the tests in an archive whose latest passing runs are significantly slower than the ones
+// before them, along with the coderef of the run in which each slowdown began.
+
+func Main(env *cmd.Env, argv []string) error {
+	flags := flag.NewFlagSet(argv[0], flag.ContinueOnError)
+
+	detector := analysis.NewRegressionDetector(nil)
+	archiveDir := flags.String("archive", env.Vars["QA_ARCHIVE"], "Base directory of the archive to look through")
+	days := flags.Int("days", 30, "Look at durations archived in the last N days")
+	flags.IntVar(&detector.RecentSamples, "recent", detector.RecentSamples, "Compare the N latest passing runs of each test to the ones before them")
+	flags.IntVar(&detector.MinBaselineSamples, "min-history", detector.MinBaselineSamples, "Skip tests with fewer than N earlier passing runs")
+	flags.Float64Var(&detector.Threshold, "threshold", detector.Threshold, "How many standard deviations, estimated from the median absolute deviation, over the baseline counts as slower")
+	flags.Float64Var(&detector.MinSlowdown, "min-slowdown", detector.MinSlowdown, "Skip tests less than this many times slower than their baseline")
+	err := flags.Parse(argv[1:])
+	if err != nil {
+		return err
+	}
+
+	if *archiveDir == "" {
+		return errors.New("No archive given. Use -archive or set QA_ARCHIVE")
+	}
+	if !filepath.IsAbs(*archiveDir) {
+		*archiveDir = filepath.Join(env.Dir, *archiveDir)
+	}
+
+	detector.History, err = analysis.LoadDurationHistory(*archiveDir, *days)
+	if err != nil {
+		return err
+	}
+
+	regressions := detector.Regressions()
+	if len(regressions) == 0 {
+		fmt.Fprintf(env.Stdout, "None of the %d archived %s are significantly slower than they used to be.\n",
+			detector.History.Len(), reporting.MaybePlural(detector.History.Len(), "test", "tests"))
+		return nil
+	}
+
+	reporting.NewStyle().SummarizeRegressions(env.Stdout, regressions)
+	return nil
+}
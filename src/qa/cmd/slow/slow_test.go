@@ -0,0 +1,74 @@
+package slow
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"qa/archive"
+	"qa/cmd"
+	"qa/tapjio"
+)
+
+func TestSlow(t *testing.T) {
+	dir, err := ioutil.TempDir("", "slow")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	// One test keeps taking about as long, and another gets much slower in run 7.
+	start := time.Now().Add(-time.Minute)
+	for run := 1; run <= 9; run++ {
+		when := start.Add(time.Duration(run) * time.Second)
+		visitor, err := archive.NewEmitter(dir, when)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		suite := tapjio.NewSuiteBeginEvent(when, 2, 1)
+		suite.Coderef = "c" + string('0'+rune(run))
+		visitor.SuiteBegin(*suite)
+
+		steady := 0.1 + 0.01*float64(run%3)
+		saves := 0.05 + 0.001*float64(run%2)
+		if run >= 7 {
+			saves = 0.9
+		}
+		visitor.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: "test_steady", Filter: "UserTest#test_steady", Status: tapjio.Pass, Time: steady})
+		visitor.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: "test_saves", Filter: "UserTest#test_saves", Status: tapjio.Pass, Time: saves})
+		visitor.SuiteFinish(*tapjio.NewSuiteFinishEvent(suite))
+
+		err = visitor.End(nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	stdout := &bytes.Buffer{}
+	env := &cmd.Env{Dir: dir, Stdout: stdout, Vars: map[string]string{"QA_ARCHIVE": dir}}
+	err = Main(env, []string{"slow"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	output := stdout.String()
+	if !strings.Contains(output, "UserTest#test_saves") || !strings.Contains(output, "since c7, 3 runs in a row") {
+		t.Fatalf("Expected test_saves to have slowed down since c7, got:\n%s", output)
+	}
+	if strings.Contains(output, "test_steady") {
+		t.Fatalf("Expected test_steady not to have slowed down, got:\n%s", output)
+	}
+
+	stdout.Reset()
+	err = Main(env, []string{"slow", "-recent", "1", "-min-history", "9"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(stdout.String(), "None of the 2 archived tests") {
+		t.Fatalf("Expected no tests with enough history, got:\n%s", stdout.String())
+	}
+}
@@ -0,0 +1,289 @@
+package dashboard
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"qa/tapjio"
+)
+
+// How many events may wait to be sent to a page before it's disconnected. Pages reconnect on
+// their own, and are sent everything they missed when they do.
+const clientBacklog = 4096
+
+// How long to keep sending the last events to pages once the run is over.
+const endTimeout = 2 * time.Second
+
+// A Dashboard is a visitor that serves a page for watching a run from a browser. The page is
+// sent the run's events as they happen, using server-sent events. Pages that connect partway
+// through a suite are first sent the events they missed.
+type Dashboard struct {
+	listener net.Listener
+
+	mutex   sync.Mutex
+	history []string
+	clients map[chan string]bool
+	ended   bool
+
+	handlers sync.WaitGroup
+}
+
+type suitePayload struct {
+	Label string `json:"label,omitempty"`
+	Start string `json:"start"`
+	Count int    `json:"count"`
+	Seed  int    `json:"seed"`
+}
+
+type beginPayload struct {
+	Filter string `json:"filter"`
+	Label  string `json:"label"`
+	Worker string `json:"worker,omitempty"`
+
+	// When the dashboard heard of the test, in milliseconds since the epoch.
+	Started int64 `json:"started"`
+}
+
+type testPayload struct {
+	Filter    string         `json:"filter"`
+	Label     string         `json:"label"`
+	Worker    string         `json:"worker,omitempty"`
+	Status    tapjio.Status  `json:"status"`
+	Time      float64        `json:"time"`
+	Attempt   int            `json:"attempt,omitempty"`
+	Retried   bool           `json:"retried,omitempty"`
+	Flaky     bool           `json:"flaky,omitempty"`
+	File      string         `json:"file,omitempty"`
+	Line      int            `json:"line,omitempty"`
+	Exception *exceptionText `json:"exception,omitempty"`
+}
+
+type exceptionText struct {
+	Class   string `json:"class"`
+	Message string `json:"message"`
+}
+
+type finalPayload struct {
+	Time   float64             `json:"time"`
+	Counts *tapjio.ResultTally `json:"counts"`
+}
+
+type endPayload struct {
+	Error string `json:"error,omitempty"`
+}
+
+// Listen starts serving the dashboard at the given address, e.g. localhost:7358.
+func Listen(address string) (*Dashboard, error) {
+	listener, err := net.Listen("tcp", address)
+	if err != nil {
+		return nil, err
+	}
+
+	dashboard := &Dashboard{
+		listener: listener,
+		clients:  make(map[chan string]bool),
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/", dashboard.servePage)
+	mux.HandleFunc("/events", dashboard.serveEvents)
+	go http.Serve(listener, mux)
+
+	return dashboard, nil
+}
+
+// URL returns where the dashboard can be seen.
+func (self *Dashboard) URL() string {
+	return "http://" + self.listener.Addr().String() + "/"
+}
+
+func (self *Dashboard) servePage(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != "/" {
+		http.NotFound(w, r)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	fmt.Fprint(w, page)
+}
+
+func (self *Dashboard) serveEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		http.Error(w, "Streaming is not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+
+	self.mutex.Lock()
+	history := self.history
+	var messages chan string
+	if !self.ended {
+		messages = make(chan string, clientBacklog)
+		self.clients[messages] = true
+		self.handlers.Add(1)
+		defer self.handlers.Done()
+	}
+	self.mutex.Unlock()
+
+	for _, message := range history {
+		_, err := fmt.Fprint(w, message)
+		if err != nil {
+			self.remove(messages)
+			return
+		}
+	}
+	flusher.Flush()
+
+	if messages == nil {
+		return
+	}
+
+	for {
+		select {
+		case message, ok := <-messages:
+			if !ok {
+				return
+			}
+			_, err := fmt.Fprint(w, message)
+			if err != nil {
+				self.remove(messages)
+				return
+			}
+			flusher.Flush()
+		case <-r.Context().Done():
+			self.remove(messages)
+			return
+		}
+	}
+}
+
+func (self *Dashboard) remove(messages chan string) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if self.clients[messages] {
+		delete(self.clients, messages)
+		close(messages)
+	}
+}
+
+// broadcast sends an event to every page, and remembers it for pages that connect later.
+func (self *Dashboard) broadcast(event string, payload interface{}) error {
+	data, err := json.Marshal(payload)
+	if err != nil {
+		return err
+	}
+	message := fmt.Sprintf("event: %s\ndata: %s\n\n", event, data)
+
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.history = append(self.history, message)
+	for messages := range self.clients {
+		select {
+		case messages <- message:
+		default:
+			// Too far behind. Once it reconnects, it'll be sent everything again.
+			delete(self.clients, messages)
+			close(messages)
+		}
+	}
+
+	return nil
+}
+
+func (self *Dashboard) TraceEvent(event tapjio.TraceEvent) error {
+	return nil
+}
+
+func (self *Dashboard) AwaitAttach(event tapjio.AwaitAttachEvent) error {
+	return nil
+}
+
+func (self *Dashboard) SuiteBegin(event tapjio.SuiteBeginEvent) error {
+	// Pages only show the latest suite, so there's no need to send them earlier ones.
+	self.mutex.Lock()
+	self.history = nil
+	self.mutex.Unlock()
+
+	return self.broadcast("suite", suitePayload{
+		Label: event.Label,
+		Start: event.Start,
+		Count: event.Count,
+		Seed:  event.Seed,
+	})
+}
+
+func (self *Dashboard) TestBegin(event tapjio.TestBeginEvent) error {
+	return self.broadcast("begin", beginPayload{
+		Filter:  event.Filter.String(),
+		Label:   tapjio.TestLabel(event.Label, event.Cases),
+		Worker:  event.Worker,
+		Started: time.Now().UnixNano() / int64(time.Millisecond),
+	})
+}
+
+func (self *Dashboard) TestFinish(event tapjio.TestFinishEvent) error {
+	payload := testPayload{
+		Filter:  event.Filter.String(),
+		Label:   tapjio.TestLabel(event.Label, event.Cases),
+		Worker:  event.Worker,
+		Status:  event.Status,
+		Time:    event.Time,
+		Attempt: event.Attempt,
+		Retried: event.Retried,
+		Flaky:   event.Flaky,
+		File:    event.File.String(),
+		Line:    event.Line,
+	}
+	if event.Exception != nil {
+		payload.Exception = &exceptionText{Class: event.Exception.Class, Message: event.Exception.Message}
+	}
+
+	return self.broadcast("test", payload)
+}
+
+func (self *Dashboard) SuiteFinish(event tapjio.SuiteFinishEvent) error {
+	return self.broadcast("final", finalPayload{Time: event.Time, Counts: event.Counts})
+}
+
+// End tells pages the run is over, gives them a moment to hear it, then stops serving.
+func (self *Dashboard) End(reason error) error {
+	payload := endPayload{}
+	if reason != nil {
+		payload.Error = reason.Error()
+	}
+	err := self.broadcast("end", payload)
+
+	self.mutex.Lock()
+	self.ended = true
+	for messages := range self.clients {
+		delete(self.clients, messages)
+		close(messages)
+	}
+	self.mutex.Unlock()
+
+	done := make(chan struct{})
+	go func() {
+		self.handlers.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+	case <-time.After(endTimeout):
+	}
+
+	closeErr := self.listener.Close()
+	if err == nil {
+		err = closeErr
+	}
+
+	return err
+}
@@ -0,0 +1,111 @@
+package dashboard
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+
+	"qa/tapjio"
+)
+
+type sentEvent struct {
+	name string
+	data map[string]interface{}
+}
+
+// readEvents reads the events a page would be sent, until the stream ends.
+func readEvents(t *testing.T, url string, events chan<- sentEvent) {
+	defer close(events)
+
+	response, err := http.Get(url)
+	if err != nil {
+		t.Error(err)
+		return
+	}
+	defer response.Body.Close()
+
+	name := ""
+	scanner := bufio.NewScanner(response.Body)
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			var data map[string]interface{}
+			err := json.Unmarshal([]byte(strings.TrimPrefix(line, "data: ")), &data)
+			if err != nil {
+				t.Error(err)
+				return
+			}
+			events <- sentEvent{name: name, data: data}
+		}
+	}
+}
+
+func expectEvent(t *testing.T, events <-chan sentEvent, name string, key string, value interface{}) {
+	select {
+	case event, ok := <-events:
+		if !ok {
+			t.Fatalf("Expected %s event, but the stream ended", name)
+		}
+		if event.name != name || event.data[key] != value {
+			t.Fatalf("Expected %s event with %s %v, got %s %v", name, key, value, event.name, event.data)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Timed out waiting for %s event", name)
+	}
+}
+
+func TestDashboard(t *testing.T) {
+	ui, err := Listen("127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := http.Get(ui.URL())
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, _ := ioutil.ReadAll(response.Body)
+	response.Body.Close()
+	if !strings.Contains(string(body), `new EventSource("events")`) {
+		t.Fatalf("Expected the dashboard page, got:\n%s", body)
+	}
+
+	suite := tapjio.NewSuiteBeginEvent(time.Now(), 2, 1)
+	suite.Label = "app"
+	ui.SuiteBegin(*suite)
+	begin := tapjio.NewTestBeginEvent()
+	begin.Label = "adds"
+	begin.Filter = "test/math_test.rb:3"
+	begin.Worker = "1"
+	ui.TestBegin(*begin)
+
+	// A page that connects partway through is sent what it missed.
+	events := make(chan sentEvent, 10)
+	go readEvents(t, ui.URL()+"events", events)
+	expectEvent(t, events, "suite", "label", "app")
+	expectEvent(t, events, "begin", "worker", "1")
+
+	ui.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: "adds", Filter: "test/math_test.rb:3", Worker: "1", Status: tapjio.Fail,
+		Exception: &tapjio.TestException{Class: "Minitest::Assertion", Message: "Expected 2"}})
+	expectEvent(t, events, "test", "status", "fail")
+
+	ui.SuiteFinish(*tapjio.NewSuiteFinishEvent(suite))
+	expectEvent(t, events, "final", "time", 0.0)
+
+	err = ui.End(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expectEvent(t, events, "end", "error", nil)
+
+	if _, ok := <-events; ok {
+		t.Fatal("Expected the stream to end")
+	}
+}
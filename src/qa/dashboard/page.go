@@ -0,0 +1,228 @@
+package dashboard
+
+// The page the dashboard serves. It has no external assets, and keeps all of its state in the
+// browser, built up from the events it's sent.
+const page = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>qa</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; margin: 2em; color: #222; }
+.location, .label, pre { font-family: Menlo, Consolas, monospace; font-size: 12px; }
+pre { background: #f6f6f6; padding: 0.5em; white-space: pre-wrap; margin: 0.3em 0 0 0; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: 0.2em; }
+#connection { color: #777; }
+#progress { height: 6px; background: #eee; margin: 1em 0; }
+#progress div { height: 6px; background: #2a7d2a; width: 0; }
+#progress.failing div { background: #c62828; }
+.tally span { margin-right: 1em; font-weight: bold; }
+.lane { display: flex; align-items: center; margin: 0.3em 0; }
+.lane .worker { width: 6em; color: #777; }
+.lane .current { width: 40em; overflow: hidden; white-space: nowrap; text-overflow: ellipsis; }
+.lane .dots { display: flex; flex-wrap: nowrap; overflow: hidden; }
+.dot { width: 8px; height: 8px; margin-right: 2px; border-radius: 2px; }
+.pass { color: #2a7d2a; } .fail { color: #c62828; } .error { color: #8e24aa; }
+.todo, .omit { color: #00838f; } .notrun { color: #777; } .flaky, .retried { color: #b8860b; }
+.dot.pass { background: #2a7d2a; } .dot.fail { background: #c62828; } .dot.error { background: #8e24aa; }
+.dot.todo, .dot.omit { background: #00838f; } .dot.notrun { background: #aaa; } .dot.flaky, .dot.retried { background: #b8860b; }
+.failure { margin: 0.8em 0; }
+table { border-collapse: collapse; }
+td { padding: 0.1em 0.8em 0.1em 0; }
+</style>
+</head>
+<body>
+<h1 id="title">qa</h1>
+<div id="connection">Connecting…</div>
+<div id="progress"><div></div></div>
+<div class="tally" id="tally"></div>
+
+<h2>Workers</h2>
+<div id="lanes"></div>
+
+<h2>Pending</h2>
+<table id="pending"></table>
+
+<h2>Failures</h2>
+<div id="failures"><p>None yet.</p></div>
+
+<script>
+(function() {
+  var state;
+  var scheduled = false;
+
+  function reset(suite) {
+    state = {
+      suite: suite || {},
+      final: null,
+      pending: {},
+      lanes: {},
+      tally: {pass: 0, fail: 0, error: 0, todo: 0, omit: 0, notrun: 0, flaky: 0},
+      done: 0,
+      failures: []
+    };
+  }
+
+  function element(tag, className, text) {
+    var e = document.createElement(tag);
+    if (className) { e.className = className; }
+    if (text !== undefined) { e.textContent = text; }
+    return e;
+  }
+
+  function lane(worker) {
+    var key = worker || "-";
+    if (!state.lanes[key]) {
+      state.lanes[key] = {current: null, dots: []};
+    }
+    return state.lanes[key];
+  }
+
+  function statusOf(test) {
+    if (test.retried) { return "retried"; }
+    if (test.flaky) { return "flaky"; }
+    return test.status;
+  }
+
+  function seconds(ms) {
+    return (ms / 1000).toFixed(1) + "s";
+  }
+
+  function render() {
+    scheduled = false;
+    var suite = state.suite;
+    document.getElementById("title").textContent = "qa" + (suite.label ? ": " + suite.label : "");
+
+    var count = Math.max(suite.count || 0, state.done + Object.keys(state.pending).length);
+    var progress = document.getElementById("progress");
+    progress.firstChild.style.width = (count ? 100 * state.done / count : 0) + "%";
+    progress.className = state.tally.fail + state.tally.error > 0 ? "failing" : "";
+
+    var tally = document.getElementById("tally");
+    tally.innerHTML = "";
+    tally.appendChild(element("span", "", state.done + "/" + count + " tests"));
+    ["pass", "fail", "error", "todo", "omit", "flaky", "notrun"].forEach(function(status) {
+      if (state.tally[status] > 0) {
+        tally.appendChild(element("span", status, state.tally[status] + " " + status));
+      }
+    });
+    if (state.final) {
+      tally.appendChild(element("span", "", "finished in " + state.final.time.toFixed(1) + "s"));
+    }
+
+    var now = Date.now();
+    var lanes = document.getElementById("lanes");
+    lanes.innerHTML = "";
+    Object.keys(state.lanes).sort(function(a, b) { return a - b || (a < b ? -1 : a > b ? 1 : 0); }).forEach(function(key) {
+      var l = state.lanes[key];
+      var row = element("div", "lane");
+      row.appendChild(element("div", "worker", key === "-" ? "" : "worker " + key));
+      var current = l.current ? l.current.label + " (" + seconds(now - l.current.started) + ")" : "idle";
+      row.appendChild(element("div", "current label", current));
+      var dots = element("div", "dots");
+      l.dots.slice(-60).forEach(function(dot) {
+        var d = element("div", "dot " + dot.status);
+        d.title = dot.label + " (" + dot.status + ")";
+        dots.appendChild(d);
+      });
+      row.appendChild(dots);
+      lanes.appendChild(row);
+    });
+
+    var pending = document.getElementById("pending");
+    pending.innerHTML = "";
+    Object.keys(state.pending).map(function(filter) { return state.pending[filter]; })
+      .sort(function(a, b) { return a.started - b.started; })
+      .forEach(function(test) {
+        var row = element("tr");
+        row.appendChild(element("td", "label", test.label));
+        row.appendChild(element("td", "", test.worker ? "worker " + test.worker : ""));
+        row.appendChild(element("td", "", seconds(now - test.started)));
+        pending.appendChild(row);
+      });
+
+    var failures = document.getElementById("failures");
+    failures.innerHTML = "";
+    if (state.failures.length === 0) {
+      failures.appendChild(element("p", "", "None yet."));
+    }
+    state.failures.forEach(function(test) {
+      var div = element("div", "failure");
+      var status = statusOf(test);
+      var title = element("div");
+      title.appendChild(element("strong", status, status + " "));
+      title.appendChild(element("span", "label", test.label + (test.attempt ? " (attempt " + test.attempt + ")" : "")));
+      if (test.file) {
+        title.appendChild(element("span", "location", "  " + test.file + (test.line ? ":" + test.line : "")));
+      }
+      div.appendChild(title);
+      if (test.exception) {
+        var message = (test.status === "error" && test.exception["class"] ? test.exception["class"] + ": " : "") + test.exception.message;
+        div.appendChild(element("pre", "", message));
+      }
+      failures.appendChild(div);
+    });
+  }
+
+  function update() {
+    if (!scheduled) {
+      scheduled = true;
+      window.requestAnimationFrame(render);
+    }
+  }
+
+  reset();
+  var connection = document.getElementById("connection");
+  var events = new EventSource("events");
+  events.onopen = function() { connection.textContent = "Connected"; };
+  events.onerror = function() { connection.textContent = "Disconnected, reconnecting…"; };
+
+  events.addEventListener("suite", function(e) {
+    reset(JSON.parse(e.data));
+    update();
+  });
+  events.addEventListener("begin", function(e) {
+    var test = JSON.parse(e.data);
+    state.pending[test.filter] = test;
+    lane(test.worker).current = test;
+    update();
+  });
+  events.addEventListener("test", function(e) {
+    var test = JSON.parse(e.data);
+    var status = statusOf(test);
+    var begun = state.pending[test.filter];
+    delete state.pending[test.filter];
+
+    var l = lane(test.worker || (begun && begun.worker));
+    if (l.current && l.current.filter === test.filter) {
+      l.current = null;
+    }
+    l.dots.push({label: test.label, status: status});
+
+    if (!test.retried) {
+      state.done++;
+      state.tally[test.flaky ? "flaky" : test.status]++;
+    }
+    if (test.status === "fail" || test.status === "error") {
+      state.failures.push(test);
+    }
+    update();
+  });
+  events.addEventListener("final", function(e) {
+    state.final = JSON.parse(e.data);
+    update();
+  });
+  events.addEventListener("end", function(e) {
+    var end = JSON.parse(e.data);
+    events.close();
+    connection.textContent = end.error ? "The run ended early: " + end.error : "The run is over";
+    update();
+  });
+
+  // Keep the times of running tests ticking.
+  setInterval(update, 1000);
+})();
+</script>
+</body>
+</html>
+`
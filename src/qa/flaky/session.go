@@ -64,6 +64,7 @@ func (s *Session) Summaries() ([]*TestSummary, error) {
 					"--subgroup-by", "outcome-digest",
 					"--ignore-if", "status==\"todo\"",
 					"--ignore-if", "status==\"omit\"",
+					"--ignore-if", "status==\"notrun\"",
 					"--success-if", "status==\"pass\"",
 				},
 			},
@@ -5,11 +5,18 @@ import (
 	"os"
 	"os/exec"
 	"qa/cmd"
+	"qa/cmd/agent"
+	"qa/cmd/bisect"
+	"qa/cmd/config"
+	"qa/cmd/coordinate"
 	"qa/cmd/discover"
 	"qa/cmd/flaky"
 	"qa/cmd/flamegraph"
 	"qa/cmd/grouping"
+	"qa/cmd/importer"
+	"qa/cmd/merge"
 	"qa/cmd/run"
+	"qa/cmd/slow"
 	"qa/cmd/stackcollapse"
 	"qa/cmd/summary"
 	"sort"
@@ -66,6 +73,58 @@ var subcommands = map[string]subcommand {
 			},
 			description: "Run Test::Unit tests",
 		},
+	"gotest": subcommand{
+		documented: true,
+		main: func(env *cmd.Env, argv []string) error {
+				return run.Framework("gotest", env, argv)
+			},
+			description: "Run Go tests",
+		},
+	"tap": subcommand{
+		documented: true,
+		main: run.Tap,
+		description: "Run test files with commands that write TAP",
+	},
+	"import": subcommand{
+		documented: true,
+		main: importer.Main,
+		description: "Add results from other tools to an archive, for qa flaky",
+	},
+	"merge": subcommand{
+		documented: true,
+		main: merge.Main,
+		description: "Combine TAP-J streams from several shards into a single suite",
+	},
+	"slow": subcommand{
+		documented: true,
+		main: slow.Main,
+		description: "List tests that have become slower than their archived history",
+	},
+	"report": subcommand{
+		documented: true,
+		main: run.Report,
+		description: "Replay saved TAP-J through any reporter, or write an HTML report of it",
+	},
+	"bisect": subcommand{
+		documented: true,
+		main: bisect.Main,
+		description: "Find the tests that make an order-dependent test fail",
+	},
+	"config": subcommand{
+		documented: true,
+		main: config.Main,
+		description: "Show the settings qa would run with, and where each came from",
+	},
+	"coordinate": subcommand{
+		documented: true,
+		main: coordinate.Main,
+		description: "Run tests on agents that connect from other machines",
+	},
+	"agent": subcommand{
+		documented: true,
+		main: agent.Main,
+		description: "Run tests for a coordinator",
+	},
 	"flamegraph": subcommand{
 		main: flamegraph.Main,
 		description: "Generate a flamegraph from an enriched TAP-J stream",
@@ -0,0 +1,134 @@
+package reporting
+
+import (
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+
+	"qa/analysis"
+	"qa/tapjio"
+)
+
+// Annotations writes workflow commands that CI services like GitHub Actions turn into inline
+// annotations, like "::error file=app/models/user.rb,line=12,title=validates::expected valid".
+// Each failing test is an error, written as soon as it finishes. Tests that are dramatically
+// slower than the others are warnings, written when their suite finishes.
+type Annotations struct {
+	writer  io.Writer
+	timeCop *analysis.TimeCop
+
+	// Where each passing test is, for the warnings about slow ones.
+	locations map[string]annotationLocation
+}
+
+type annotationLocation struct {
+	file string
+	line int
+}
+
+func NewAnnotations(writer io.Writer) *Annotations {
+	return &Annotations{writer: writer}
+}
+
+// annotationEscape escapes the message of a workflow command. Property values need commas and
+// colons escaped as well.
+func annotationEscape(text string, property bool) string {
+	text = strings.Replace(text, "%", "%25", -1)
+	text = strings.Replace(text, "\r", "%0D", -1)
+	text = strings.Replace(text, "\n", "%0A", -1)
+	if property {
+		text = strings.Replace(text, ":", "%3A", -1)
+		text = strings.Replace(text, ",", "%2C", -1)
+	}
+
+	return text
+}
+
+func (self *Annotations) annotate(command string, file string, line int, title string, message string) error {
+	var properties []string
+	if file != "" {
+		properties = append(properties, "file="+annotationEscape(file, true))
+		if line > 0 {
+			properties = append(properties, "line="+strconv.Itoa(line))
+		}
+	}
+	if title != "" {
+		properties = append(properties, "title="+annotationEscape(title, true))
+	}
+
+	_, err := fmt.Fprintf(self.writer, "::%s %s::%s\n", command, strings.Join(properties, ","), annotationEscape(message, false))
+	return err
+}
+
+func (self *Annotations) TraceEvent(event tapjio.TraceEvent) error {
+	return nil
+}
+
+func (self *Annotations) AwaitAttach(event tapjio.AwaitAttachEvent) error {
+	return nil
+}
+
+func (self *Annotations) SuiteBegin(event tapjio.SuiteBeginEvent) error {
+	self.timeCop = &analysis.TimeCop{MaxResults: 10}
+	self.locations = make(map[string]annotationLocation)
+	return nil
+}
+
+func (self *Annotations) TestBegin(event tapjio.TestBeginEvent) error {
+	return nil
+}
+
+func (self *Annotations) TestFinish(event tapjio.TestFinishEvent) error {
+	if self.timeCop != nil {
+		self.timeCop.TestFinish(event)
+	}
+
+	label := tapjio.TestLabel(event.Label, event.Cases)
+	if event.Status == tapjio.Pass && self.locations != nil {
+		self.locations[label] = annotationLocation{file: event.File.String(), line: event.Line}
+	}
+
+	if event.Retried || (event.Status != tapjio.Fail && event.Status != tapjio.Error) {
+		return nil
+	}
+
+	message := string(event.Status)
+	if exception := event.Exception; exception != nil {
+		message = exception.Message
+		if event.Status == tapjio.Error && exception.Class != "" {
+			message = exception.Class + ": " + message
+		}
+	}
+
+	file, line := failureLocation(event)
+	return self.annotate("error", file, line, label, message)
+}
+
+func (self *Annotations) SuiteFinish(event tapjio.SuiteFinishEvent) error {
+	if self.timeCop == nil {
+		return nil
+	}
+
+	self.timeCop.SuiteFinish(event)
+	for _, outcome := range self.timeCop.SlowPassingOutcomes {
+		duration := millisDuration(outcome.Duration)
+		// When every test is quick, even the slowest aren't worth mentioning.
+		if duration == 0 {
+			continue
+		}
+
+		location := self.locations[outcome.Label]
+		message := fmt.Sprintf("Took %v, more than twice the average of passing tests", duration)
+		err := self.annotate("warning", location.file, location.line, "Slow test: "+outcome.Label, message)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *Annotations) End(reason error) error {
+	return nil
+}
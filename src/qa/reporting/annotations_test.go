@@ -0,0 +1,63 @@
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"qa/tapjio"
+)
+
+func TestAnnotations(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	annotations := NewAnnotations(buffer)
+
+	suite := tapjio.SuiteBeginEvent{Type: "suite"}
+	annotations.SuiteBegin(suite)
+
+	user := tapjio.CaseEvent{Type: "case", Label: "User"}
+	events := []tapjio.TestFinishEvent{
+		{Type: "test", Label: "validates", Status: tapjio.Fail, File: "spec/user_spec.rb", Line: 3, Cases: []tapjio.CaseEvent{user},
+			Exception: &tapjio.TestException{
+				Message: "expected valid,\nbut 100% invalid",
+				Backtrace: []tapjio.BacktraceLocation{
+					{File: "/gems/rspec.rb", Line: 1, Internal: true},
+					{File: "app/models/user.rb", Line: 12, User: true},
+				},
+			}},
+		{Type: "test", Label: "loads", Status: tapjio.Error, File: "spec/user_spec.rb", Line: 8, Cases: []tapjio.CaseEvent{user},
+			Exception: &tapjio.TestException{Class: "NameError", Message: "undefined"}},
+		{Type: "test", Label: "retried", Status: tapjio.Fail, Retried: true, Attempt: 1},
+	}
+	for i := 0; i < 10; i++ {
+		events = append(events, tapjio.TestFinishEvent{Type: "test", Label: fmt.Sprintf("fast %d", i), Status: tapjio.Pass, Time: 0.01})
+	}
+	events = append(events, tapjio.TestFinishEvent{Type: "test", Label: "slow", Status: tapjio.Pass, Time: 2, File: "spec/slow_spec.rb", Line: 5})
+
+	for _, event := range events {
+		err := annotations.TestFinish(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err := annotations.SuiteFinish(*tapjio.NewSuiteFinishEvent(&suite))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []string{
+		"::error file=app/models/user.rb,line=12,title=User ▸ validates::expected valid,%0Abut 100%25 invalid",
+		"::error file=spec/user_spec.rb,line=8,title=User ▸ loads::NameError: undefined",
+		"::warning file=spec/slow_spec.rb,line=5,title=Slow test%3A slow::Took 2s, more than twice the average of passing tests",
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != len(expected) {
+		t.Fatalf("Expected %d annotations, got:\n%s", len(expected), buffer.String())
+	}
+	for ix, line := range lines {
+		if line != expected[ix] {
+			t.Fatalf("Expected %q, got %q", expected[ix], line)
+		}
+	}
+}
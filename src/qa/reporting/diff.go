@@ -0,0 +1,73 @@
+package reporting
+
+import (
+	"fmt"
+	"io"
+
+	"qa/tapjio"
+)
+
+// Diff lines longer than this many characters are wrapped.
+const diffWrapWidth = 100
+
+// At most this many lines of a diff are shown, counting wrapped ones, before the rest is elided.
+const maxDiffRows = 60
+
+// wrapDiffText breaks text into pieces of at most width characters.
+func wrapDiffText(text string, width int) []string {
+	runes := []rune(text)
+	if len(runes) <= width {
+		return []string{text}
+	}
+
+	var pieces []string
+	for len(runes) > width {
+		pieces = append(pieces, string(runes[:width]))
+		runes = runes[width:]
+	}
+
+	return append(pieces, string(runes))
+}
+
+// summarizeDiff shows how what a failed equality assertion was given differs from what it
+// expected. Long lines are wrapped, and a long diff is cut short.
+func (self *Style) summarizeDiff(writer io.Writer, diff []tapjio.DiffLine) {
+	fmt.Fprintf(writer, "   %s %s %s\n",
+		self.outputTitleStyle("Diff"),
+		self.diffRemovedStyle("-expected"),
+		self.diffAddedStyle("+actual"))
+
+	rows := 0
+	for ix, line := range diff {
+		if rows >= maxDiffRows {
+			remaining := len(diff) - ix
+			fmt.Fprintf(writer, "   … %d more %s of diff\n", remaining, MaybePlural(remaining, "line", "lines"))
+			return
+		}
+
+		style := self.lineTextFocusedStyle
+		switch line.Op {
+		case tapjio.DiffRemoved:
+			style = self.diffRemovedStyle
+		case tapjio.DiffAdded:
+			style = self.diffAddedStyle
+		case tapjio.DiffHunk:
+			style = self.diffHunkStyle
+		}
+
+		if line.Op == tapjio.DiffHunk {
+			fmt.Fprintf(writer, "   %s\n", style("%s", line.Text))
+			rows++
+			continue
+		}
+
+		for pieceIx, piece := range wrapDiffText(line.Text, diffWrapWidth) {
+			continuation := " "
+			if pieceIx > 0 {
+				continuation = self.lineNumberBlurredStyle("↪")
+			}
+			fmt.Fprintf(writer, "   %s%s%s\n", style(string(line.Op)), continuation, style("%s", piece))
+			rows++
+		}
+	}
+}
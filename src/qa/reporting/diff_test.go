@@ -0,0 +1,50 @@
+package reporting
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/fatih/color"
+
+	"qa/tapjio"
+)
+
+func TestSummarizeDiff(t *testing.T) {
+	noColor := color.NoColor
+	color.NoColor = true
+	defer func() { color.NoColor = noColor }()
+
+	exception := tapjio.TestException{
+		Class:    "Minitest::Assertion",
+		Message:  "Expected: \"...\"\n  Actual: \"...\"",
+		Expected: &tapjio.ComparedValue{Kind: "string", String: "short\n" + strings.Repeat("a", 150)},
+		Actual:   &tapjio.ComparedValue{Kind: "string", String: "short\n" + strings.Repeat("b", 150)},
+	}
+
+	buffer := &bytes.Buffer{}
+	NewStyle().summarizeException(buffer, false, exception)
+	expected := "   Diff -expected +actual\n" +
+		"   @@ -1,2 +1,2 @@\n" +
+		"     short\n" +
+		"   - " + strings.Repeat("a", 100) + "\n" +
+		"   -↪" + strings.Repeat("a", 50) + "\n" +
+		"   + " + strings.Repeat("b", 100) + "\n" +
+		"   +↪" + strings.Repeat("b", 50) + "\n"
+	if !strings.Contains(buffer.String(), expected) {
+		t.Fatalf("Expected a wrapped diff, got:\n%s", buffer.String())
+	}
+
+	var long []string
+	for i := 0; i < 100; i++ {
+		long = append(long, strings.Repeat("x", i))
+	}
+	exception.Expected = &tapjio.ComparedValue{Kind: "array", Elements: long}
+	exception.Actual = &tapjio.ComparedValue{Kind: "array"}
+
+	buffer.Reset()
+	NewStyle().summarizeException(buffer, false, exception)
+	if !strings.Contains(buffer.String(), "   … 43 more lines of diff\n") {
+		t.Fatalf("Expected a long diff to be cut short, got:\n%s", buffer.String())
+	}
+}
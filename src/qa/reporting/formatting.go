@@ -25,6 +25,8 @@ func NewStyle() *Style {
 		errorStyle: color.New(color.FgMagenta, color.Bold).SprintfFunc(),
 		todoStyle:  color.New(color.FgCyan, color.Bold).SprintfFunc(),
 		omitStyle:  color.New(color.FgCyan, color.Bold).SprintfFunc(),
+		notRunStyle: color.New(color.Faint).SprintfFunc(),
+		flakyStyle: color.New(color.FgYellow, color.Bold).SprintfFunc(),
 
 		testDescriptionStyle: color.New(color.Bold).SprintfFunc(),
 
@@ -43,6 +45,9 @@ func NewStyle() *Style {
 		OmitNounSingular: "omit",
 		OmitNounPlural:   "omits",
 
+		NotRunNoun: "not run",
+		FlakyNoun:  "flaky",
+
 		snailSummaryStyle:  color.New(color.Bold, color.FgYellow).SprintFunc(),
 		snailDurationStyle: color.New(color.FgYellow).SprintfFunc(),
 
@@ -52,6 +57,10 @@ func NewStyle() *Style {
 		lineTextFocusedStyle: color.New().SprintfFunc(),
 
 		outputTitleStyle: color.New(color.FgBlack, color.Bold).SprintfFunc(),
+
+		diffRemovedStyle: color.New(color.FgRed).SprintfFunc(),
+		diffAddedStyle: color.New(color.FgGreen).SprintfFunc(),
+		diffHunkStyle: color.New(color.FgCyan).SprintfFunc(),
 	}
 }
 
@@ -61,6 +70,8 @@ type Style struct {
 	failStyle   func(s string, a ...interface{}) string
 	errorStyle  func(s string, a ...interface{}) string
 	omitStyle  func(s string, a ...interface{}) string
+	notRunStyle func(s string, a ...interface{}) string
+	flakyStyle func(s string, a ...interface{}) string
 
 	ShowAllInternalFrames bool
 	PreferOnlyUserFrames  bool
@@ -80,6 +91,9 @@ type Style struct {
 	OmitNounSingular  string
 	OmitNounPlural    string
 
+	NotRunNoun string
+	FlakyNoun  string
+
 	snailDurationStyle func(s string, a ...interface{}) string
 	snailSummaryStyle  func(a ...interface{}) string
 
@@ -91,6 +105,10 @@ type Style struct {
 	lineTextFocusedStyle func(s string, a ...interface{}) string
 
 	outputTitleStyle func(s string, a ...interface{}) string
+
+	diffRemovedStyle func(s string, a ...interface{}) string
+	diffAddedStyle func(s string, a ...interface{}) string
+	diffHunkStyle func(s string, a ...interface{}) string
 }
 
 func (self *Style) formatStatus(status tapjio.Status) string {
@@ -105,6 +123,8 @@ func (self *Style) formatStatus(status tapjio.Status) string {
 		return self.omitStyle("Ø")
 	case tapjio.Todo:
 		return self.todoStyle("…")
+	case tapjio.NotRun:
+		return self.notRunStyle("-")
 	default:
 		return self.errorStyle("?")
 	}
@@ -119,6 +139,11 @@ func (self *Style) summarizeException(writer io.Writer, includeClass bool, excep
 
 	fmt.Fprintf(writer, "%s\n\n", indent(message, 3))
 
+	if diff := tapjio.Diff(&exception); diff != nil {
+		self.summarizeDiff(writer, diff)
+		fmt.Fprintf(writer, "\n")
+	}
+
 	// new_bt = bt.take_while { |e| !e['internal'] }
 	// new_bt = bt.select     { |e| !e['internal'] } if new_bt.empty?
 	// new_bt = bt.dup                               if new_bt.empty?
@@ -231,6 +256,21 @@ func (self *Style) summarizeException(writer io.Writer, includeClass bool, excep
 			fmt.Fprintf(writer, format, marker, iText, lineText)
 		}
 	}
+
+	for _, thread := range exception.Threads {
+		fmt.Fprintf(writer, "\n   %s\n", self.outputTitleStyle("Thread %s", thread.Label))
+		for _, entry := range thread.Backtrace {
+			if entry.Internal && !showAllInternalFrames {
+				continue
+			}
+
+			method := ""
+			if entry.Method != "" {
+				method = " in " + entry.Method
+			}
+			fmt.Fprintf(writer, "   File:   %s:%d%s\n", entry.File, entry.Line, method)
+		}
+	}
 }
 
 func (self *Style) summarizeCapturedOutput(writer io.Writer, label, output string) {
@@ -262,11 +302,26 @@ func (self *Style) FormatTestExceptionBriefly(event tapjio.TestFinishEvent) stri
 	}
 }
 
+func (self *Style) formatTestFinishStatus(event tapjio.TestFinishEvent) string {
+	if event.Retried {
+		return self.flakyStyle("↻")
+	}
+
+	if event.Flaky {
+		return self.flakyStyle("~")
+	}
+
+	return self.formatStatus(event.Status)
+}
+
 func (self *Style) SummarizeTestFinish(writer io.Writer, totalTests int, tally tapjio.ResultTally, event tapjio.TestFinishEvent) {
 	description := tapjio.TestLabel(event.Label, event.Cases)
+	if event.Attempt > 0 {
+		description = fmt.Sprintf("%s (attempt %d)", description, event.Attempt)
+	}
 
 	fmt.Fprintf(writer, "%s  %-50s [%d/%d] %v\n",
-		self.formatStatus(event.Status),
+		self.formatTestFinishStatus(event),
 		self.testDescriptionStyle(description),
 		tally.Total, totalTests,
 		millisDuration(event.Time))
@@ -320,6 +375,72 @@ func (self *Style) SummarizeSnails(writer io.Writer, timecop *analysis.TimeCop)
 	}
 }
 
+// SummarizeRegressions lists the tests that have become significantly slower than they used to
+// be, and since when.
+func (self *Style) SummarizeRegressions(writer io.Writer, regressions []analysis.Regression) {
+	for _, regression := range regressions {
+		since := regression.Coderef
+		if since == "" {
+			since = regression.Start
+		}
+		if since != "" {
+			since = " since " + since
+		}
+		if regression.Runs > 1 {
+			since = fmt.Sprintf("%s, %d runs in a row", since, regression.Runs)
+		}
+
+		fmt.Fprintf(writer, "📈  %-59s %s%s\n",
+			regression.Label,
+			self.snailDurationStyle("%v, up from %v (%.1f×)",
+				millisDuration(regression.Recent), millisDuration(regression.Baseline), regression.Slowdown()),
+			since)
+	}
+
+	if len(regressions) > 0 {
+		fmt.Fprintf(writer,
+			self.snailSummaryStyle("\nThe %d %s above %s significantly slower than %s archived history\n\n"),
+			len(regressions),
+			MaybePlural(len(regressions), "test", "tests"),
+			MaybePlural(len(regressions), "is", "are"),
+			MaybePlural(len(regressions), "its", "their"))
+	}
+}
+
+// SummarizeBudgetOverruns lists the tests that went over their duration budgets, and the
+// suite, if it did.
+func (self *Style) SummarizeBudgetOverruns(writer io.Writer, tests []tapjio.TestFinishEvent, final tapjio.SuiteFinishEvent) {
+	overrunStyle := func(overrun *tapjio.BudgetOverrun) func(s string, a ...interface{}) string {
+		if overrun.Warning {
+			return self.flakyStyle
+		}
+		return self.failStyle
+	}
+
+	for _, test := range tests {
+		fmt.Fprintf(writer, "⏱  %-59s %s\n",
+			tapjio.TestLabel(test.Label, test.Cases),
+			overrunStyle(test.Budget)("%v, over the %v budget for %s",
+				millisDuration(test.Time), millisDuration(test.Budget.Budget), test.Budget.Pattern))
+	}
+
+	if final.Budget != nil {
+		fmt.Fprintf(writer, "⏱  %s\n",
+			overrunStyle(final.Budget)("The suite took %v, over its %v budget",
+				millisDuration(final.Time), millisDuration(final.Budget.Budget)))
+	}
+
+	if len(tests) > 0 {
+		fmt.Fprintf(writer, "\n%d %s went over %s duration %s\n\n",
+			len(tests),
+			MaybePlural(len(tests), "test", "tests"),
+			MaybePlural(len(tests), "its", "their"),
+			MaybePlural(len(tests), "budget", "budgets"))
+	} else {
+		fmt.Fprintf(writer, "\n")
+	}
+}
+
 func (self *Style) FormatTally(tally tapjio.ResultTally) string {
 	countLabels := []string{}
 	if tally.Pass > 0 {
@@ -347,6 +468,16 @@ func (self *Style) FormatTally(tally tapjio.ResultTally) string {
 			self.omitStyle("%d %s", tally.Omit, MaybePlural(tally.Omit, self.OmitNounSingular, self.OmitNounPlural)))
 	}
 
+	if tally.Flaky > 0 {
+		countLabels = append(countLabels,
+			self.flakyStyle("%d %s", tally.Flaky, self.FlakyNoun))
+	}
+
+	if tally.NotRun > 0 {
+		countLabels = append(countLabels,
+			self.notRunStyle("%d %s", tally.NotRun, self.NotRunNoun))
+	}
+
 	return strings.Join(countLabels, ", ")
 }
 
@@ -386,3 +517,17 @@ func Round(d, r time.Duration) time.Duration {
 func millisDuration(seconds float64) time.Duration {
 	return time.Duration(seconds*1000) * time.Millisecond
 }
+
+// failureLocation finds where a test failed: the first frame of its backtrace in the project's
+// own code, or where the test is if there isn't one.
+func failureLocation(event tapjio.TestFinishEvent) (string, int) {
+	if event.Exception != nil {
+		for _, entry := range event.Exception.Backtrace {
+			if entry.User && !entry.Internal {
+				return entry.File, entry.Line
+			}
+		}
+	}
+
+	return event.File.String(), event.Line
+}
@@ -0,0 +1,444 @@
+package reporting
+
+import (
+	"html/template"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+
+	"qa/tapjio"
+)
+
+// Html writes a single, self-contained HTML page describing the suites it visits: a summary,
+// failures grouped by outcome digest, and a sortable table of every test and its duration.
+// Nothing is written until End is called. The page has no external assets, so it can be
+// archived alongside a build and opened offline.
+type Html struct {
+	writer io.Writer
+	closer io.Closer
+
+	suites []*htmlSuite
+	tally  tapjio.ResultTally
+	groups map[tapjio.OutcomeDigest]*htmlGroup
+	tests  []*htmlTest
+}
+
+type htmlSuite struct {
+	Label    string
+	Coderef  string
+	Start    string
+	Seed     int
+	Count    int
+	Duration string
+	Tally    *tapjio.ResultTally
+}
+
+type htmlGroup struct {
+	Digest  string
+	Status  string
+	Class   string
+	Message string
+	Tests   []*htmlTest
+}
+
+type htmlTest struct {
+	ID        string
+	Label     string
+	Location  string
+	Status    string
+	Time      float64
+	Duration  string
+	Attempt   int
+	Failed    bool
+	Exception *htmlException
+	Stdout    string
+	Stderr    string
+}
+
+type htmlException struct {
+	Class   string
+	Message string
+	Diff    []htmlDiffLine
+	Frames  []htmlFrame
+	Threads []htmlThread
+}
+
+type htmlDiffLine struct {
+	// One of "same", "removed", "added" or "hunk".
+	Class string
+	Text  string
+}
+
+type htmlThread struct {
+	Label  string
+	Frames []htmlFrame
+}
+
+type htmlFrame struct {
+	Location  string
+	Method    string
+	Internal  bool
+	Variables []htmlVariable
+	Lines     []htmlLine
+}
+
+type htmlVariable struct {
+	Name  string
+	Value string
+}
+
+type htmlLine struct {
+	Number  int
+	Text    string
+	Focused bool
+}
+
+// NewHtmlEmitCloser returns an Html reporter that writes to writer, then closes it.
+func NewHtmlEmitCloser(writer io.WriteCloser) *Html {
+	return &Html{writer: writer, closer: writer, groups: make(map[tapjio.OutcomeDigest]*htmlGroup)}
+}
+
+func NewHtmlEmitter(writer io.Writer) *Html {
+	return &Html{writer: writer, groups: make(map[tapjio.OutcomeDigest]*htmlGroup)}
+}
+
+func htmlStatus(event tapjio.TestFinishEvent) string {
+	switch {
+	case event.Retried:
+		return "retried"
+	case event.Flaky:
+		return "flaky"
+	case event.Status == tapjio.NotRun:
+		return "notrun"
+	}
+
+	return string(event.Status)
+}
+
+func htmlFrames(backtrace []tapjio.BacktraceLocation, snippets map[string]map[string]string) []htmlFrame {
+	var frames []htmlFrame
+	for _, entry := range backtrace {
+		frame := htmlFrame{
+			Location: entry.File + ":" + strconv.Itoa(entry.Line),
+			Method:   entry.Method,
+			Internal: entry.Internal,
+		}
+
+		var names []string
+		for name := range entry.Variables {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			frame.Variables = append(frame.Variables, htmlVariable{Name: name, Value: entry.Variables[name]})
+		}
+
+		if lines, ok := snippets[entry.File]; ok {
+			for i := entry.Line - 3; i <= entry.Line+3; i++ {
+				text, ok := lines[strconv.Itoa(i)]
+				if !ok {
+					continue
+				}
+				frame.Lines = append(frame.Lines, htmlLine{Number: i, Text: text, Focused: i == entry.Line})
+			}
+		}
+
+		frames = append(frames, frame)
+	}
+
+	return frames
+}
+
+func newHtmlException(exception *tapjio.TestException) *htmlException {
+	if exception == nil {
+		return nil
+	}
+
+	result := &htmlException{
+		Class:   exception.Class,
+		Message: exception.Message,
+		Frames:  htmlFrames(exception.Backtrace, exception.Snippets),
+	}
+	for _, line := range tapjio.Diff(exception) {
+		class := "same"
+		switch line.Op {
+		case tapjio.DiffRemoved:
+			class = "removed"
+		case tapjio.DiffAdded:
+			class = "added"
+		case tapjio.DiffHunk:
+			class = "hunk"
+		}
+		result.Diff = append(result.Diff, htmlDiffLine{Class: class, Text: line.String()})
+	}
+	for _, thread := range exception.Threads {
+		result.Threads = append(result.Threads, htmlThread{Label: thread.Label, Frames: htmlFrames(thread.Backtrace, nil)})
+	}
+
+	return result
+}
+
+func (self *Html) TraceEvent(event tapjio.TraceEvent) error {
+	return nil
+}
+
+func (self *Html) AwaitAttach(event tapjio.AwaitAttachEvent) error {
+	return nil
+}
+
+func (self *Html) SuiteBegin(event tapjio.SuiteBeginEvent) error {
+	self.suites = append(self.suites, &htmlSuite{
+		Label:   event.Label,
+		Coderef: event.Coderef,
+		Start:   event.Start,
+		Seed:    event.Seed,
+		Count:   event.Count,
+	})
+
+	return nil
+}
+
+func (self *Html) TestBegin(event tapjio.TestBeginEvent) error {
+	return nil
+}
+
+func (self *Html) TestFinish(event tapjio.TestFinishEvent) error {
+	self.tally.IncrementFor(event)
+
+	test := &htmlTest{
+		ID:        "test-" + strconv.Itoa(len(self.tests)+1),
+		Label:     tapjio.TestLabel(event.Label, event.Cases),
+		Status:    htmlStatus(event),
+		Time:      event.Time,
+		Duration:  millisDuration(event.Time).String(),
+		Attempt:   event.Attempt,
+		Failed:    !event.Retried && (event.Status == tapjio.Fail || event.Status == tapjio.Error),
+		Exception: newHtmlException(event.Exception),
+		Stdout:    event.Stdout,
+		Stderr:    event.Stderr,
+	}
+	if event.File != "" {
+		test.Location = event.File.String()
+		if event.Line > 0 {
+			test.Location += ":" + strconv.Itoa(event.Line)
+		}
+	}
+	self.tests = append(self.tests, test)
+
+	// Attempts that were retried are only listed. Whether the test failed is up to its last one.
+	if !test.Failed {
+		return nil
+	}
+
+	digest, err := tapjio.OutcomeDigestFor(event.Status, event.Exception)
+	if err != nil {
+		return err
+	}
+	group, ok := self.groups[digest]
+	if !ok {
+		group = &htmlGroup{Digest: digest.String(), Status: string(event.Status)}
+		if event.Exception != nil {
+			group.Class = event.Exception.Class
+			group.Message = event.Exception.Message
+		}
+		self.groups[digest] = group
+	}
+	group.Tests = append(group.Tests, test)
+
+	return nil
+}
+
+func (self *Html) SuiteFinish(event tapjio.SuiteFinishEvent) error {
+	if len(self.suites) == 0 {
+		return nil
+	}
+
+	suite := self.suites[len(self.suites)-1]
+	suite.Duration = millisDuration(event.Time).String()
+	suite.Tally = event.Counts
+
+	return nil
+}
+
+type htmlGroups []*htmlGroup
+
+func (g htmlGroups) Len() int      { return len(g) }
+func (g htmlGroups) Swap(i, j int) { g[i], g[j] = g[j], g[i] }
+func (g htmlGroups) Less(i, j int) bool {
+	if len(g[i].Tests) != len(g[j].Tests) {
+		return len(g[i].Tests) > len(g[j].Tests)
+	}
+	return g[i].Digest < g[j].Digest
+}
+
+func (self *Html) End(reason error) error {
+	var groups htmlGroups
+	for _, group := range self.groups {
+		groups = append(groups, group)
+	}
+	sort.Sort(groups)
+
+	title := "qa"
+	if len(self.suites) > 0 && self.suites[0].Label != "" {
+		title = "qa: " + self.suites[0].Label
+	}
+
+	data := map[string]interface{}{
+		"Title":   title,
+		"Suites":  self.suites,
+		"Tally":   self.tally,
+		"Summary": strings.Join(tallyLabels(self.tally), ", "),
+		"Groups":  groups,
+		"Tests":   self.tests,
+	}
+	if reason != nil {
+		data["Reason"] = reason.Error()
+	}
+
+	err := htmlTemplate.Execute(self.writer, data)
+
+	if self.closer != nil {
+		closeErr := self.closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
+
+func tallyLabels(tally tapjio.ResultTally) []string {
+	var labels []string
+	add := func(count int, singular, plural string) {
+		if count > 0 {
+			labels = append(labels, strconv.Itoa(count)+" "+MaybePlural(count, singular, plural))
+		}
+	}
+	add(tally.Pass, "pass", "passes")
+	add(tally.Fail, "fail", "fails")
+	add(tally.Error, "error", "errors")
+	add(tally.Todo, "skip", "skips")
+	add(tally.Omit, "omit", "omits")
+	add(tally.Flaky, "flaky", "flaky")
+	add(tally.NotRun, "not run", "not run")
+
+	return labels
+}
+
+var htmlTemplate = template.Must(template.New("report").Parse(`<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>{{.Title}}</title>
+<style>
+body { font-family: -apple-system, "Helvetica Neue", Arial, sans-serif; margin: 2em; color: #222; }
+pre, code, .location { font-family: Menlo, Consolas, monospace; font-size: 12px; }
+pre { background: #f6f6f6; padding: 0.5em; overflow-x: auto; white-space: pre-wrap; }
+h2 { border-bottom: 1px solid #ddd; padding-bottom: 0.2em; }
+table { border-collapse: collapse; }
+th, td { text-align: left; padding: 0.2em 0.8em; border-bottom: 1px solid #eee; vertical-align: top; }
+th.sortable { cursor: pointer; user-select: none; }
+th.sortable:after { content: " \2195"; color: #aaa; }
+td.number { text-align: right; }
+.pass { color: #2a7d2a; } .fail { color: #c62828; } .error { color: #8e24aa; }
+.todo, .omit { color: #00838f; } .notrun { color: #777; } .flaky, .retried { color: #b8860b; }
+.status { font-weight: bold; }
+.group { margin-bottom: 2em; }
+.digest { color: #999; font-size: 11px; }
+.test { margin: 0.5em 0 0.5em 1em; }
+.frame { margin-left: 1em; }
+.internal { display: none; }
+body.show-internal .internal { display: block; }
+.focused { background: #fff3c4; font-weight: bold; }
+.diff .removed { color: #c62828; background: #fdecea; } .diff .added { color: #2a7d2a; background: #e8f5e9; }
+.diff .hunk { color: #00838f; }
+.variables td { font-family: Menlo, Consolas, monospace; font-size: 12px; border: none; padding: 0 0.8em 0 0; }
+</style>
+</head>
+<body>
+<h1>{{.Title}}</h1>
+{{if .Reason}}<p class="error">The run ended early: {{.Reason}}</p>{{end}}
+<p><strong>{{.Tally.Total}} tests</strong>{{if .Summary}}: {{.Summary}}{{end}}</p>
+
+<h2>Suites</h2>
+<table>
+<tr><th>Label</th><th>Coderef</th><th>Started</th><th>Seed</th><th>Tests</th><th>Duration</th><th>Results</th></tr>
+{{range .Suites}}<tr>
+<td>{{.Label}}</td><td><code>{{.Coderef}}</code></td><td>{{.Start}}</td><td>{{.Seed}}</td><td class="number">{{.Count}}</td><td class="number">{{.Duration}}</td>
+<td>{{with .Tally}}<span class="pass">{{.Pass}} passed</span>, <span class="fail">{{.Fail}} failed</span>, <span class="error">{{.Error}} errored</span>{{else}}<span class="error">did not finish</span>{{end}}</td>
+</tr>{{end}}
+</table>
+
+{{define "frames"}}{{range .}}<div class="frame{{if .Internal}} internal{{end}}">
+<div class="location">{{.Location}}{{if .Method}} in {{.Method}}{{end}}</div>
+{{if .Variables}}<table class="variables">{{range .Variables}}<tr><td>{{.Name}}</td><td>= {{.Value}}</td></tr>{{end}}</table>{{end}}
+{{if .Lines}}<pre>{{range .Lines}}<span{{if .Focused}} class="focused"{{end}}>{{printf "%4d" .Number}}  {{.Text}}</span>
+{{end}}</pre>{{end}}
+</div>{{end}}{{end}}
+
+{{if .Groups}}
+<h2>Failures</h2>
+<p><label><input type="checkbox" onclick="document.body.classList.toggle('show-internal', this.checked)"> Show internal frames</label></p>
+{{range .Groups}}<div class="group">
+<h3><span class="{{.Status}}">{{len .Tests}} &times; {{if .Class}}{{.Class}}: {{end}}</span>{{.Message}}</h3>
+<div class="digest">Outcome digest {{.Digest}}</div>
+{{range .Tests}}<div class="test" id="{{.ID}}">
+<details>
+<summary><span class="status {{.Status}}">{{.Status}}</span> {{.Label}}{{if .Attempt}} (attempt {{.Attempt}}){{end}} <span class="location">{{.Location}}</span> {{.Duration}}</summary>
+{{with .Exception}}{{if .Message}}<pre>{{.Message}}</pre>{{end}}
+{{if .Diff}}<pre class="diff"><span class="removed">--- expected</span>
+<span class="added">+++ actual</span>
+{{range .Diff}}<span class="{{.Class}}">{{.Text}}</span>
+{{end}}</pre>{{end}}
+{{template "frames" .Frames}}
+{{range .Threads}}<h4>Thread {{.Label}}</h4>{{template "frames" .Frames}}{{end}}{{end}}
+{{if .Stdout}}<h4>STDOUT</h4><pre>{{.Stdout}}</pre>{{end}}
+{{if .Stderr}}<h4>STDERR</h4><pre>{{.Stderr}}</pre>{{end}}
+</details>
+</div>{{end}}
+</div>{{end}}
+{{end}}
+
+<h2>Tests</h2>
+<table id="tests">
+<thead><tr><th class="sortable" data-type="text">Test</th><th class="sortable" data-type="text">Status</th><th class="sortable" data-type="number">Duration</th><th class="sortable" data-type="text">Location</th><th>Output</th></tr></thead>
+<tbody>
+{{range .Tests}}<tr>
+<td>{{if .Failed}}<a href="#{{.ID}}">{{.Label}}</a>{{else}}{{.Label}}{{end}}{{if .Attempt}} (attempt {{.Attempt}}){{end}}</td>
+<td class="status {{.Status}}">{{.Status}}</td>
+<td class="number" data-value="{{.Time}}">{{.Duration}}</td>
+<td class="location">{{.Location}}</td>
+<td>{{if or .Stdout .Stderr}}<details><summary>output</summary>{{if .Stdout}}<h4>STDOUT</h4><pre>{{.Stdout}}</pre>{{end}}{{if .Stderr}}<h4>STDERR</h4><pre>{{.Stderr}}</pre>{{end}}</details>{{end}}</td>
+</tr>{{end}}
+</tbody>
+</table>
+
+<script>
+(function() {
+  var table = document.getElementById("tests");
+  var headers = table.querySelectorAll("th.sortable");
+  Array.prototype.forEach.call(headers, function(header, column) {
+    var descending = false;
+    header.addEventListener("click", function() {
+      var numeric = header.getAttribute("data-type") === "number";
+      var body = table.tBodies[0];
+      var rows = Array.prototype.slice.call(body.rows);
+      var value = function(row) {
+        var cell = row.cells[column];
+        return numeric ? parseFloat(cell.getAttribute("data-value")) : cell.textContent;
+      };
+      descending = !descending;
+      rows.sort(function(a, b) {
+        var x = value(a), y = value(b);
+        var order = x < y ? -1 : x > y ? 1 : 0;
+        return descending ? -order : order;
+      });
+      rows.forEach(function(row) { body.appendChild(row); });
+    });
+  });
+})();
+</script>
+</body>
+</html>
+`))
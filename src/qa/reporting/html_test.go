@@ -0,0 +1,90 @@
+package reporting
+
+import (
+	"bytes"
+	"regexp"
+	"strings"
+	"testing"
+
+	"qa/tapjio"
+)
+
+func htmlFor(events []tapjio.TestFinishEvent) (string, error) {
+	buffer := &bytes.Buffer{}
+	html := NewHtmlEmitter(buffer)
+
+	suite := tapjio.SuiteBeginEvent{Type: "suite", Label: "app"}
+	html.SuiteBegin(suite)
+	for _, event := range events {
+		html.TestFinish(event)
+	}
+	html.SuiteFinish(*tapjio.NewSuiteFinishEvent(&suite))
+	err := html.End(nil)
+
+	return buffer.String(), err
+}
+
+func TestHtml(t *testing.T) {
+	invalid := func() *tapjio.TestException {
+		return &tapjio.TestException{
+			Class:   "RuntimeError",
+			Message: "<script>alert(1)</script>",
+			Backtrace: []tapjio.BacktraceLocation{
+				{File: "app/models/user.rb", Line: 5, Method: "save", Variables: map[string]string{"name": `"bob"`}},
+			},
+			Snippets: map[string]map[string]string{
+				"app/models/user.rb": {"4": "def save", "5": "  raise 'invalid'", "6": "end"},
+			},
+		}
+	}
+
+	events := []tapjio.TestFinishEvent{
+		{Type: "test", Label: "saves", Status: tapjio.Error, Exception: invalid(), Stdout: "<b>saving</b>"},
+		{Type: "test", Label: "updates", Status: tapjio.Error, Exception: invalid()},
+		{Type: "test", Label: "compares", Status: tapjio.Fail, File: "spec/user_spec.rb", Line: 9,
+			Exception: &tapjio.TestException{
+				Message:  "expected equal",
+				Expected: &tapjio.ComparedValue{Kind: "string", String: "one\ntwo\nthree"},
+				Actual:   &tapjio.ComparedValue{Kind: "string", String: "one\n2\nthree"},
+			}},
+		{Type: "test", Label: "sometimes", Status: tapjio.Fail, Retried: true, Attempt: 1,
+			Exception: &tapjio.TestException{Message: "only the first time"}},
+		{Type: "test", Label: "sometimes", Status: tapjio.Pass, Flaky: true, Attempt: 2},
+	}
+
+	report, err := htmlFor(events)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expected := range []string{
+		// Both errors share an outcome digest, so they're grouped together.
+		"2 &times; RuntimeError: </span>&lt;script&gt;alert(1)&lt;/script&gt;</h3>",
+		"1 &times; </span>expected equal</h3>",
+		"<h4>STDOUT</h4><pre>&lt;b&gt;saving&lt;/b&gt;</pre>",
+		`<span class="removed">-two</span>`,
+		`<span class="added">&#43;2</span>`,
+		`<span class="focused">   5    raise &#39;invalid&#39;</span>`,
+		"<tr><td>name</td><td>= &#34;bob&#34;</td></tr>",
+		`<td class="status retried">retried</td>`,
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("Expected report to contain %q:\n%s", expected, report)
+		}
+	}
+
+	if strings.Contains(report, "<script>alert(1)</script>") {
+		t.Fatalf("Expected messages to be escaped:\n%s", report)
+	}
+	if strings.Contains(report, "only the first time") {
+		t.Fatalf("Expected retried attempts to be left out of failures:\n%s", report)
+	}
+	if strings.Count(report, `<div class="group">`) != 2 {
+		t.Fatalf("Expected 2 groups of failures:\n%s", report)
+	}
+
+	// The report has to work offline, so it may only link within itself.
+	if external := regexp.MustCompile(`(src|href)="[^#]`).FindString(report); external != "" {
+		t.Fatalf("Expected no external assets, found %q:\n%s", external, report)
+	}
+}
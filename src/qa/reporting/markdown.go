@@ -0,0 +1,315 @@
+package reporting
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"path"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"qa/analysis"
+	"qa/tapjio"
+)
+
+// Markdown writes a short report meant to be posted as a pull request comment: the tally, a
+// table of failures, a collapsed block with the details of each, and the slowest tests.
+// Nothing is written until End is called.
+type Markdown struct {
+	// MaxBytes is how large the report may be. Failures that don't fit are left out, and a
+	// note says how many, then any of the slowest tests that don't fit. Zero means no limit.
+	MaxBytes int
+
+	// LinkBase, if given, turns each failure's location into a link, e.g.
+	// https://github.com/org/repo/blob/abc123/ links to spec/user_spec.rb:12 as
+	// https://github.com/org/repo/blob/abc123/spec/user_spec.rb#L12.
+	LinkBase string
+
+	writer   io.Writer
+	closer   io.Closer
+	label    string
+	tally    tapjio.ResultTally
+	time     float64
+	failures []tapjio.TestFinishEvent
+	timeCop  *analysis.TimeCop
+}
+
+// NewMarkdownEmitCloser returns a Markdown reporter that writes to writer, then closes it.
+func NewMarkdownEmitCloser(writer io.WriteCloser) *Markdown {
+	markdown := NewMarkdownEmitter(writer)
+	markdown.closer = writer
+	return markdown
+}
+
+func NewMarkdownEmitter(writer io.Writer) *Markdown {
+	return &Markdown{writer: writer, timeCop: &analysis.TimeCop{MaxResults: 5}}
+}
+
+func (self *Markdown) TraceEvent(event tapjio.TraceEvent) error {
+	return nil
+}
+
+func (self *Markdown) AwaitAttach(event tapjio.AwaitAttachEvent) error {
+	return nil
+}
+
+func (self *Markdown) SuiteBegin(event tapjio.SuiteBeginEvent) error {
+	if self.label == "" {
+		self.label = event.Label
+	}
+
+	return nil
+}
+
+func (self *Markdown) TestBegin(event tapjio.TestBeginEvent) error {
+	return nil
+}
+
+func (self *Markdown) TestFinish(event tapjio.TestFinishEvent) error {
+	self.tally.IncrementFor(event)
+	self.timeCop.TestFinish(event)
+
+	if !event.Retried && (event.Status == tapjio.Fail || event.Status == tapjio.Error) {
+		self.failures = append(self.failures, event)
+	}
+
+	return nil
+}
+
+func (self *Markdown) SuiteFinish(event tapjio.SuiteFinishEvent) error {
+	self.time += event.Time
+	return nil
+}
+
+// markdownEscape escapes text so it can go in a table cell or a line of its own.
+func markdownEscape(text string) string {
+	text = strings.Replace(text, "\n", " ", -1)
+
+	buffer := &bytes.Buffer{}
+	for _, r := range text {
+		switch r {
+		case '\\', '`', '*', '_', '[', ']', '|', '#':
+			buffer.WriteRune('\\')
+			buffer.WriteRune(r)
+		case '<':
+			buffer.WriteString("&lt;")
+		case '>':
+			buffer.WriteString("&gt;")
+		default:
+			buffer.WriteRune(r)
+		}
+	}
+
+	return buffer.String()
+}
+
+// markdownFence returns a code fence that doesn't appear in text.
+func markdownFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+
+	return fence
+}
+
+func (self *Markdown) formatLocation(file string, line int) string {
+	if file == "" {
+		return ""
+	}
+
+	text := file
+	if line > 0 {
+		text += ":" + strconv.Itoa(line)
+	}
+	if self.LinkBase == "" || path.IsAbs(file) {
+		return "`" + text + "`"
+	}
+
+	link := strings.TrimSuffix(self.LinkBase, "/") + "/" + strings.TrimPrefix(file, "./")
+	if line > 0 {
+		link += "#L" + strconv.Itoa(line)
+	}
+
+	return fmt.Sprintf("[%s](%s)", markdownEscape(text), link)
+}
+
+func (self *Markdown) formatDetails(event tapjio.TestFinishEvent) string {
+	body := &bytes.Buffer{}
+	message := ""
+	if exception := event.Exception; exception != nil {
+		message = exception.Message
+		if event.Status == tapjio.Error && exception.Class != "" {
+			fmt.Fprintf(body, "%s: ", exception.Class)
+		}
+		fmt.Fprintf(body, "%s\n", exception.Message)
+		for _, entry := range exception.Backtrace {
+			if entry.Internal {
+				continue
+			}
+			if entry.Method != "" {
+				fmt.Fprintf(body, "    %s:%d in %s\n", entry.File, entry.Line, entry.Method)
+			} else {
+				fmt.Fprintf(body, "    %s:%d\n", entry.File, entry.Line)
+			}
+		}
+	}
+	if event.Stdout != "" {
+		fmt.Fprintf(body, "\nSTDOUT:\n%s\n", strings.TrimRight(event.Stdout, "\n"))
+	}
+	if event.Stderr != "" {
+		fmt.Fprintf(body, "\nSTDERR:\n%s\n", strings.TrimRight(event.Stderr, "\n"))
+	}
+
+	summary := markdownEscape(tapjio.TestLabel(event.Label, event.Cases))
+	if line := strings.SplitN(message, "\n", 2)[0]; line != "" {
+		summary += ": " + markdownEscape(line)
+	}
+
+	fence := markdownFence(body.String())
+	return fmt.Sprintf("<details><summary>%s</summary>\n\n%s\n%s%s\n\n</details>\n\n", summary, fence, body.String(), fence)
+}
+
+func (self *Markdown) formatHeader() string {
+	status := "✅"
+	if self.tally.Fail > 0 || self.tally.Error > 0 {
+		status = "❌"
+	}
+
+	title := "qa"
+	if self.label != "" {
+		title = markdownEscape(self.label)
+	}
+
+	summary := strings.Join(tallyLabels(self.tally), ", ")
+	if summary == "" {
+		summary = "no tests"
+	}
+
+	return fmt.Sprintf("### %s %s: %s (%d %s in %v)\n\n",
+		status, title, summary,
+		self.tally.Total, MaybePlural(self.tally.Total, "test", "tests"),
+		millisDuration(self.time))
+}
+
+// formatSlowTests returns the table of slowest tests, with as many rows as fits allows.
+func (self *Markdown) formatSlowTests(fits func(text string) bool) string {
+	self.timeCop.SuiteFinish(tapjio.SuiteFinishEvent{})
+
+	var rows []string
+	for _, outcome := range self.timeCop.SlowPassingOutcomes {
+		// When every test is quick, even the slowest aren't worth mentioning.
+		if millisDuration(outcome.Duration) == 0 {
+			continue
+		}
+		rows = append(rows, fmt.Sprintf("| %s | %v |\n", markdownEscape(outcome.Label), millisDuration(outcome.Duration)))
+	}
+	if len(rows) == 0 {
+		return ""
+	}
+
+	// Only worth a table if at least one row fits under its heading.
+	table := "#### Slowest tests\n\n| Test | Duration |\n| --- | ---: |\n"
+	if !fits(table + rows[0] + "\n") {
+		return ""
+	}
+	table += rows[0]
+	for _, row := range rows[1:] {
+		if !fits(row) {
+			break
+		}
+		table += row
+	}
+
+	return table + "\n"
+}
+
+// The room kept for the note saying how many failures were left out.
+const markdownOmittedNoteSize = 100
+
+// render returns the report, saying why the run ended early if reason is given. It's kept
+// within MaxBytes by leaving out failures, then slowest tests, that don't fit.
+func (self *Markdown) render(reason error) string {
+	header := self.formatHeader()
+	ended := ""
+	if reason != nil {
+		ended = fmt.Sprintf("_The run ended early: %s_\n", markdownEscape(reason.Error()))
+	}
+
+	budget := self.MaxBytes - len(header) - len(ended) - markdownOmittedNoteSize
+	fits := func(text string) bool {
+		if self.MaxBytes <= 0 {
+			return true
+		}
+		if len(text) > budget {
+			return false
+		}
+		budget -= len(text)
+		return true
+	}
+
+	failures := &bytes.Buffer{}
+	tableHeader := "| | Test | Location |\n| --- | --- | --- |\n"
+	shown := 0
+	if len(self.failures) > 0 && fits(tableHeader) {
+		failures.WriteString(tableHeader)
+		for _, event := range self.failures {
+			file, line := failureLocation(event)
+			row := fmt.Sprintf("| %s | %s | %s |\n",
+				event.Status,
+				markdownEscape(tapjio.TestLabel(event.Label, event.Cases)),
+				self.formatLocation(file, line))
+			if !fits(row) {
+				break
+			}
+			failures.WriteString(row)
+			shown++
+		}
+		failures.WriteString("\n")
+	}
+
+	detailed := 0
+	for _, event := range self.failures[:shown] {
+		details := self.formatDetails(event)
+		if !fits(details) {
+			break
+		}
+		failures.WriteString(details)
+		detailed++
+	}
+
+	if omitted := len(self.failures) - shown; omitted > 0 {
+		fmt.Fprintf(failures, "_…and %d more %s not shown._\n\n", omitted, MaybePlural(omitted, "failure", "failures"))
+	} else if omitted := shown - detailed; omitted > 0 {
+		fmt.Fprintf(failures, "_Details of %d more %s not shown._\n\n", omitted, MaybePlural(omitted, "failure", "failures"))
+	}
+
+	report := header + failures.String() + self.formatSlowTests(fits) + ended
+
+	// Only a huge label or reason could leave no room at all; cut those short.
+	if self.MaxBytes > 0 && len(report) > self.MaxBytes {
+		cut := self.MaxBytes
+		for cut > 0 && !utf8.RuneStart(report[cut]) {
+			cut--
+		}
+		report = report[:cut]
+	}
+
+	return report
+}
+
+func (self *Markdown) End(reason error) error {
+	report := self.render(reason)
+
+	_, err := io.WriteString(self.writer, report)
+
+	if self.closer != nil {
+		closeErr := self.closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
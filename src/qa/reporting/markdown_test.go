@@ -0,0 +1,119 @@
+package reporting
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+
+	"qa/tapjio"
+)
+
+func markdownFor(markdown *Markdown, events []tapjio.TestFinishEvent) error {
+	suite := tapjio.SuiteBeginEvent{Type: "suite", Label: "app"}
+	markdown.SuiteBegin(suite)
+	for _, event := range events {
+		markdown.TestFinish(event)
+	}
+	markdown.SuiteFinish(*tapjio.NewSuiteFinishEvent(&suite))
+	return markdown.End(nil)
+}
+
+func TestMarkdown(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	markdown := NewMarkdownEmitter(buffer)
+	markdown.LinkBase = "https://example.com/repo/blob/abc/"
+
+	events := []tapjio.TestFinishEvent{
+		{Type: "test", Label: "validates | names", Status: tapjio.Fail, File: "spec/user_spec.rb", Line: 3,
+			Exception: &tapjio.TestException{
+				Message: "expected ```valid```",
+				Backtrace: []tapjio.BacktraceLocation{
+					{File: "/gems/rspec.rb", Line: 1, Internal: true},
+					{File: "app/models/user.rb", Line: 12, User: true},
+				},
+			}},
+	}
+	for i := 0; i < 10; i++ {
+		events = append(events, tapjio.TestFinishEvent{Type: "test", Label: fmt.Sprintf("fast %d", i), Status: tapjio.Pass, Time: 0.01})
+	}
+	events = append(events, tapjio.TestFinishEvent{Type: "test", Label: "slow", Status: tapjio.Pass, Time: 2})
+
+	err := markdownFor(markdown, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buffer.String()
+
+	for _, expected := range []string{
+		"### ❌ app: 11 passes, 1 fail (12 tests in",
+		"| fail | validates \\| names | [app/models/user.rb:12](https://example.com/repo/blob/abc/app/models/user.rb#L12) |",
+		"<details><summary>validates \\| names: expected \\`\\`\\`valid\\`\\`\\`</summary>",
+		"````\nexpected ```valid```\n    app/models/user.rb:12\n````",
+		"| slow | 2s |",
+	} {
+		if !strings.Contains(report, expected) {
+			t.Fatalf("Expected report to contain %q:\n%s", expected, report)
+		}
+	}
+	if strings.Contains(report, "rspec.rb") {
+		t.Fatalf("Expected internal frames to be left out:\n%s", report)
+	}
+}
+
+func TestMarkdownMaxBytes(t *testing.T) {
+	var events []tapjio.TestFinishEvent
+	for i := 0; i < 200; i++ {
+		events = append(events, tapjio.TestFinishEvent{Type: "test", Label: fmt.Sprintf("test %d", i), Status: tapjio.Error,
+			Exception: &tapjio.TestException{Class: "RuntimeError", Message: strings.Repeat("boom ", 20)}})
+	}
+
+	buffer := &bytes.Buffer{}
+	markdown := NewMarkdownEmitter(buffer)
+	markdown.MaxBytes = 4000
+	err := markdownFor(markdown, events)
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buffer.String()
+
+	if len(report) > markdown.MaxBytes {
+		t.Fatalf("Expected report to be at most %d bytes, got %d:\n%s", markdown.MaxBytes, len(report), report)
+	}
+	if !strings.Contains(report, "| error | test 0 |") || !strings.Contains(report, "more failures not shown._") {
+		t.Fatalf("Expected report to be truncated with a note:\n%s", report)
+	}
+}
+
+func TestMarkdownMaxBytesIncludesSlowTestsAndReason(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	markdown := NewMarkdownEmitter(buffer)
+	markdown.MaxBytes = 400
+
+	suite := tapjio.SuiteBeginEvent{Type: "suite", Label: "app"}
+	markdown.SuiteBegin(suite)
+	for i := 0; i < 20; i++ {
+		markdown.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: fmt.Sprintf("fast %d", i), Status: tapjio.Pass, Time: 0.01})
+	}
+	for i := 0; i < 5; i++ {
+		markdown.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: fmt.Sprintf("slow %d %s", i, strings.Repeat("x", 80)),
+			Status: tapjio.Pass, Time: float64(5 - i)})
+	}
+	markdown.SuiteFinish(*tapjio.NewSuiteFinishEvent(&suite))
+	err := markdown.End(errors.New("interrupted"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	report := buffer.String()
+
+	if len(report) > markdown.MaxBytes {
+		t.Fatalf("Expected report to be at most %d bytes, got %d:\n%s", markdown.MaxBytes, len(report), report)
+	}
+	if !strings.Contains(report, "| slow 0 ") || strings.Contains(report, "| slow 4 ") {
+		t.Fatalf("Expected slowest tests to be cut short:\n%s", report)
+	}
+	if !strings.HasSuffix(report, "_The run ended early: interrupted_\n") {
+		t.Fatalf("Expected report to say why the run ended:\n%s", report)
+	}
+}
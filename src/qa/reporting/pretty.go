@@ -16,6 +16,9 @@ type Pretty struct {
 	ShowSnails          bool
 	ShowIndividualTests bool
 
+	// If set, tests that pass significantly slower than their archived history are listed.
+	Regressions *analysis.RegressionDetector
+
 	writer        io.Writer
 	varyingSeeds  bool
 	runs          int
@@ -33,6 +36,12 @@ type Pretty struct {
 
 	pending map[tapjio.TestFilter]string
 
+	// The tests of the current suite that went over their duration budgets.
+	overBudget []tapjio.TestFinishEvent
+
+	suite       *tapjio.SuiteBeginEvent
+	regressions []analysis.Regression
+
 	style *Style
 }
 
@@ -58,6 +67,9 @@ func (self *Pretty) AwaitAttach(event tapjio.AwaitAttachEvent) error {
 func (self *Pretty) SuiteBegin(suite tapjio.SuiteBeginEvent) error {
 	self.pending = make(map[tapjio.TestFilter]string)
 	self.timeCop = &analysis.TimeCop{MaxResults: 10}
+	self.overBudget = nil
+	self.suite = &suite
+	self.regressions = nil
 
 	self.run += 1
 	self.seed = suite.Seed
@@ -172,20 +184,36 @@ func (self *Pretty) TestBegin(event tapjio.TestBeginEvent) error {
 
 func (self *Pretty) TestFinish(test tapjio.TestFinishEvent) error {
 	self.timeCop.TestFinish(test)
-	self.tally.Increment(test.Status)
+	self.tally.IncrementFor(test)
 
 	self.totalTestTime += test.Time
 
+	if test.Budget != nil && !test.Retried {
+		self.overBudget = append(self.overBudget, test)
+	}
+
+	if self.Regressions != nil {
+		if regression := self.Regressions.CheckTest(test, self.suite); regression != nil {
+			self.regressions = append(self.regressions, *regression)
+		}
+	}
+
 	self.clearSummary()
 
 	delete(self.pending, test.Filter)
 	defer self.writeSummary()
 
-	if !self.ShowIndividualTests {
+	// Some runners, like tap, only learn how many tests there are by running them.
+	if self.tally.Total+len(self.pending) > self.totalTests {
+		self.totalTests = self.tally.Total + len(self.pending)
+	}
+
+	// Tests that never ran have nothing to show. They're counted in the summary.
+	if !self.ShowIndividualTests || test.Status == tapjio.NotRun {
 		return nil
 	}
 
-	if ((self.ElideQuietPass && test.Status == tapjio.Pass) ||
+	if ((self.ElideQuietPass && test.Status == tapjio.Pass && !test.Flaky) ||
 		(self.ElideQuietOmit && test.Status == tapjio.Omit)) &&
 		test.Stdout == "" && test.Stderr == "" {
 		return nil
@@ -209,6 +237,27 @@ func (self *Pretty) SuiteFinish(final tapjio.SuiteFinishEvent) error {
 
 	counts := final.Counts
 
+	// Budget overruns are listed apart from the snails, which are only slow relative to the rest.
+	if len(self.overBudget) > 0 || final.Budget != nil {
+		if !self.mostRecentTestPrintedSpacingNewline {
+			fmt.Fprintf(self.writer, "\n")
+		}
+
+		self.style.SummarizeBudgetOverruns(self.writer, self.overBudget, final)
+		self.mostRecentTestPrintedSpacingNewline = true
+	}
+
+	// Unlike snails, tests slower than they used to be are worth knowing about even when
+	// others fail.
+	if len(self.regressions) > 0 {
+		if !self.mostRecentTestPrintedSpacingNewline {
+			fmt.Fprintf(self.writer, "\n")
+		}
+
+		self.style.SummarizeRegressions(self.writer, self.regressions)
+		self.mostRecentTestPrintedSpacingNewline = true
+	}
+
 	// If there are errors/fails don't show any SLOW PASSes
 	if self.ShowSnails {
 		if self.timeCop.Passed() && len(self.timeCop.SlowPassingOutcomes) > 0 {
@@ -221,11 +270,33 @@ func (self *Pretty) SuiteFinish(final tapjio.SuiteFinishEvent) error {
 	}
 
 	if self.runs == self.run {
-		fmt.Fprintf(self.writer, "🏁  Ran %d tests in %v (%v of job time): %s.\n",
+		estimate := ""
+		if estimatedMillis, ok := final.Stats[tapjio.EstimatedMakespanStat]; ok {
+			estimated := float64(estimatedMillis) / 1000
+			// The estimate only covers running tests, so leave out startup if we know how long it took.
+			makespan, startup := final.Time, ""
+			if makespanMillis, ok := final.Stats[tapjio.MakespanStat]; ok {
+				makespan = float64(makespanMillis) / 1000
+				startup = fmt.Sprintf(" after %v of startup", millisDuration(final.Time-makespan))
+			}
+			gap, direction := makespan-estimated, "over"
+			if gap < 0 {
+				gap, direction = -gap, "under"
+			}
+			estimate = fmt.Sprintf(", %v %s the %v estimate%s", millisDuration(gap), direction, millisDuration(estimated), startup)
+		}
+
+		fmt.Fprintf(self.writer, "🏁  Ran %d tests in %v (%v of job time%s): %s.\n",
 			counts.Total,
 			millisDuration(final.Time),
 			millisDuration(self.timeCop.TotalDuration),
+			estimate,
 			self.style.FormatTally(*counts))
+
+		if recycles := final.Stats[tapjio.WorkerRecyclesStat]; recycles > 0 {
+			fmt.Fprintf(self.writer, "♻️  Replaced %d %s that outgrew -worker-max-rss.\n",
+				recycles, MaybePlural(recycles, "worker", "workers"))
+		}
 	}
 
 	return nil
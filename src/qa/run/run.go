@@ -5,8 +5,11 @@ import (
 	"log"
 	"os"
 	"qa/runner"
+	"qa/runner/external"
+	"qa/runner/gotest"
 	"qa/runner/ruby"
 	"qa/runner/server"
+	"qa/runner/tap"
 	"qa/tapjio"
 	"runtime"
 	"runtime/debug"
@@ -20,18 +23,45 @@ type Env struct {
 	SuiteCoderef      string
 	WorkerEnvs        []map[string]string
 	RunnerConfigs     []runner.Config
+	DurationEstimator runner.DurationEstimator
 	Visitor           tapjio.Visitor
 	Server            *server.Server
 	TestRunnerVisitor func(testRunner runner.TestRunner, lastRunner bool) error
 	Memprofile        string
 	Heapdump          string
 	Runs              int
+
+	// FailFast, if positive, is the number of failed or errored tests after which we stop.
+	FailFast int
+
+	// Retries is how many more times to run a failing or erroring test before giving up on it.
+	Retries int
+
+	// Budgets, if set, limit how long tests and suites may take.
+	Budgets *runner.Budgets
+
+	// Shard, if ShardCount is more than 1, is which (1-based) part of the suite to run.
+	Shard      int
+	ShardCount int
+
+	// ShardDurationEstimator, if set, balances shards by duration rather than by test count.
+	// Every shard must be given the same one, or they won't agree on which tests to run.
+	ShardDurationEstimator runner.DurationEstimator
+
+	// Arrange, if set, decides the order to run the enumerated runners in, instead of Schedule.
+	// It may split runners into subsets, but must keep every test.
+	Arrange func(runners []runner.TestRunner) []runner.TestRunner
+
+	// StartContext, if set, is used instead of StartContext to start a context for each runner
+	// config, e.g. to run tests on remote agents.
+	StartContext func(runnerConfig runner.Config) (runner.Context, error)
 }
 
 var defaultGlobs = map[string]string{
 	"rspec":     "spec/**/*spec.rb",
 	"minitest":  "test/**/test*.rb",
 	"test-unit": "test/**/test*.rb",
+	"gotest":    gotest.DefaultPattern,
 }
 
 func DefaultGlob(runner string) string {
@@ -64,10 +94,49 @@ func rubyContextStarter(runnerAssetName string) contextStarter {
 	}
 }
 
+// goContextStarter starts a context for Go tests. go test starts a process for each runner,
+// so there are no workers to start ahead of time.
+func goContextStarter(
+	srv *server.Server,
+	workerEnvs []map[string]string,
+	runnerConfig runner.Config) (runner.Context, error) {
+
+	return gotest.StartContext(runnerConfig)
+}
+
+func tapContextStarter(
+	srv *server.Server,
+	workerEnvs []map[string]string,
+	runnerConfig runner.Config) (runner.Context, error) {
+
+	return tap.StartContext(runnerConfig)
+}
+
 var starters = map[string]contextStarter{
 	"rspec":     rubyContextStarter("ruby/rspec.rb"),
 	"minitest":  rubyContextStarter("ruby/minitest.rb"),
 	"test-unit": rubyContextStarter("ruby/test-unit.rb"),
+	"gotest":    goContextStarter,
+	"tap":       tapContextStarter,
+}
+
+// StartContext starts a context for the given runner config on this machine, with one worker
+// for each of workerEnvs.
+func StartContext(
+	srv *server.Server,
+	workerEnvs []map[string]string,
+	runnerConfig runner.Config) (runner.Context, error) {
+
+	if len(runnerConfig.Command) > 0 {
+		return external.StartContext(srv, runnerConfig)
+	}
+
+	starter, ok := starters[runnerConfig.Name]
+	if !ok {
+		return nil, errors.New("Could not find starter: " + runnerConfig.Name)
+	}
+
+	return starter(srv, workerEnvs, runnerConfig)
 }
 
 func Run(env *Env) (bool, error) {
@@ -77,12 +146,13 @@ func Run(env *Env) (bool, error) {
 	var testRunners []runner.TestRunner
 	count := 0
 	for _, runnerConfig := range env.RunnerConfigs {
-		starter, ok := starters[runnerConfig.Name]
-		if !ok {
-			return false, errors.New("Could not find starter: " + runnerConfig.Name)
+		var ctx runner.Context
+		var err error
+		if env.StartContext != nil {
+			ctx, err = env.StartContext(runnerConfig)
+		} else {
+			ctx, err = StartContext(env.Server, env.WorkerEnvs, runnerConfig)
 		}
-
-		ctx, err := starter(env.Server, env.WorkerEnvs, runnerConfig)
 		if err != nil {
 			return false, err
 		}
@@ -116,6 +186,22 @@ func Run(env *Env) (bool, error) {
 		}
 	}
 
+	if env.ShardCount > 1 {
+		testRunners = runner.Shard(testRunners, env.ShardDurationEstimator, env.Shard, env.ShardCount)
+
+		count = 0
+		for _, testRunner := range testRunners {
+			count += testRunner.TestCount()
+		}
+	}
+
+	var estimatedMakespan float64
+	if env.Arrange != nil {
+		testRunners = env.Arrange(testRunners)
+	} else {
+		estimatedMakespan = runner.Schedule(testRunners, env.DurationEstimator, len(env.WorkerEnvs))
+	}
+
 	var err error
 	passed := true
 
@@ -128,13 +214,49 @@ func Run(env *Env) (bool, error) {
 		suiteEvent := tapjio.NewSuiteBeginEvent(startTime, count, seed)
 		suiteEvent.Label = env.SuiteLabel
 		suiteEvent.Coderef = env.SuiteCoderef
+		if env.ShardCount > 1 {
+			suiteEvent.Shard = env.Shard
+			suiteEvent.ShardCount = env.ShardCount
+		}
 		err = visitor.SuiteBegin(*suiteEvent)
 		if err != nil {
 			return false, visitEnd(visitor, err)
 		}
 
 		final := *tapjio.NewSuiteFinishEvent(suiteEvent)
-		err = runner.RunAll(visitor, env.WorkerEnvs, final.Counts, seed, testRunners)
+		if estimatedMakespan > 0 {
+			final.Stats[tapjio.EstimatedMakespanStat] = int(estimatedMakespan * 1000)
+		}
+
+		// When the first test began and the last one finished, to measure the makespan by.
+		var testsBegan, testsFinished time.Time
+		runVisitor := tapjio.MultiVisitor([]tapjio.Visitor{
+			visitor,
+			&tapjio.DecodingCallbacks{
+				OnTestBegin: func(event tapjio.TestBeginEvent) error {
+					if testsBegan.IsZero() {
+						testsBegan = time.Now()
+					}
+					return nil
+				},
+				OnTestFinish: func(event tapjio.TestFinishEvent) error {
+					testsFinished = time.Now()
+					// Not every runner reports when its tests begin.
+					if testsBegan.IsZero() {
+						testsBegan = testsFinished.Add(-time.Duration(event.Time * float64(time.Second)))
+					}
+					return nil
+				},
+				OnTrace: func(event tapjio.TraceEvent) error {
+					if event.Data != nil && event.Data.Name == tapjio.WorkerRecycleTraceName {
+						final.IncrementStat(tapjio.WorkerRecyclesStat, 1)
+					}
+					return nil
+				},
+			},
+		})
+
+		err = runner.RunAll(runVisitor, env.WorkerEnvs, final.Counts, seed, env.FailFast, env.Retries, env.Budgets, testRunners)
 		if !final.Passed() {
 			passed = false
 		}
@@ -144,6 +266,13 @@ func Run(env *Env) (bool, error) {
 		}
 
 		final.Time = time.Now().UTC().Sub(startTime).Seconds()
+		if !testsBegan.IsZero() {
+			final.Stats[tapjio.MakespanStat] = int(testsFinished.Sub(testsBegan).Seconds() * 1000)
+		}
+		env.Budgets.CheckSuite(&final)
+		if !final.Passed() {
+			passed = false
+		}
 
 		finalErr := visitor.SuiteFinish(final)
 		if err == nil {
@@ -153,6 +282,11 @@ func Run(env *Env) (bool, error) {
 		if err != nil {
 			break
 		}
+
+		// Don't bother with the remaining runs if we've already had enough failures.
+		if env.FailFast > 0 && final.Counts.Fail+final.Counts.Error >= env.FailFast {
+			break
+		}
 	}
 
 	if env.Memprofile != "" {
@@ -181,8 +181,24 @@ module ::Qa::MinitestAttachDebugger
   end
 end
 
+# Minitest diffs long values itself, with the diff command if there is one. Once we have what
+# was compared, the message only needs to say what it is.
+module ::Qa::MinitestNoteComparisons
+  def assert_equal(exp, act, msg = nil)
+    super
+  rescue ::Minitest::Assertion => e
+    message = e.message.sub(/^--- expected\n\+\+\+ actual\n.*\z/m) do
+      max = ::Qa::TapjExceptions::MAX_VAR_VALUE_LENGTH
+      "Expected: #{mu_pp(exp)[0...max]}\n  Actual: #{mu_pp(act)[0...max]}"
+    end
+    ::Qa::TapjExceptions.note_comparison(e, exp, act, message)
+    raise
+  end
+end
+
 class ::Minitest::Test
   include ::Qa::MinitestAttachDebugger
+  include ::Qa::MinitestNoteComparisons
 end
 
 module ::Qa::MinitestDryRunnerClassMethods
@@ -208,12 +224,14 @@ engine.def_run_tests do |qa_trace, opt, tapj_conduit, tests|
     Minitest::Test.send(:extend, ::Qa::MinitestRunnerClassMethods)
   end
 
-  filter = tests.empty? ? nil : "/^(#{tests.map{|test|Regexp.escape(test)} * '|'})$/"
+  filter_for = lambda do |names|
+    names.empty? ? nil : "/^(#{names.map{|test|Regexp.escape(test)} * '|'})$/"
+  end
   options = {
     seed: opt.seed,
     io: tapj_conduit,
     trace: qa_trace,
-    filter: filter,
+    filter: filter_for.(tests),
   }
 
   srand(options[:seed] % 0xFFFF)
@@ -233,7 +251,14 @@ engine.def_run_tests do |qa_trace, opt, tapj_conduit, tests|
 
   Minitest.reporter = nil # runnables shouldn't depend on the reporter, ever
   reporter.start
-  Minitest.__run(reporter, options)
+  if opt.last && tests.include?(opt.last)
+    # Run the test that must go last on its own, once the rest are done.
+    others = tests - [opt.last]
+    Minitest.__run(reporter, options.merge(filter: filter_for.(others))) unless others.empty?
+    Minitest.__run(reporter, options.merge(filter: filter_for.([opt.last])))
+  else
+    Minitest.__run(reporter, options)
+  end
   reporter.report
 end
 
@@ -270,12 +270,36 @@ end
   prepend ::Qa::Rspec::MaybeAttachDebuggerBeforeAfterHook
 end
 
+# Diffable matchers like eq pass what they compared along with their message, which RSpec
+# appends a diff of its own to.
+module ::Qa::Rspec::NoteComparisons
+  def fail_with(message, *compared)
+    super
+  rescue ::RSpec::Expectations::ExpectationNotMetError => e
+    if compared.length == 2
+      ::Qa::TapjExceptions.note_comparison(e, *compared, message)
+    end
+    raise
+  end
+end
+
+::RSpec::Expectations.singleton_class.class_eval do
+  prepend ::Qa::Rspec::NoteComparisons
+end
+
 engine.def_run_tests do |qa_trace, opt, tapj_conduit, tests|
   world = ::RSpec.world
   rspec_config = ::RSpec.configuration
 
+  # The tests to run in each pass. The test that must go last gets a pass of its own.
+  passes = [tests]
+  if opt.last && tests.include?(opt.last)
+    passes = [tests - [opt.last], [opt.last]]
+  end
+
+  selected = Set.new(tests)
   unless tests.empty?
-    world.filter_manager.include(:qa_filter => lambda { |v| tests.include?(v) })
+    world.filter_manager.include(:qa_filter => lambda { |v| selected.include?(v) })
   end
 
   formatter = ::Qa::Rspec::TapjFormatter.new(qa_trace, tapj_conduit)
@@ -293,8 +317,15 @@ engine.def_run_tests do |qa_trace, opt, tapj_conduit, tests|
 
   reporter.report(world.example_count(groups)) do |reporter|
     rspec_config.with_suite_hooks do
-      groups.each do |g|
-        g.run(reporter)
+      passes.each do |pass|
+        unless tests.empty?
+          selected.replace(pass)
+          world.filtered_examples.clear
+        end
+
+        groups.each do |g|
+          g.run(reporter)
+        end
       end
     end
   end
@@ -1019,7 +1019,7 @@ module Qa::TapjExceptions
         backtrace_bindings = backtrace_bindings[1..-1] if backtrace_bindings
       end
 
-      message ||= error.message
+      message ||= error.instance_variable_get(:@__qa_message) || error.message
     end
 
     # eliminate ourselves from the list.
@@ -1135,6 +1135,10 @@ module Qa::TapjExceptions
       'backtrace' => backtrace,
     }
 
+    if comparison = error.instance_variable_get(:@__qa_comparison)
+      h['expected'], h['actual'] = comparison.map { |value| summarize_compared_value(value) }
+    end
+
     if error.is_a?(LoadError)
       h['load_error_path'] = error.instance_variable_get(:@__qa_path) || error.path
       if load_path = error.instance_variable_get(:@__qa_load_path)
@@ -1145,6 +1149,50 @@ module Qa::TapjExceptions
     h
   end
 
+  # Remembers what a failed equality assertion compared, so summarize_exception can send both
+  # values along. The message to use instead of the error's own can be given too, for when a
+  # framework has already added a diff of its own to it.
+  def note_comparison(error, expected, actual, message=nil)
+    error.instance_variable_set(:@__qa_comparison, [expected, actual])
+    error.instance_variable_set(:@__qa_message, message) if message
+  end
+
+  MAX_COMPARED_VALUE_LENGTH = 65536
+  MAX_COMPARED_VALUE_PARTS = 1000
+
+  # Strings, arrays and hashes are sent along with their parts, so they can be shown as a diff.
+  def summarize_compared_value(value)
+    h = {'kind' => 'object', 'inspect' => safe_inspect(value)[0...MAX_COMPARED_VALUE_LENGTH]}
+
+    case value
+    when String
+      string = value.encode('UTF-16', :invalid => :replace, :undef => :replace).encode('UTF-8')
+      h['kind'] = 'string'
+      h['string'] = string[0...MAX_COMPARED_VALUE_LENGTH]
+      h['truncated'] = true if string.length > MAX_COMPARED_VALUE_LENGTH
+    when Array
+      h['kind'] = 'array'
+      h['elements'] = value.first(MAX_COMPARED_VALUE_PARTS).map do |element|
+        safe_inspect(element)[0...MAX_VAR_VALUE_LENGTH]
+      end
+      h['truncated'] = true if value.length > MAX_COMPARED_VALUE_PARTS
+    when Hash
+      h['kind'] = 'hash'
+      h['entries'] = value.first(MAX_COMPARED_VALUE_PARTS).map do |key, element|
+        [safe_inspect(key)[0...MAX_VAR_VALUE_LENGTH], safe_inspect(element)[0...MAX_VAR_VALUE_LENGTH]]
+      end
+      h['truncated'] = true if value.length > MAX_COMPARED_VALUE_PARTS
+    end
+
+    h
+  end
+
+  def safe_inspect(value)
+    value.inspect
+  rescue
+    "<internal error during inspect>"
+  end
+
   # (number of surrounding lines to show)
   CODE_SNIPPET_RADIUS = 10
 
@@ -1228,7 +1276,8 @@ class ::Qa::TapjConduit
         'qa:label' => label,
         'qa:subtype' => subtype,
         'qa:filter' => filter,
-        'qa:file' => file)
+        'qa:file' => file,
+        'qa:pid' => Process.pid)
     flush
   end
 
@@ -1303,6 +1352,40 @@ class ::Qa::TapjConduit
     flush
   end
 
+  # Reports the test currently running as an error, including the backtrace of every thread.
+  # Used when a test has been running for too long and is about to be killed.
+  def emit_timeout_event
+    test, test_thread = @mutex.synchronize { [@current_test, @current_test_thread] }
+    return unless test
+
+    error = ::Qa::Timeout.new("Timed out")
+    exception = ::Qa::TapjExceptions.summarize_exception(error, (test_thread && test_thread.backtrace) || [])
+    exception['threads'] = Thread.list.map do |thread|
+      next if thread == test_thread || thread == Thread.current
+
+      summary = ::Qa::TapjExceptions.summarize_exception(error, thread.backtrace || [])
+      summary['snippets'].each do |file, snippet|
+        (exception['snippets'][file] ||= {}).update(snippet)
+      end
+
+      {
+        'label' => thread.inspect,
+        'backtrace' => summary['backtrace'],
+      }
+    end.compact
+
+    emit(
+        'type' => 'test',
+        'label' => test['qa:label'],
+        'subtype' => test['qa:subtype'],
+        'filter' => test['qa:filter'],
+        'file' => test['qa:file'],
+        'status' => 'error',
+        'time' => ::Qa::Time.at_f(::Qa::Time.now_f) - test['qa:timestamp'],
+        'exception' => exception)
+    flush
+  end
+
   def passed?
     @mutex.synchronize do
       (@fail_count + @error_count).zero?
@@ -1322,6 +1405,8 @@ class ::Qa::TapjConduit
         if event['qa:type'] == 'test:begin'
           @load_tracking.trap_begin
           @test_timestamp = event['qa:timestamp']
+          @current_test = event
+          @current_test_thread = Thread.current
         end
       when 'test'
         if @suppress_next_test_event
@@ -1329,6 +1414,9 @@ class ::Qa::TapjConduit
           return
         end
 
+        @current_test = nil
+        @current_test_thread = nil
+
         if file = event['file']
           absolute_path = File.expand_path(file)
           missing_files = @missing_file_dependencies[absolute_path] || []
@@ -1390,9 +1478,40 @@ class ::Qa::TapjConduit
 
   def flush
     @upstream.flush
+
+    retire! if @retiring && @mutex.synchronize { @current_test.nil? }
+  end
+
+  # Exit once the current test (if any) is done, e.g. because the worker is using too much
+  # memory. Safe to call from a trap.
+  def retire_after_current_test!
+    @retiring = true
+  end
+
+  private
+
+  def retire!
+    rss = nil
+    ::Qa::Stats::LinuxMemory.new(Process.pid).sample { |h| rss = h['vm_rss'] }
+
+    @upstream.emit(
+        'type' => 'trace',
+        'trace' => {
+          'name' => 'qa:worker-recycle',
+          'pid' => ENV['TEST_ENV_NUMBER'] || Process.pid,
+          'tid' => 1,
+          'ph' => 'I',
+          'ts' => ::Qa::Time.now_f * 1e6,
+          'args' => {'vm_rss' => rss},
+        })
+    @upstream.flush
+
+    exit!(::Qa::TestEngine::WORKER_EXIT_STATUS)
   end
 end
 
+class ::Qa::Timeout < StandardError; end
+
 module ::Qa::ClientSocket
   module_function
 
@@ -1608,8 +1727,18 @@ module ::Qa::Warmup::RailsActiveRecord
     end
   end
 
+  # Returns the given database config without its password, so it can be logged.
+  def redact_config(config)
+    redacted = config.reject { |k, _| k.to_s == 'password' }
+    if url = redacted['url']
+      redacted['url'] = url.sub(%r{\A([^:/]+://[^:@/]*):[^/]*@}, '\1:REDACTED@')
+    end
+
+    redacted
+  end
+
   def with_env(env)
-    saved = ENV.to_hash.values_at(env.keys)
+    saved = ENV.to_hash.values_at(*env.keys)
     env.each do |k, v|
       ENV[k] = v
     end
@@ -1634,10 +1763,16 @@ module ::Qa::Warmup::RailsActiveRecord
         if defined?(Rails) && defined?(ActiveRecord::Base)
           connection_cache = cache[:rails_database_connections]
           config = rails_database_configuration
-          if config['database'] == default_db && envs.length > 1 && defined?(ActiveRecord::NoDatabaseError)
+          if env['DATABASE_URL']
+            # Each worker was given its own database with -worker-env, so use it as is.
+            config = config.merge('url' => env['DATABASE_URL'])
+
+            $stderr.puts "Warming up config #{redact_config(config)}"
+            ActiveRecord::Base.establish_connection(config)
+          elsif config['database'] == default_db && envs.length > 1 && defined?(ActiveRecord::NoDatabaseError)
             config['database'] = "#{config['database']}_qa#{env['TEST_ENV_NUMBER']}"
 
-            $__qa_stderr.puts "Warming up (overridden) config #{config}"
+            $__qa_stderr.puts "Warming up (overridden) config #{redact_config(config)}"
             ActiveRecord::Base.establish_connection(config)
             begin
               ActiveRecord::Base.connection
@@ -1653,7 +1788,7 @@ module ::Qa::Warmup::RailsActiveRecord
               ActiveRecord::Tasks::DatabaseTasks.migrate
             end
           else
-            $stderr.puts "Warming up config #{config}"
+            $stderr.puts "Warming up config #{redact_config(config)}"
             ActiveRecord::Base.establish_connection(config)
           end
 
@@ -1685,6 +1820,7 @@ class ::Qa::ClientOptionParser
   attr_reader :trace_probes
   attr_reader :seed
   attr_reader :tapj_sink
+  attr_reader :last
 
   def initialize
     @trace_probes = []
@@ -1713,6 +1849,11 @@ class ::Qa::ClientOptionParser
       opts.on "--tapj-sink ENDPOINT" do |s|
         @tapj_sink = s
       end
+
+      desc = "Runs the given test after all the others, rather than in seed order."
+      opts.on "--last TEST", desc do |t|
+        @last = t
+      end
     end
   end
 
@@ -1723,6 +1864,9 @@ end
 
 require 'set'
 class ::Qa::TestEngine
+  # The status workers exit with when they're done, as opposed to a test exiting or aborting.
+  WORKER_EXIT_STATUS = 0
+
   def initialize
     @prefork = lambda {}
     @run_tests = lambda {}
@@ -1898,16 +2042,49 @@ class ::Qa::TestEngine
   end
 
   def accept_client(cache, env, args, eval_after_fork, passthrough, conserved, trace_probes, trace_events)
+    opt = ::Qa::ClientOptionParser.new
+    tests = opt.parse(args)
+
+    # Connect before forking, so we can still report on the worker if it dies abnormally.
+    socket = ::Qa::ClientSocket.connect(opt.tapj_sink)
+
     p = Process.fork do
+      exit_status = WORKER_EXIT_STATUS
       begin
-        opt = ::Qa::ClientOptionParser.new
-        tests = opt.parse(args)
-
         seed = opt.seed
-        socket = ::Qa::ClientSocket.connect(opt.tapj_sink)
         tapj_conduit = ::Qa::TapjConduit.new(@load_tracking, ::Qa::JsonConduit.new(socket))
         tapj_conduit.missing_file_dependencies = @missing_file_dependencies
 
+        # We're sent SIGQUIT when the current test has taken too long. Report where every
+        # thread is before we go. Do it from a new thread, since we can't lock from a trap.
+        Signal.trap('QUIT') do
+          Thread.new do
+            begin
+              tapj_conduit.emit_timeout_event
+            ensure
+              exit!
+            end
+          end
+        end
+
+        # We're sent SIGUSR2 when we've grown too large, and should make way for a fresh worker.
+        Signal.trap('USR2') do
+          tapj_conduit.retire_after_current_test!
+        end
+
+        # Say who we are right away, so we can be stopped even if we hang before any test begins.
+        tapj_conduit.emit(
+            'type' => 'trace',
+            'trace' => {
+              'name' => 'qa:worker-start',
+              'pid' => env['TEST_ENV_NUMBER'] || Process.pid,
+              'tid' => 1,
+              'ph' => 'I',
+              'ts' => ::Qa::Time.now_f * 1e6,
+              'args' => {'pid' => Process.pid},
+            })
+        tapj_conduit.flush
+
         run_everything = tests.empty?
 
         script_errors_with_file = []
@@ -2021,12 +2198,41 @@ class ::Qa::TestEngine
         end
 
         conserved.check_conservation
+      rescue SystemExit => e
+        # A test called exit or abort. Don't let that pass for the worker finishing.
+        exit_status = e.success? ? 1 : e.status
       ensure
         tapj_conduit.flush if tapj_conduit
         socket.close if socket
       end
-      exit!
+      exit!(exit_status)
+    end
+
+    Thread.new do
+      begin
+        _, status = Process.wait2(p)
+
+        # Workers that finish their tests (or retire early) exit with WORKER_EXIT_STATUS.
+        if status.signaled? || status.exitstatus != WORKER_EXIT_STATUS
+          event = {
+            'type' => 'trace',
+            'trace' => {
+              'name' => 'qa:worker-exit',
+              'pid' => env['TEST_ENV_NUMBER'] || p,
+              'tid' => 1,
+              'ph' => 'I',
+              'ts' => ::Qa::Time.now_f * 1e6,
+              'args' => {'status' => status.to_s},
+            },
+          }
+          socket.write(::Qa::Json.fast_generate(event, :max_nesting => false) + "\n")
+          socket.flush
+        end
+      rescue IOError, SystemCallError
+        # Nobody is listening anymore.
+      ensure
+        socket.close
+      end
     end
-    Process.detach(p)
   end
 end
@@ -21,9 +21,27 @@ module Test::Unit::UI::Tap
       @already_outputted = false
       @top_level = true
       @stdcom = ::Qa::Stdcom.new
+
+      # Move the test that must go last to the end of the suite, whatever order it was in.
+      if (last = @options[:last]) && (test = remove_test(@suite, last))
+        @suite << test
+      end
     end
 
   private
+    def remove_test(suite, name)
+      suite.tests.each_with_index do |test, ix|
+        if test.is_a?(Test::Unit::TestSuite)
+          found = remove_test(test, name)
+          return found if found
+        elsif "#{test.class.name}##{test.method_name}" == name
+          return suite.tests.delete_at(ix)
+        end
+      end
+
+      nil
+    end
+
     def attach_to_mediator
       @mediator.add_listener(Test::Unit::TestResult::FAULT,                &method(:tapout_fault))
       @mediator.add_listener(Test::Unit::UI::TestRunnerMediator::STARTED,  &method(:tapout_before_suite))
@@ -112,6 +130,12 @@ module Test::Unit::UI::Tap
             'status'      => 'fail',
             'expected'    => fault.inspected_expected,
             'returned'    => fault.inspected_actual)
+
+        # test-unit appends its own diff of what an assert_equal compared.
+        if fault.respond_to?(:expected) && fault.inspected_expected
+          ::Qa::TapjExceptions.note_comparison(fault, fault.expected, fault.actual,
+              fault.message.sub(/\n\ndiff:\n.*\z/m, ''))
+        end
       else
         exception, exception_location = fault.exception, fault.location
         doc['status'] = 'error'
@@ -289,6 +313,7 @@ engine.def_run_tests do |qa_trace, opt, tapj_conduit, tests|
   runner_options[:output] = tapj_conduit
   runner_options[:seed] = seed
   runner_options[:trace] = qa_trace
+  runner_options[:last] = opt.last
 
   auto_runner.run
 end
@@ -0,0 +1,102 @@
+package runner
+
+import (
+	"fmt"
+	"path/filepath"
+	"time"
+
+	"qa/glob"
+	"qa/tapjio"
+)
+
+// A Budget is how long each test matching Pattern may take. Patterns are globs, matched against
+// both a test's file, like spec/unit/**, and its filter, like UserTest#test_*.
+type Budget struct {
+	Pattern  string
+	Duration time.Duration
+
+	match func(string) bool
+}
+
+func NewBudget(pattern string, duration time.Duration) (*Budget, error) {
+	match, err := glob.ToMatchPathFn(pattern)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Budget{Pattern: pattern, Duration: duration, match: match}, nil
+}
+
+// Budgets are the duration budgets for a run's tests, and for each of its suites as a whole.
+type Budgets struct {
+	// Each test is held to the first of these that matches it, if any do.
+	Tests []*Budget
+
+	// How long each suite may take, if positive.
+	Suite time.Duration
+
+	// Whether going over budget only warrants a warning, rather than a failure.
+	WarnOnly bool
+
+	// Tests whose files are under Dir are matched by their paths relative to it.
+	Dir string
+}
+
+func (self *Budgets) budgetFor(test tapjio.TestFinishEvent) *Budget {
+	file := test.File.String()
+	if self.Dir != "" && filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(self.Dir, file); err == nil {
+			file = rel
+		}
+	}
+	// Runners like RSpec report files as ./spec/..., which patterns like spec/** should match.
+	if file != "" {
+		file = filepath.Clean(file)
+	}
+
+	for _, budget := range self.Tests {
+		if budget.Pattern == test.Filter.String() || (file != "" && budget.match(file)) || budget.match(test.Filter.String()) {
+			return budget
+		}
+	}
+
+	return nil
+}
+
+// CheckTest notes whether a passing test went over its budget. Unless the budgets only warrant
+// warnings, a test over budget fails.
+func (self *Budgets) CheckTest(test *tapjio.TestFinishEvent) {
+	if self == nil || test.Status != tapjio.Pass {
+		return
+	}
+
+	budget := self.budgetFor(*test)
+	if budget == nil || test.Time <= budget.Duration.Seconds() {
+		return
+	}
+
+	test.Budget = &tapjio.BudgetOverrun{
+		Pattern: budget.Pattern,
+		Budget:  budget.Duration.Seconds(),
+		Warning: self.WarnOnly,
+	}
+	if self.WarnOnly {
+		return
+	}
+
+	test.Status = tapjio.Fail
+	test.Exception = &tapjio.TestException{
+		Class: tapjio.BudgetExceptionClass,
+		Message: fmt.Sprintf("Took %v, over the %v budget for %s",
+			time.Duration(test.Time*1000)*time.Millisecond, budget.Duration, budget.Pattern),
+	}
+}
+
+// CheckSuite notes whether a finished suite went over its budget.
+func (self *Budgets) CheckSuite(final *tapjio.SuiteFinishEvent) {
+	if self == nil || self.Suite <= 0 || final.Time <= self.Suite.Seconds() {
+		return
+	}
+
+	final.Budget = &tapjio.BudgetOverrun{Budget: self.Suite.Seconds(), Warning: self.WarnOnly}
+}
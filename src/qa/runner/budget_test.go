@@ -0,0 +1,101 @@
+package runner
+
+import (
+	"testing"
+	"time"
+
+	"qa/tapjio"
+)
+
+func newTestBudgets(t *testing.T, warnOnly bool) *Budgets {
+	budgets := &Budgets{Suite: time.Minute, WarnOnly: warnOnly, Dir: "/app"}
+	for _, spec := range []struct {
+		pattern  string
+		duration time.Duration
+	}{
+		{"spec/unit/**", 200 * time.Millisecond},
+		{"UserTest#test_*", time.Second},
+		{"spec/**", 2 * time.Second},
+	} {
+		budget, err := NewBudget(spec.pattern, spec.duration)
+		if err != nil {
+			t.Fatal(err)
+		}
+		budgets.Tests = append(budgets.Tests, budget)
+	}
+
+	return budgets
+}
+
+func TestBudgetsCheckTest(t *testing.T) {
+	budgets := newTestBudgets(t, false)
+
+	cases := []struct {
+		test    tapjio.TestFinishEvent
+		pattern string
+	}{
+		{tapjio.TestFinishEvent{File: "spec/unit/user_spec.rb", Status: tapjio.Pass, Time: 0.1}, ""},
+		{tapjio.TestFinishEvent{File: "spec/unit/user_spec.rb", Status: tapjio.Pass, Time: 0.3}, "spec/unit/**"},
+		{tapjio.TestFinishEvent{File: "./spec/unit/user_spec.rb", Status: tapjio.Pass, Time: 0.3}, "spec/unit/**"},
+		{tapjio.TestFinishEvent{File: "/app/spec/models/user_spec.rb", Status: tapjio.Pass, Time: 0.3}, ""},
+		{tapjio.TestFinishEvent{File: "/app/spec/models/user_spec.rb", Status: tapjio.Pass, Time: 3}, "spec/**"},
+		{tapjio.TestFinishEvent{Filter: "UserTest#test_saves", File: "/app/test/user_test.rb", Status: tapjio.Pass, Time: 1.5}, "UserTest#test_*"},
+		{tapjio.TestFinishEvent{Filter: "UserTest#test_saves", Status: tapjio.Fail, Time: 1.5}, ""},
+		{tapjio.TestFinishEvent{File: "lib/other.rb", Status: tapjio.Pass, Time: 60}, ""},
+	}
+
+	for _, c := range cases {
+		test := c.test
+		budgets.CheckTest(&test)
+
+		if c.pattern == "" {
+			if test.Budget != nil || test.Status != c.test.Status {
+				t.Errorf("Expected %v to be within budget, got %#v", c.test, test.Budget)
+			}
+			continue
+		}
+
+		if test.Budget == nil || test.Budget.Pattern != c.pattern || test.Budget.Warning {
+			t.Errorf("Expected %v to be over the %s budget, got %#v", c.test, c.pattern, test.Budget)
+			continue
+		}
+		if test.Status != tapjio.Fail || test.Exception == nil || test.Exception.Class != tapjio.BudgetExceptionClass {
+			t.Errorf("Expected %v to fail, got %v %#v", c.test, test.Status, test.Exception)
+		}
+	}
+
+	test := tapjio.TestFinishEvent{File: "spec/unit/user_spec.rb", Status: tapjio.Pass, Time: 0.25}
+	budgets.CheckTest(&test)
+	if test.Exception.Message != "Took 250ms, over the 200ms budget for spec/unit/**" {
+		t.Errorf("Unexpected message: %s", test.Exception.Message)
+	}
+}
+
+func TestBudgetsWarnOnly(t *testing.T) {
+	budgets := newTestBudgets(t, true)
+
+	test := tapjio.TestFinishEvent{File: "spec/unit/user_spec.rb", Status: tapjio.Pass, Time: 0.3}
+	budgets.CheckTest(&test)
+	if test.Status != tapjio.Pass || test.Exception != nil || test.Budget == nil || !test.Budget.Warning {
+		t.Fatalf("Expected only a warning, got %v %#v", test.Status, test.Budget)
+	}
+
+	suite := tapjio.NewSuiteBeginEvent(time.Now(), 1, 1)
+	final := tapjio.NewSuiteFinishEvent(suite)
+	final.Time = 61
+	budgets.CheckSuite(final)
+	if final.Budget == nil || !final.Budget.Warning || !final.Passed() {
+		t.Fatalf("Expected the suite to pass with a warning, got %#v", final.Budget)
+	}
+
+	budgets.WarnOnly = false
+	final.Budget = nil
+	budgets.CheckSuite(final)
+	if final.Passed() {
+		t.Fatal("Expected a suite over budget not to pass")
+	}
+
+	var none *Budgets
+	none.CheckSuite(final)
+	none.CheckTest(&test)
+}
@@ -0,0 +1,406 @@
+package external
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"qa/runner"
+	"qa/runner/server"
+	"qa/tapjio"
+)
+
+// An external runner runs tests with any command that speaks the following protocol.
+//
+// The command is run once to list tests, then once for each group of tests to run them. It
+// runs in the runner's directory, with the runner's environment plus:
+//
+//     QA_TAPJ_SINK  An address like token@host:port. Connect to host:port over TCP, write
+//                   the token and a newline, then write TAP-J events, one JSON object per
+//                   line. Close the connection when done.
+//     QA_SEED       The random seed to order tests with.
+//     QA_DRY_RUN    Set to 1 when listing tests, and not set otherwise.
+//
+// When listing tests, the arguments are the files matched by the runner's patterns, and the
+// command writes a "test" event for each test without running it. Each must have a qa:filter
+// that the command understands and, if it has one, a qa:file.
+//
+// When running tests, the arguments are the qa:filter of each test to run, and the worker's
+// environment (QA_WORKER, and so on) is added too. The command writes a "test" event with the
+// outcome of each, optionally preceded by a "note" event with a qa:type of test:begin. Its exit
+// status doesn't matter, but tests it exits without reporting are counted as errors. Test
+// timeouts only apply to tests whose begin is reported this way.
+
+// How long to wait for a command that has exited to finish sending events.
+const connectGracePeriod = 2 * time.Second
+
+// Why a command was stopped before it exited on its own.
+type stopReason int
+
+const (
+	notStopped stopReason = iota
+	stoppedByQuit
+	stoppedByRunnerTimeout
+	stoppedByTestTimeout
+)
+
+type context struct {
+	srv    *server.Server
+	config runner.Config
+}
+
+// StartContext returns a context that runs the given runner config's Command.
+func StartContext(srv *server.Server, runnerConfig runner.Config) (runner.Context, error) {
+	if len(runnerConfig.Command) == 0 {
+		return nil, errors.New("No command given for runner " + runnerConfig.Name)
+	}
+
+	return &context{srv: srv, config: runnerConfig}, nil
+}
+
+func (self *context) Close() error {
+	return nil
+}
+
+// command runs the context's command with the given arguments and extra environment variables,
+// sending the events it writes to visitor. Stops the command early if quitChan is closed, if it
+// runs past deadline, or if testTimeout passes without any progress. A zero deadline or
+// testTimeout is ignored. Returns why the command was stopped early, if it was, and the error it exited
+// with, if any.
+func (self *context) command(
+	args []string,
+	env map[string]string,
+	seed int,
+	quitChan <-chan struct{},
+	testTimeout time.Duration,
+	deadline time.Time,
+	visitor tapjio.Visitor) (stopped stopReason, exitErr error, err error) {
+
+	cfg := self.config
+
+	// Holds whether a test is running, as of the latest event. Only the decoder sends on it, so
+	// it can replace whatever's there without blocking.
+	inTest := make(chan bool, 1)
+	notify := func(running bool) {
+		select {
+		case <-inTest:
+		default:
+		}
+		inTest <- running
+	}
+
+	address, errChan, err := self.srv.Decode(&tapjio.DecodingCallbacks{
+		OnTestBegin: func(event tapjio.TestBeginEvent) error {
+			notify(true)
+			return visitor.TestBegin(event)
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			notify(false)
+			return visitor.TestFinish(event)
+		},
+		OnTrace:       visitor.TraceEvent,
+		OnAwaitAttach: visitor.AwaitAttach,
+		OnEnd:         visitor.End,
+	})
+	if err != nil {
+		return notStopped, nil, err
+	}
+
+	cmd := exec.Command(cfg.Command[0], append(append([]string{}, cfg.Command[1:]...), args...)...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = os.Environ()
+	for name, value := range cfg.EnvVars {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Env = append(cmd.Env, "QA_TAPJ_SINK="+address, "QA_SEED="+strconv.Itoa(seed))
+	cmd.Stdout = os.Stderr
+	cmd.Stderr = os.Stderr
+	// So that the command can be killed along with anything it starts.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	err = cmd.Start()
+	if err != nil {
+		self.srv.Cancel(address)
+		<-errChan
+		return notStopped, nil, err
+	}
+
+	exited := make(chan error, 1)
+	go func() {
+		exited <- cmd.Wait()
+	}()
+
+	var runnerTimedOut <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		defer timer.Stop()
+		runnerTimedOut = timer.C
+	}
+
+	// The test timer only runs between a test's begin and finish events.
+	var testTimer *time.Timer
+	var testTimedOut <-chan time.Time
+	if testTimeout > 0 {
+		testTimer = time.NewTimer(testTimeout)
+		testTimer.Stop()
+		defer testTimer.Stop()
+		testTimedOut = testTimer.C
+	}
+
+	for running := true; running; {
+		select {
+		case exitErr = <-exited:
+			running = false
+		case begun := <-inTest:
+			if testTimer != nil {
+				if !testTimer.Stop() {
+					select {
+					case <-testTimer.C:
+					default:
+					}
+				}
+				if begun {
+					testTimer.Reset(testTimeout)
+				}
+			}
+		case <-quitChan:
+			stopped = stoppedByQuit
+		case <-runnerTimedOut:
+			stopped = stoppedByRunnerTimeout
+		case <-testTimedOut:
+			stopped = stoppedByTestTimeout
+		}
+
+		if stopped != notStopped {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			exitErr = <-exited
+			running = false
+		}
+	}
+
+	select {
+	case err = <-errChan:
+	case <-time.After(connectGracePeriod):
+		// The command exited without ever connecting, or something it started still holds the
+		// connection open.
+		self.srv.Disconnect(address)
+		<-errChan
+		err = nil
+	}
+
+	return stopped, exitErr, err
+}
+
+type externalRunner struct {
+	ctx     *context
+	filters []tapjio.TestFilter
+}
+
+func (self *context) EnumerateRunners(seed int) (traceEvents []tapjio.TraceEvent, testRunners []runner.TestRunner, err error) {
+	cfg := self.config
+	files, err := cfg.Files()
+	if err != nil {
+		return
+	}
+
+	var keep map[tapjio.TestFilter]bool
+	if len(cfg.Filters) > 0 {
+		keep = make(map[tapjio.TestFilter]bool)
+		for _, filter := range cfg.Filters {
+			keep[filter] = true
+		}
+	}
+
+	var currentRunner *externalRunner
+	var currentFile tapjio.FilePath
+	_, exitErr, err := self.command(files, map[string]string{"QA_DRY_RUN": "1"}, seed, nil, 0, time.Time{}, &tapjio.DecodingCallbacks{
+		OnTrace: func(trace tapjio.TraceEvent) error {
+			traceEvents = append(traceEvents, trace)
+			return nil
+		},
+		OnTestFinish: func(test tapjio.TestFinishEvent) error {
+			if test.Filter == "" {
+				return fmt.Errorf("%s listed a test without a filter: %s", cfg.Command[0], test.Label)
+			}
+
+			if keep != nil && !keep[test.Filter] {
+				return nil
+			}
+
+			squashPolicy := cfg.SquashPolicy
+			if squashPolicy == runner.SquashNothing ||
+				squashPolicy == runner.SquashByFile && (currentRunner == nil || currentFile != test.File) ||
+				squashPolicy == runner.SquashAll && currentRunner == nil {
+				if currentRunner != nil {
+					testRunners = append(testRunners, *currentRunner)
+				}
+				currentRunner = &externalRunner{ctx: self}
+				currentFile = test.File
+			}
+			currentRunner.filters = append(currentRunner.filters, test.Filter)
+			return nil
+		},
+		OnEnd: func(reason error) error {
+			if currentRunner != nil {
+				testRunners = append(testRunners, *currentRunner)
+				currentRunner = nil
+			}
+			return nil
+		},
+	})
+	if err == nil && exitErr != nil {
+		err = fmt.Errorf("Listing tests with %s failed: %v", strings.Join(cfg.Command, " "), exitErr)
+	}
+
+	return
+}
+
+func (self externalRunner) TestCount() int {
+	return len(self.filters)
+}
+
+func (self externalRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self externalRunner) Dependencies() []runner.TestDependencyEntry {
+	return nil
+}
+
+func (self externalRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	return externalRunner{
+		ctx:     self.ctx,
+		filters: append([]tapjio.TestFilter{}, filters...),
+	}
+}
+
+// Run runs the command with the runner's filters as arguments. Tests the command doesn't report
+// before it exits (or is stopped) are reported as errors, except that if a test times out, the
+// tests after it are run again with a fresh command.
+func (self externalRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	var deadline time.Time
+	if self.ctx.config.RunnerTimeout > 0 {
+		deadline = time.Now().Add(self.ctx.config.RunnerTimeout)
+	}
+
+	return self.run(env, seed, quitChan, deadline, visitor)
+}
+
+// run is like Run, but stops at deadline, which tests run again after a timeout share.
+func (self externalRunner) run(env map[string]string, seed int, quitChan <-chan struct{}, deadline time.Time, visitor tapjio.Visitor) error {
+	cfg := self.ctx.config
+	mutex := &sync.Mutex{}
+	unfinished := make(map[tapjio.TestFilter]bool)
+	for _, filter := range self.filters {
+		unfinished[filter] = true
+	}
+	var current tapjio.TestFilter
+
+	var args []string
+	for _, filter := range self.filters {
+		args = append(args, filter.String())
+	}
+
+	stopped, exitErr, err := self.ctx.command(args, env, seed, quitChan, cfg.TestTimeout, deadline, &tapjio.DecodingCallbacks{
+		OnTestBegin: func(event tapjio.TestBeginEvent) error {
+			mutex.Lock()
+			current = event.Filter
+			mutex.Unlock()
+
+			return visitor.TestBegin(event)
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			mutex.Lock()
+			delete(unfinished, event.Filter)
+			current = ""
+			mutex.Unlock()
+
+			if event.Runner == "" {
+				event.Runner = cfg.Name
+			}
+			return visitor.TestFinish(event)
+		},
+		OnTrace:       visitor.TraceEvent,
+		OnAwaitAttach: visitor.AwaitAttach,
+	})
+	if err != nil {
+		return err
+	}
+
+	if stopped == stoppedByQuit {
+		return nil
+	}
+
+	mutex.Lock()
+	defer mutex.Unlock()
+
+	exception := tapjio.TestException{Class: tapjio.WorkerCrashExceptionClass, Message: "Exited before reporting this test"}
+	if exitErr != nil {
+		exception.Message = fmt.Sprintf("Exited before reporting this test: %v", exitErr)
+	}
+
+	switch stopped {
+	case stoppedByRunnerTimeout:
+		exception = tapjio.TestException{
+			Class:   tapjio.TimeoutExceptionClass,
+			Message: fmt.Sprintf("Runner timed out after %v", cfg.RunnerTimeout),
+		}
+	case stoppedByTestTimeout:
+		exception = tapjio.TestException{
+			Class:   tapjio.TimeoutExceptionClass,
+			Message: fmt.Sprintf("Test timed out after %v", cfg.TestTimeout),
+		}
+
+		// If we know which test timed out, give the rest another chance.
+		if current != "" {
+			err := self.reportError(visitor, current, exception)
+			if err != nil {
+				return err
+			}
+			delete(unfinished, current)
+
+			var rest []tapjio.TestFilter
+			for _, filter := range self.filters {
+				if unfinished[filter] {
+					rest = append(rest, filter)
+				}
+			}
+			if len(rest) == 0 {
+				return nil
+			}
+
+			return externalRunner{ctx: self.ctx, filters: rest}.run(env, seed, quitChan, deadline, visitor)
+		}
+	}
+
+	for _, filter := range self.filters {
+		if unfinished[filter] {
+			err := self.reportError(visitor, filter, exception)
+			if err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self externalRunner) reportError(visitor tapjio.Visitor, filter tapjio.TestFilter, exception tapjio.TestException) error {
+	return visitor.TestFinish(tapjio.TestFinishEvent{
+		Type:      "test",
+		Label:     filter.String(),
+		Runner:    self.ctx.config.Name,
+		Filter:    filter,
+		Status:    tapjio.Error,
+		Exception: &exception,
+	})
+}
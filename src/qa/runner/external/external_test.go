@@ -0,0 +1,198 @@
+package external
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"qa/runner"
+	"qa/runner/server"
+	"qa/tapjio"
+)
+
+// TestHelperProcess isn't a real test. It's the external runner command used by the tests
+// below. Each file it's given lists the names of its tests, one per line. Tests named crash
+// exit before reporting, tests named hang never finish, and tests named orphan never finish
+// and start a process that holds the connection open.
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("QA_HELPER_PROCESS") != "1" {
+		return
+	}
+	defer os.Exit(0)
+
+	if os.Getenv("QA_HELPER_ORPHAN") == "1" {
+		time.Sleep(time.Minute)
+		return
+	}
+
+	args := os.Args
+	for len(args) > 0 && args[0] != "--" {
+		args = args[1:]
+	}
+	args = args[1:]
+
+	sink := strings.SplitN(os.Getenv("QA_TAPJ_SINK"), "@", 2)
+	conn, err := net.Dial("tcp", sink[1])
+	if err != nil {
+		panic(err)
+	}
+	defer conn.Close()
+	io.WriteString(conn, sink[0]+"\n")
+	encoder := json.NewEncoder(conn)
+
+	if os.Getenv("QA_DRY_RUN") == "1" {
+		for _, file := range args {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				panic(err)
+			}
+			for _, name := range strings.Fields(string(b)) {
+				encoder.Encode(tapjio.TestFinishEvent{
+					Type:   "test",
+					Label:  name,
+					Filter: tapjio.TestFilter(file + "#" + name),
+					File:   tapjio.FilePath(file),
+					Status: tapjio.Todo,
+				})
+			}
+		}
+		return
+	}
+
+	for _, filter := range args {
+		begin := tapjio.NewTestBeginEvent()
+		begin.Filter = tapjio.TestFilter(filter)
+		encoder.Encode(begin)
+
+		switch strings.SplitN(filter, "#", 2)[1] {
+		case "crash":
+			os.Exit(3)
+		case "hang":
+			time.Sleep(time.Minute)
+		case "orphan":
+			file, err := conn.(*net.TCPConn).File()
+			if err != nil {
+				panic(err)
+			}
+			orphan := exec.Command(os.Args[0], "-test.run=TestHelperProcess", "--")
+			orphan.Env = append(os.Environ(), "QA_HELPER_ORPHAN=1")
+			orphan.ExtraFiles = []*os.File{file}
+			err = orphan.Start()
+			if err != nil {
+				panic(err)
+			}
+			time.Sleep(time.Minute)
+		}
+
+		encoder.Encode(tapjio.TestFinishEvent{
+			Type:   "test",
+			Label:  filter,
+			Filter: tapjio.TestFilter(filter),
+			Status: tapjio.Pass,
+		})
+	}
+}
+
+func TestExternalRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "external")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	files := map[string]string{
+		"a.t": "one two",
+		"b.t": "one crash three",
+		"c.t": "hang after",
+		"d.t": "orphan after",
+	}
+	for name, content := range files {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	srv, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	ctx, err := StartContext(srv, runner.Config{
+		Name:         "helper",
+		FileLister:   runner.NewFileGlob(dir, []string{"*.t"}),
+		Dir:          dir,
+		EnvVars:      map[string]string{"QA_HELPER_PROCESS": "1"},
+		SquashPolicy: runner.SquashByFile,
+		TestTimeout:  500 * time.Millisecond,
+		Command:      []string{os.Args[0], "-test.run=TestHelperProcess", "--"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	_, runners, err := ctx.EnumerateRunners(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runners) != 4 {
+		t.Fatalf("Expected a runner for each file, got %d", len(runners))
+	}
+
+	results := make(map[tapjio.TestFilter]tapjio.TestFinishEvent)
+	visitor := &tapjio.DecodingCallbacks{
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			results[event.Filter] = event
+			return nil
+		},
+	}
+	for _, testRunner := range runners {
+		start := time.Now()
+		err = testRunner.Run(map[string]string{"QA_WORKER": "0"}, 1, make(chan struct{}), visitor)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		// Stopping a test kills anything it started, so nothing is left holding the connection.
+		if elapsed := time.Since(start); elapsed >= connectGracePeriod {
+			t.Fatalf("Expected %v to finish promptly, took %v", testRunner.Filters(), elapsed)
+		}
+	}
+
+	expected := map[string]string{
+		"a.t#one":    "pass",
+		"a.t#two":    "pass",
+		"b.t#one":    "pass",
+		"b.t#crash":  "error " + tapjio.WorkerCrashExceptionClass,
+		"b.t#three":  "error " + tapjio.WorkerCrashExceptionClass,
+		"c.t#hang":   "error " + tapjio.TimeoutExceptionClass,
+		"c.t#after":  "pass",
+		"d.t#orphan": "error " + tapjio.TimeoutExceptionClass,
+		"d.t#after":  "pass",
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %#v", len(expected), results)
+	}
+	for filter, outcome := range expected {
+		event := results[tapjio.TestFilter(filter)]
+		actual := string(event.Status)
+		if event.Exception != nil {
+			actual += " " + event.Exception.Class
+		}
+		if actual != outcome {
+			t.Fatalf("Expected %s for %s, got %s", outcome, filter, actual)
+		}
+		if event.Runner != "helper" {
+			t.Fatalf("Expected runner to be recorded for %s, got %s", filter, event.Runner)
+		}
+	}
+}
@@ -0,0 +1,665 @@
+package gotest
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"qa/runner"
+	"qa/tapjio"
+)
+
+// The gotest runner runs Go tests with go test. Its patterns are package patterns, like
+// ./..., and each package is treated as a file: with the default squash policy, the tests of
+// a package are run together by one go test process. Each test's filter is its package's
+// import path and its name, e.g. example.com/app/models#TestSave.
+//
+// Tests are listed with go test -list, and run with go test -json -run, so Go 1.10 or newer
+// is needed. Subtests are reported as part of the top-level test that runs them.
+
+// The package pattern used when none is given.
+const DefaultPattern = "./..."
+
+// The exception classes reported for tests that fail and that panic.
+const failureExceptionClass = "testing.Failure"
+const panicExceptionClass = "panic"
+
+// Names that go test -list prints for tests that -run can select. Benchmarks aren't run.
+var testNamePattern = regexp.MustCompile(`^(Test|Example|Fuzz)\w*$`)
+
+// Lines that go test writes around each test's own output.
+var frameLinePattern = regexp.MustCompile(`^\s*(=== (RUN|PAUSE|CONT|NAME)\s|--- (PASS|FAIL|SKIP): )`)
+
+// Lines written by t.Error, t.Log, and so on, e.g. "    model_test.go:12: not saved".
+var logLinePattern = regexp.MustCompile(`^\s+([^\s:]+\.go):(\d+): ?(.*)$`)
+
+// Lines of a goroutine trace naming where a function call is, e.g. "\t/src/app/model.go:12 +0x25".
+var traceLinePattern = regexp.MustCompile(`^\t(\S+\.go):(\d+)( \+0x[0-9a-f]+)?$`)
+
+// An event written by go test -json. See go doc cmd/test2json.
+type testEvent struct {
+	Time    time.Time
+	Action  string
+	Package string
+
+	// Set instead of Package for build-output events, e.g. "example.com/app [example.com/app.test]".
+	ImportPath string
+
+	Test    string
+	Elapsed float64
+	Output  string
+}
+
+type context struct {
+	config runner.Config
+
+	// The directory of each package, relative to the runner's directory.
+	dirs map[string]string
+}
+
+// StartContext returns a context that runs the Go tests in the given runner config's packages.
+func StartContext(runnerConfig runner.Config) (runner.Context, error) {
+	return &context{config: runnerConfig, dirs: make(map[string]string)}, nil
+}
+
+func (self *context) Close() error {
+	return nil
+}
+
+// dir returns the runner's directory, which is the current directory if none was given.
+func (self *context) dir() string {
+	if self.config.Dir != "" {
+		return self.config.Dir
+	}
+
+	dir, err := os.Getwd()
+	if err != nil {
+		return "."
+	}
+
+	return dir
+}
+
+func (self *context) patterns() []string {
+	patterns := self.config.FileLister.Patterns()
+	if len(patterns) == 0 {
+		return []string{DefaultPattern}
+	}
+
+	return patterns
+}
+
+// goCommand returns a go command with the given arguments, run in the runner's directory with
+// the runner's environment plus the given variables.
+func (self *context) goCommand(env map[string]string, args ...string) *exec.Cmd {
+	cfg := self.config
+	cmd := exec.Command("go", args...)
+	cmd.Dir = cfg.Dir
+	cmd.Env = os.Environ()
+	for name, value := range cfg.EnvVars {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Stderr = os.Stderr
+
+	// So that go test can be killed along with the test binary it starts.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	return cmd
+}
+
+// decodeEvents sends each event written to reader, then closes the returned channel. Lines
+// that aren't events, like build errors from older versions of go, are written to stderr.
+func decodeEvents(reader io.Reader) <-chan testEvent {
+	events := make(chan testEvent)
+	go func() {
+		defer close(events)
+		scanner := bufio.NewScanner(reader)
+		scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+		for scanner.Scan() {
+			var event testEvent
+			err := json.Unmarshal(scanner.Bytes(), &event)
+			if err != nil || event.Action == "" {
+				fmt.Fprintln(os.Stderr, scanner.Text())
+				continue
+			}
+			events <- event
+		}
+		// Drain whatever's left so go test doesn't block writing it.
+		io.Copy(os.Stderr, reader)
+	}()
+
+	return events
+}
+
+func (self *context) listPackageDirs() error {
+	cmd := self.goCommand(nil, append([]string{"list", "-f", "{{.ImportPath}}\t{{.Dir}}"}, self.patterns()...)...)
+	cmd.Stderr = nil
+	output, err := cmd.Output()
+	if err != nil {
+		return err
+	}
+
+	for _, line := range strings.Split(strings.TrimSpace(string(output)), "\n") {
+		split := strings.SplitN(line, "\t", 2)
+		if len(split) != 2 {
+			continue
+		}
+		dir, err := filepath.Rel(self.dir(), split[1])
+		if err != nil {
+			dir = split[1]
+		}
+		self.dirs[split[0]] = dir
+	}
+
+	return nil
+}
+
+func (self *context) EnumerateRunners(seed int) (traceEvents []tapjio.TraceEvent, testRunners []runner.TestRunner, err error) {
+	cfg := self.config
+	err = self.listPackageDirs()
+	if err != nil {
+		return
+	}
+
+	var keep map[tapjio.TestFilter]bool
+	if len(cfg.Filters) > 0 {
+		keep = make(map[tapjio.TestFilter]bool)
+		for _, filter := range cfg.Filters {
+			keep[filter] = true
+		}
+	}
+
+	cmd := self.goCommand(nil, append([]string{"test", "-json", "-list", "."}, self.patterns()...)...)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return
+	}
+	err = cmd.Start()
+	if err != nil {
+		return
+	}
+
+	var currentRunner *goTestRunner
+	var currentPackage string
+	buildOutput := make(map[string]*bytes.Buffer)
+	var buildFailures []string
+	for event := range decodeEvents(stdout) {
+		switch event.Action {
+		case "output":
+			if event.Test != "" {
+				continue
+			}
+			if buildOutput[event.Package] == nil {
+				buildOutput[event.Package] = &bytes.Buffer{}
+			}
+			buildOutput[event.Package].WriteString(event.Output)
+
+			name := strings.TrimSpace(event.Output)
+			if !testNamePattern.MatchString(name) {
+				continue
+			}
+
+			filter := newFilter(event.Package, name)
+			if keep != nil && !keep[filter] {
+				continue
+			}
+
+			squashPolicy := cfg.SquashPolicy
+			if squashPolicy == runner.SquashNothing ||
+				squashPolicy == runner.SquashByFile && (currentRunner == nil || currentPackage != event.Package) ||
+				squashPolicy == runner.SquashAll && currentRunner == nil {
+				if currentRunner != nil {
+					testRunners = append(testRunners, *currentRunner)
+				}
+				currentRunner = &goTestRunner{ctx: self}
+				currentPackage = event.Package
+			}
+			currentRunner.filters = append(currentRunner.filters, filter)
+		case "build-output":
+			pkg := strings.SplitN(event.ImportPath, " ", 2)[0]
+			if buildOutput[pkg] == nil {
+				buildOutput[pkg] = &bytes.Buffer{}
+			}
+			buildOutput[pkg].WriteString(event.Output)
+		case "fail":
+			if event.Test == "" {
+				buildFailures = append(buildFailures, event.Package)
+			}
+		}
+	}
+	if currentRunner != nil {
+		testRunners = append(testRunners, *currentRunner)
+	}
+
+	waitErr := cmd.Wait()
+	if len(buildFailures) > 0 {
+		var messages []string
+		for _, pkg := range buildFailures {
+			if output, ok := buildOutput[pkg]; ok {
+				messages = append(messages, output.String())
+			}
+		}
+		err = fmt.Errorf("Could not list tests in %s:\n%s",
+			strings.Join(buildFailures, ", "),
+			strings.Join(messages, ""))
+		return
+	}
+	if waitErr != nil {
+		err = fmt.Errorf("Listing tests with go test failed: %v", waitErr)
+	}
+
+	return
+}
+
+func newFilter(pkg string, name string) tapjio.TestFilter {
+	return tapjio.TestFilter(pkg + "#" + name)
+}
+
+func splitFilter(filter tapjio.TestFilter) (pkg string, name string) {
+	split := strings.SplitN(filter.String(), "#", 2)
+	if len(split) != 2 {
+		return "", filter.String()
+	}
+
+	return split[0], split[1]
+}
+
+type goTestRunner struct {
+	ctx     *context
+	filters []tapjio.TestFilter
+}
+
+func (self goTestRunner) TestCount() int {
+	return len(self.filters)
+}
+
+func (self goTestRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self goTestRunner) Dependencies() []runner.TestDependencyEntry {
+	return nil
+}
+
+func (self goTestRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	return goTestRunner{
+		ctx:     self.ctx,
+		filters: append([]tapjio.TestFilter{}, filters...),
+	}
+}
+
+// Run runs the runner's tests, with one go test process for each package in turn.
+func (self goTestRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	var packages []string
+	names := make(map[string][]string)
+	for _, filter := range self.filters {
+		pkg, name := splitFilter(filter)
+		if _, ok := names[pkg]; !ok {
+			packages = append(packages, pkg)
+		}
+		names[pkg] = append(names[pkg], name)
+	}
+
+	for _, pkg := range packages {
+		select {
+		case <-quitChan:
+			return nil
+		default:
+		}
+
+		err := self.runPackage(pkg, names[pkg], env, seed, quitChan, visitor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// runPackage runs the named tests in pkg with go test. If go test exits without reporting some
+// of them, those are run again with a fresh go test, as long as some progress was made. Tests
+// that time out are reported as errors, and the ones that hadn't started yet are run again.
+func (self goTestRunner) runPackage(
+	pkg string,
+	names []string,
+	env map[string]string,
+	seed int,
+	quitChan <-chan struct{},
+	visitor tapjio.Visitor) error {
+
+	cfg := self.ctx.config
+	cmd := self.ctx.goCommand(env,
+		"test", "-json", "-count=1",
+		"-run", "^("+strings.Join(names, "|")+")$",
+		pkg)
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+	events := decodeEvents(stdout)
+
+	kill := func() {
+		syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+		cmd.Wait()
+	}
+
+	// If we stop reading events early, let the decoder finish anyway.
+	defer func() {
+		go func() {
+			for range events {
+			}
+		}()
+	}()
+
+	unfinished := make(map[string]bool)
+	for _, name := range names {
+		unfinished[name] = true
+	}
+	started := make(map[string]bool)
+	running := make(map[string]bool)
+	outputs := make(map[string]*bytes.Buffer)
+	packageOutput := &bytes.Buffer{}
+	testTimedOut := false
+
+	var runnerTimedOut <-chan time.Time
+	if cfg.RunnerTimeout > 0 {
+		timer := time.NewTimer(cfg.RunnerTimeout)
+		defer timer.Stop()
+		runnerTimedOut = timer.C
+	}
+
+	// The test timer runs while any test is running, and restarts with each event about one.
+	var testTimer *time.Timer
+	var testTimerC <-chan time.Time
+	if cfg.TestTimeout > 0 {
+		testTimer = time.NewTimer(cfg.TestTimeout)
+		testTimer.Stop()
+		defer testTimer.Stop()
+		testTimerC = testTimer.C
+	}
+	resetTestTimer := func() {
+		if testTimer == nil {
+			return
+		}
+		if !testTimer.Stop() {
+			select {
+			case <-testTimer.C:
+			default:
+			}
+		}
+		if len(running) > 0 {
+			testTimer.Reset(cfg.TestTimeout)
+		}
+	}
+
+	var exception *tapjio.TestException
+	for exception == nil {
+		var event testEvent
+		var ok bool
+		select {
+		case event, ok = <-events:
+		case <-quitChan:
+			kill()
+			return nil
+		case <-runnerTimedOut:
+			exception = &tapjio.TestException{
+				Class:   tapjio.TimeoutExceptionClass,
+				Message: fmt.Sprintf("Runner timed out after %v", cfg.RunnerTimeout),
+			}
+			continue
+		case <-testTimerC:
+			testTimedOut = true
+			exception = &tapjio.TestException{
+				Class:   tapjio.TimeoutExceptionClass,
+				Message: fmt.Sprintf("Test timed out after %v", cfg.TestTimeout),
+			}
+			continue
+		}
+
+		if !ok {
+			break
+		}
+
+		if event.Test == "" {
+			if event.Action == "output" || event.Action == "build-output" {
+				packageOutput.WriteString(event.Output)
+			}
+			continue
+		}
+
+		name := strings.SplitN(event.Test, "/", 2)[0]
+		if !unfinished[name] {
+			continue
+		}
+		if outputs[name] == nil {
+			outputs[name] = &bytes.Buffer{}
+		}
+
+		switch event.Action {
+		case "output":
+			outputs[name].WriteString(event.Output)
+		case "run", "cont":
+			if event.Test == name {
+				if !started[name] {
+					started[name] = true
+					begin := tapjio.NewTestBeginEvent()
+					begin.Timestamp = timestamp(event.Time)
+					begin.Label = name
+					begin.Filter = newFilter(pkg, name)
+					begin.File = tapjio.FilePath(pkg)
+					err = visitor.TestBegin(*begin)
+					if err != nil {
+						kill()
+						return err
+					}
+				}
+				running[name] = true
+			}
+			resetTestTimer()
+		case "pause":
+			if event.Test == name {
+				delete(running, name)
+			}
+			resetTestTimer()
+		case "pass", "fail", "skip":
+			if event.Test == name {
+				delete(unfinished, name)
+				delete(running, name)
+
+				err = visitor.TestFinish(self.finishEvent(pkg, name, event, outputs[name].String()))
+				if err != nil {
+					kill()
+					return err
+				}
+			}
+			resetTestTimer()
+		}
+	}
+
+	var exitErr error
+	if exception != nil {
+		kill()
+	} else {
+		exitErr = cmd.Wait()
+	}
+
+	var rest []tapjio.TestFilter
+	for _, name := range names {
+		if !unfinished[name] {
+			continue
+		}
+
+		// Tests that hadn't started when another timed out or crashed go test (e.g. by calling
+		// os.Exit) get another chance. The ones that had started are to blame.
+		if !started[name] && len(started) > 0 && (exception == nil || testTimedOut) {
+			rest = append(rest, newFilter(pkg, name))
+			continue
+		}
+
+		e := exception
+		if e == nil {
+			message := "go test exited before reporting this test"
+			if exitErr != nil {
+				message = fmt.Sprintf("go test exited before reporting this test: %v", exitErr)
+			}
+			if packageOutput.Len() > 0 {
+				message += "\n" + packageOutput.String()
+			}
+			e = &tapjio.TestException{Class: tapjio.WorkerCrashExceptionClass, Message: message}
+		}
+
+		err = visitor.TestFinish(tapjio.TestFinishEvent{
+			Type:      "test",
+			Label:     name,
+			Runner:    cfg.Name,
+			Filter:    newFilter(pkg, name),
+			File:      tapjio.FilePath(pkg),
+			Status:    tapjio.Error,
+			Exception: e,
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if len(rest) == 0 {
+		return nil
+	}
+
+	return self.Subset(rest).Run(env, seed, quitChan, visitor)
+}
+
+func timestamp(t time.Time) float64 {
+	if t.IsZero() {
+		t = time.Now()
+	}
+
+	return float64(t.UnixNano()) / float64(time.Second)
+}
+
+// finishEvent translates the final event for a test, along with everything it wrote, into a
+// TestFinishEvent.
+func (self goTestRunner) finishEvent(pkg string, name string, event testEvent, output string) tapjio.TestFinishEvent {
+	finish := tapjio.TestFinishEvent{
+		Type:      "test",
+		Time:      event.Elapsed,
+		Runner:    self.ctx.config.Name,
+		Timestamp: timestamp(event.Time),
+		Label:     name,
+		Filter:    newFilter(pkg, name),
+		File:      tapjio.FilePath(pkg),
+		Stdout:    stripFrameLines(output),
+	}
+
+	switch event.Action {
+	case "pass":
+		finish.Status = tapjio.Pass
+	case "skip":
+		finish.Status = tapjio.Omit
+	case "fail":
+		finish.Status = tapjio.Fail
+		finish.Exception = self.failureException(pkg, output)
+		if finish.Exception.Class == panicExceptionClass {
+			finish.Status = tapjio.Error
+		}
+	}
+
+	return finish
+}
+
+func stripFrameLines(output string) string {
+	var lines []string
+	for _, line := range strings.SplitAfter(output, "\n") {
+		if !frameLinePattern.MatchString(line) {
+			lines = append(lines, line)
+		}
+	}
+
+	return strings.Join(lines, "")
+}
+
+// failureException describes why a test failed, given its output. If it panicked, that's the
+// panic and the stack of the goroutine that panicked. Otherwise it's what the test logged, and
+// where.
+func (self goTestRunner) failureException(pkg string, output string) *tapjio.TestException {
+	lines := strings.Split(stripFrameLines(output), "\n")
+
+	for ix, line := range lines {
+		if !strings.HasPrefix(line, "panic: ") {
+			continue
+		}
+
+		exception := &tapjio.TestException{
+			Class:   panicExceptionClass,
+			Message: strings.TrimPrefix(line, "panic: "),
+		}
+		for traceIx := ix + 1; traceIx < len(lines); traceIx++ {
+			match := traceLinePattern.FindStringSubmatch(lines[traceIx])
+			if match == nil {
+				continue
+			}
+			lineNumber, _ := strconv.Atoi(match[2])
+			method := lines[traceIx-1]
+			if paren := strings.LastIndex(method, "("); paren > 0 {
+				method = method[:paren]
+			}
+			exception.Backtrace = append(exception.Backtrace, self.location(match[1], lineNumber, method))
+		}
+
+		return exception
+	}
+
+	exception := &tapjio.TestException{Class: failureExceptionClass}
+	var messages []string
+	dir := self.ctx.dirs[pkg]
+	for _, line := range lines {
+		match := logLinePattern.FindStringSubmatch(line)
+		if match != nil {
+			lineNumber, _ := strconv.Atoi(match[2])
+			exception.Backtrace = append(exception.Backtrace, self.location(filepath.Join(dir, match[1]), lineNumber, ""))
+		}
+		if strings.TrimSpace(line) != "" {
+			messages = append(messages, strings.TrimSpace(line))
+		}
+	}
+	exception.Message = strings.Join(messages, "\n")
+
+	return exception
+}
+
+// location returns a backtrace location for file, which may be absolute or relative to the
+// runner's directory. Locations within the runner's directory are marked as user code.
+func (self goTestRunner) location(file string, line int, method string) tapjio.BacktraceLocation {
+	dir := self.ctx.dir()
+	user := !filepath.IsAbs(file)
+	if filepath.IsAbs(file) {
+		if rel, err := filepath.Rel(dir, file); err == nil && !strings.HasPrefix(rel, "..") {
+			file = rel
+			user = true
+		}
+	}
+
+	return tapjio.BacktraceLocation{
+		File:   file,
+		Line:   line,
+		Method: method,
+		User:   user,
+	}
+}
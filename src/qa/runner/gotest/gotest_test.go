@@ -0,0 +1,205 @@
+package gotest
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"testing"
+	"time"
+
+	"qa/runner"
+	"qa/tapjio"
+)
+
+var testModule = map[string]string{
+	"go.mod": "module example.com/gt\n",
+	"a/a_test.go": `package a
+
+import (
+	"fmt"
+	"os"
+	"testing"
+)
+
+func TestOne(t *testing.T) {
+	fmt.Println("hello from", os.Getenv("QA_WORKER"))
+}
+
+func TestTwo(t *testing.T) {
+	t.Run("sub", func(t *testing.T) {
+		t.Errorf("bad %d", 1)
+	})
+}
+
+func TestSkip(t *testing.T) {
+	t.Skip("not today")
+}
+
+func BenchmarkIgnored(b *testing.B) {}
+`,
+	"b/b_test.go": `package b
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+func TestPanic(t *testing.T) {
+	panic("boom")
+}
+
+func TestHang(t *testing.T) {
+	time.Sleep(time.Minute)
+}
+
+func TestExit(t *testing.T) {
+	os.Exit(3)
+}
+
+func TestAfter(t *testing.T) {}
+`,
+}
+
+func TestGoTestRunner(t *testing.T) {
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip("go isn't installed")
+	}
+
+	dir, err := ioutil.TempDir("", "gotest")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range testModule {
+		path := filepath.Join(dir, name)
+		err = os.MkdirAll(filepath.Dir(path), 0755)
+		if err != nil {
+			t.Fatal(err)
+		}
+		err = ioutil.WriteFile(path, []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, err := StartContext(runner.Config{
+		Name:         "gotest",
+		FileLister:   runner.NewFileGlob(dir, []string{"./..."}),
+		Dir:          dir,
+		EnvVars:      map[string]string{"GO111MODULE": "on", "GOFLAGS": ""},
+		SquashPolicy: runner.SquashByFile,
+		TestTimeout:  5 * time.Second,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	_, runners, err := ctx.EnumerateRunners(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runners) != 2 {
+		t.Fatalf("Expected a runner for each package, got %d", len(runners))
+	}
+
+	var filters []string
+	for _, testRunner := range runners {
+		for _, filter := range testRunner.Filters() {
+			filters = append(filters, filter.String())
+		}
+	}
+	sort.Strings(filters)
+	expectedFilters := []string{
+		"example.com/gt/a#TestOne",
+		"example.com/gt/a#TestSkip",
+		"example.com/gt/a#TestTwo",
+		"example.com/gt/b#TestAfter",
+		"example.com/gt/b#TestExit",
+		"example.com/gt/b#TestHang",
+		"example.com/gt/b#TestPanic",
+	}
+	if strings.Join(filters, " ") != strings.Join(expectedFilters, " ") {
+		t.Fatalf("Expected filters %v, got %v", expectedFilters, filters)
+	}
+
+	begun := make(map[tapjio.TestFilter]bool)
+	results := make(map[tapjio.TestFilter]tapjio.TestFinishEvent)
+	visitor := &tapjio.DecodingCallbacks{
+		OnTestBegin: func(event tapjio.TestBeginEvent) error {
+			begun[event.Filter] = true
+			return nil
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			results[event.Filter] = event
+			return nil
+		},
+	}
+	for _, testRunner := range runners {
+		err = testRunner.Run(map[string]string{"QA_WORKER": "7"}, 1, make(chan struct{}), visitor)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"example.com/gt/a#TestOne":   "pass",
+		"example.com/gt/a#TestTwo":   "fail " + failureExceptionClass,
+		"example.com/gt/a#TestSkip":  "omit",
+		"example.com/gt/b#TestPanic": "error " + panicExceptionClass,
+		"example.com/gt/b#TestHang":  "error " + tapjio.TimeoutExceptionClass,
+		"example.com/gt/b#TestExit":  "error " + tapjio.WorkerCrashExceptionClass,
+		"example.com/gt/b#TestAfter": "pass",
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %#v", len(expected), results)
+	}
+	for filter, outcome := range expected {
+		event := results[tapjio.TestFilter(filter)]
+		actual := string(event.Status)
+		if event.Exception != nil {
+			actual += " " + event.Exception.Class
+		}
+		if actual != outcome {
+			t.Fatalf("Expected %s for %s, got %s: %#v", outcome, filter, actual, event)
+		}
+		if event.Runner != "gotest" {
+			t.Fatalf("Expected runner to be recorded for %s, got %s", filter, event.Runner)
+		}
+		if !begun[tapjio.TestFilter(filter)] {
+			t.Fatalf("Expected a begin event for %s", filter)
+		}
+	}
+
+	one := results["example.com/gt/a#TestOne"]
+	if one.Stdout != "hello from 7\n" || one.File != "example.com/gt/a" {
+		t.Fatalf("Expected output and package for TestOne, got %#v", one)
+	}
+
+	two := results["example.com/gt/a#TestTwo"].Exception
+	if two.Message != "a_test.go:15: bad 1" {
+		t.Fatalf("Expected failure message for TestTwo, got %q", two.Message)
+	}
+	if len(two.Backtrace) != 1 || two.Backtrace[0].File != filepath.Join("a", "a_test.go") || two.Backtrace[0].Line != 15 {
+		t.Fatalf("Expected failure location for TestTwo, got %#v", two.Backtrace)
+	}
+
+	panicked := results["example.com/gt/b#TestPanic"].Exception
+	if !strings.HasPrefix(panicked.Message, "boom") {
+		t.Fatalf("Expected panic message for TestPanic, got %q", panicked.Message)
+	}
+	foundFrame := false
+	for _, location := range panicked.Backtrace {
+		if location.User && location.File == filepath.Join("b", "b_test.go") && location.Line == 10 {
+			foundFrame = true
+		}
+	}
+	if !foundFrame {
+		t.Fatalf("Expected panic location for TestPanic, got %#v", panicked.Backtrace)
+	}
+}
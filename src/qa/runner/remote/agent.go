@@ -0,0 +1,312 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+
+	"qa/runner"
+	"qa/tapjio"
+)
+
+// Agent runs tests on behalf of a coordinator, with one worker for each of WorkerEnvs.
+type Agent struct {
+	Name       string
+	WorkerEnvs []map[string]string
+	Log        io.Writer
+
+	// StartContext starts a local context for the runner config with the given name, patterns,
+	// and filters.
+	StartContext func(name string, patterns []string, filters []tapjio.TestFilter) (runner.Context, error)
+
+	address  string
+	mutex    *sync.Mutex
+	encoder  *json.Encoder
+	contexts map[string]*agentContext
+	quits    map[string]chan struct{}
+}
+
+type agentContext struct {
+	ready        chan struct{}
+	ctx          runner.Context
+	err          error
+	mutex        *sync.Mutex
+	enumerations map[int]*agentEnumeration
+}
+
+type agentEnumeration struct {
+	ready   chan struct{}
+	runners []runner.TestRunner
+	index   map[tapjio.TestFilter]int
+	err     error
+}
+
+// Serve connects to the coordinator at the given address and runs whatever it asks for, until
+// it disconnects.
+func (self *Agent) Serve(address string) error {
+	conn, err := net.Dial("tcp", address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	self.address = address
+	self.mutex = &sync.Mutex{}
+	self.encoder = json.NewEncoder(conn)
+	self.contexts = make(map[string]*agentContext)
+	self.quits = make(map[string]chan struct{})
+	defer self.closeContexts()
+
+	_, err = io.WriteString(conn, agentToken+"\n")
+	if err != nil {
+		return err
+	}
+
+	err = self.send(&message{Hello: &helloMessage{Name: self.Name, Jobs: len(self.WorkerEnvs)}})
+	if err != nil {
+		return err
+	}
+
+	var running sync.WaitGroup
+	defer running.Wait()
+
+	decoder := json.NewDecoder(bufio.NewReader(conn))
+	for {
+		var msg message
+		err := decoder.Decode(&msg)
+		if err == io.EOF {
+			self.cancelAll()
+			return nil
+		}
+		if err != nil {
+			self.cancelAll()
+			return err
+		}
+
+		switch {
+		case msg.Reject != "":
+			return errors.New("Coordinator rejected agent: " + msg.Reject)
+		case msg.Start != nil:
+			go self.context(*msg.Start)
+		case msg.Enumerate != nil:
+			enumerate := *msg.Enumerate
+			go func() {
+				reply := &runnersMessage{Id: enumerate.Id}
+				enumeration := self.enumeration(enumerate.Runner, enumerate.Seed)
+				if enumeration.err != nil {
+					reply.Error = enumeration.err.Error()
+				}
+				for _, testRunner := range enumeration.runners {
+					reply.Runners = append(reply.Runners, runnerInfo{
+						Filters: testRunner.Filters(),
+						Count:   testRunner.TestCount(),
+					})
+				}
+				self.send(&message{Runners: reply})
+			}()
+		case msg.Run != nil:
+			run := *msg.Run
+			quitChan := make(chan struct{})
+			self.mutex.Lock()
+			self.quits[run.Address] = quitChan
+			self.mutex.Unlock()
+
+			running.Add(1)
+			go func() {
+				defer running.Done()
+				err := self.run(run, quitChan)
+
+				self.mutex.Lock()
+				delete(self.quits, run.Address)
+				self.mutex.Unlock()
+
+				done := &doneMessage{Address: run.Address}
+				if err != nil {
+					fmt.Fprintf(self.Log, "Error running %d tests: %v\n", len(run.Filters), err)
+					done.Error = err.Error()
+				}
+				self.send(&message{Done: done})
+			}()
+		case msg.Cancel != "":
+			self.mutex.Lock()
+			if quitChan, ok := self.quits[msg.Cancel]; ok {
+				delete(self.quits, msg.Cancel)
+				close(quitChan)
+			}
+			self.mutex.Unlock()
+		}
+	}
+}
+
+func (self *Agent) send(msg *message) error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	return self.encoder.Encode(msg)
+}
+
+func (self *Agent) cancelAll() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for address, quitChan := range self.quits {
+		delete(self.quits, address)
+		close(quitChan)
+	}
+}
+
+func (self *Agent) closeContexts() {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for _, c := range self.contexts {
+		<-c.ready
+		if c.ctx != nil {
+			c.ctx.Close()
+		}
+	}
+}
+
+// context returns the context for the given key, starting it if needed.
+func (self *Agent) context(key runnerKey) *agentContext {
+	self.mutex.Lock()
+	c, ok := self.contexts[key.String()]
+	if !ok {
+		c = &agentContext{
+			ready:        make(chan struct{}),
+			mutex:        &sync.Mutex{},
+			enumerations: make(map[int]*agentEnumeration),
+		}
+		self.contexts[key.String()] = c
+	}
+	self.mutex.Unlock()
+
+	if !ok {
+		fmt.Fprintf(self.Log, "Starting %s for %s\n", key.Name, strings.Join(key.Patterns, " "))
+		c.ctx, c.err = self.StartContext(key.Name, key.Patterns, key.Filters)
+		close(c.ready)
+	}
+
+	<-c.ready
+	return c
+}
+
+// enumeration returns the runners for the given key and seed, listing them if needed.
+func (self *Agent) enumeration(key runnerKey, seed int) *agentEnumeration {
+	c := self.context(key)
+	if c.err != nil {
+		return &agentEnumeration{err: c.err}
+	}
+
+	c.mutex.Lock()
+	e, ok := c.enumerations[seed]
+	if !ok {
+		e = &agentEnumeration{ready: make(chan struct{})}
+		c.enumerations[seed] = e
+	}
+	c.mutex.Unlock()
+
+	if !ok {
+		_, e.runners, e.err = c.ctx.EnumerateRunners(seed)
+		e.index = make(map[tapjio.TestFilter]int)
+		for ix, testRunner := range e.runners {
+			for _, filter := range testRunner.Filters() {
+				e.index[filter] = ix
+			}
+		}
+		close(e.ready)
+	}
+
+	<-e.ready
+	return e
+}
+
+// resolve returns local runners for the given tests, in the order given.
+func (self *agentEnumeration) resolve(filters []tapjio.TestFilter) ([]runner.TestRunner, error) {
+	var runners []runner.TestRunner
+	var group []tapjio.TestFilter
+	last := -1
+	flush := func() {
+		if len(group) == 0 {
+			return
+		}
+
+		testRunner := self.runners[last]
+		if len(group) == len(testRunner.Filters()) {
+			runners = append(runners, testRunner)
+		} else {
+			runners = append(runners, testRunner.Subset(group))
+		}
+		group = nil
+	}
+
+	for _, filter := range filters {
+		ix, ok := self.index[filter]
+		if !ok {
+			return nil, fmt.Errorf("No such test here: %s", filter)
+		}
+
+		if ix != last {
+			flush()
+			last = ix
+		}
+		group = append(group, filter)
+	}
+	flush()
+
+	return runners, nil
+}
+
+func (self *Agent) run(run runMessage, quitChan chan struct{}) error {
+	if run.Worker < 0 || run.Worker >= len(self.WorkerEnvs) {
+		return fmt.Errorf("No such worker: %d", run.Worker)
+	}
+
+	enumeration := self.enumeration(run.Runner, run.Seed)
+	if enumeration.err != nil {
+		return enumeration.err
+	}
+
+	runners, err := enumeration.resolve(run.Filters)
+	if err != nil {
+		return err
+	}
+
+	conn, err := net.Dial("tcp", self.address)
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+
+	token := strings.SplitN(run.Address, "@", 2)[0]
+	_, err = io.WriteString(conn, token+"\n")
+	if err != nil {
+		return err
+	}
+
+	emitter := tapjio.NewTapjEmitter(conn)
+	visitor := &tapjio.DecodingCallbacks{
+		OnTestBegin: emitter.TestBegin,
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			// Dependencies are encoded relative to an index that isn't sent along.
+			event.Dependencies = nil
+			return emitter.TestFinish(event)
+		},
+		OnTrace:       emitter.TraceEvent,
+		OnAwaitAttach: emitter.AwaitAttach,
+	}
+
+	for _, testRunner := range runners {
+		err = testRunner.Run(self.WorkerEnvs[run.Worker], run.Seed, quitChan, visitor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
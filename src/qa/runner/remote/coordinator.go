@@ -0,0 +1,441 @@
+package remote
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"sync"
+	"time"
+
+	"qa/runner"
+	"qa/runner/server"
+	"qa/tapjio"
+)
+
+type agentConn struct {
+	name    string
+	jobs    int
+	conn    net.Conn
+	encoder *json.Encoder
+	closed  bool
+
+	// Held while writing to conn, which is done without holding the coordinator's mutex.
+	writeMutex *sync.Mutex
+
+	// Guarded by the coordinator's mutex.
+	pending      map[string]chan error
+	enumerations map[int]chan *runnersMessage
+}
+
+type slot struct {
+	agent  *agentConn
+	worker int
+}
+
+// Coordinator hands out TestRunners to agents that connect to its server, as they have
+// workers free. Results stream back through the server like those of local workers.
+type Coordinator struct {
+	srv   *server.Server
+	log   io.Writer
+	mutex *sync.Mutex
+	cond  *sync.Cond
+
+	started  bool
+	closed   bool
+	agents   []*agentConn
+	idle     []*slot
+	live     int
+	nextId   int
+	starting []runnerKey
+}
+
+func NewCoordinator(srv *server.Server, log io.Writer) (*Coordinator, error) {
+	mutex := &sync.Mutex{}
+	self := &Coordinator{
+		srv:   srv,
+		log:   log,
+		mutex: mutex,
+		cond:  sync.NewCond(mutex),
+	}
+
+	err := srv.Serve(agentToken, self.accept)
+	if err != nil {
+		return nil, err
+	}
+
+	return self, nil
+}
+
+// How long writing a message to an agent may take before the agent is given up on.
+const agentWriteTimeout = 30 * time.Second
+
+// send writes msg to the given agent. Must be called without the mutex held, so that an agent
+// that stops reading doesn't hold up the others.
+func (self *Coordinator) send(agent *agentConn, msg *message) error {
+	self.mutex.Lock()
+	closed := agent.closed
+	self.mutex.Unlock()
+	if closed {
+		return fmt.Errorf("Agent %s is gone", agent.name)
+	}
+
+	agent.writeMutex.Lock()
+	agent.conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+	err := agent.encoder.Encode(msg)
+	agent.writeMutex.Unlock()
+
+	if err != nil {
+		self.mutex.Lock()
+		self.drop(agent, err)
+		self.mutex.Unlock()
+	}
+
+	return err
+}
+
+func (self *Coordinator) accept(conn net.Conn, reader *bufio.Reader) {
+	decoder := json.NewDecoder(reader)
+	var hello message
+	err := decoder.Decode(&hello)
+	if err != nil || hello.Hello == nil || hello.Hello.Jobs < 1 {
+		conn.Close()
+		return
+	}
+
+	agent := &agentConn{
+		name:         hello.Hello.Name,
+		jobs:         hello.Hello.Jobs,
+		conn:         conn,
+		encoder:      json.NewEncoder(conn),
+		writeMutex:   &sync.Mutex{},
+		pending:      make(map[string]chan error),
+		enumerations: make(map[int]chan *runnersMessage),
+	}
+
+	self.mutex.Lock()
+	if self.started || self.closed {
+		self.mutex.Unlock()
+		conn.SetWriteDeadline(time.Now().Add(agentWriteTimeout))
+		agent.encoder.Encode(&message{Reject: "Tests are already running"})
+		conn.Close()
+		return
+	}
+
+	self.agents = append(self.agents, agent)
+	for worker := 0; worker < agent.jobs; worker++ {
+		self.idle = append(self.idle, &slot{agent: agent, worker: worker})
+	}
+	self.live += agent.jobs
+	fmt.Fprintf(self.log, "Agent %s connected with %d workers.\n", agent.name, agent.jobs)
+	self.cond.Broadcast()
+	self.mutex.Unlock()
+
+	for {
+		var msg message
+		err := decoder.Decode(&msg)
+		if err != nil {
+			if err == io.EOF {
+				err = errors.New("Disconnected")
+			}
+			self.mutex.Lock()
+			self.drop(agent, err)
+			self.mutex.Unlock()
+			return
+		}
+
+		self.mutex.Lock()
+		if done := msg.Done; done != nil {
+			if ch, ok := agent.pending[done.Address]; ok {
+				delete(agent.pending, done.Address)
+				if done.Error != "" {
+					ch <- errors.New(done.Error)
+				}
+				close(ch)
+			}
+		} else if runners := msg.Runners; runners != nil {
+			if ch, ok := agent.enumerations[runners.Id]; ok {
+				delete(agent.enumerations, runners.Id)
+				ch <- runners
+			}
+		}
+		self.mutex.Unlock()
+	}
+}
+
+// drop forgets about an agent that is gone, failing whatever it was doing. Must be called with
+// the mutex held.
+func (self *Coordinator) drop(agent *agentConn, reason error) {
+	if agent.closed {
+		return
+	}
+
+	agent.closed = true
+	agent.conn.Close()
+	if !self.closed {
+		fmt.Fprintf(self.log, "Agent %s is gone: %v\n", agent.name, reason)
+	}
+
+	var idle []*slot
+	for _, s := range self.idle {
+		if s.agent != agent {
+			idle = append(idle, s)
+		}
+	}
+	self.idle = idle
+	self.live -= agent.jobs
+	self.cond.Broadcast()
+
+	err := fmt.Errorf("Agent %s is gone: %v", agent.name, reason)
+	for address, ch := range agent.pending {
+		delete(agent.pending, address)
+		ch <- err
+		close(ch)
+	}
+
+	for id, ch := range agent.enumerations {
+		delete(agent.enumerations, id)
+		ch <- &runnersMessage{Id: id, Error: err.Error()}
+	}
+}
+
+// WaitForAgents blocks until at least n agents have connected, then stops accepting new ones.
+// Returns the total number of workers they have.
+func (self *Coordinator) WaitForAgents(n int) (int, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for len(self.agents) < n && !self.closed {
+		self.cond.Wait()
+	}
+
+	if self.closed {
+		return 0, errors.New("Coordinator closed")
+	}
+
+	self.started = true
+	return self.live, nil
+}
+
+func (self *Coordinator) Close() error {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	self.closed = true
+	for _, agent := range self.agents {
+		self.drop(agent, errors.New("Coordinator closed"))
+	}
+	self.cond.Broadcast()
+
+	return nil
+}
+
+func (self *Coordinator) acquire() (*slot, error) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	for len(self.idle) == 0 && self.live > 0 {
+		self.cond.Wait()
+	}
+
+	if len(self.idle) == 0 {
+		return nil, errors.New("No agents left")
+	}
+
+	s := self.idle[0]
+	self.idle = self.idle[1:]
+	return s, nil
+}
+
+func (self *Coordinator) release(s *slot) {
+	self.mutex.Lock()
+	defer self.mutex.Unlock()
+
+	if !s.agent.closed {
+		self.idle = append(self.idle, s)
+		self.cond.Signal()
+	}
+}
+
+// StartContext asks every agent to start a context for the given runner config, and returns a
+// context whose runners are run by whichever agent has a worker free.
+func (self *Coordinator) StartContext(runnerConfig runner.Config) (runner.Context, error) {
+	key := runnerKey{
+		Name:     runnerConfig.Name,
+		Patterns: runnerConfig.FileLister.Patterns(),
+		Filters:  runnerConfig.Filters,
+	}
+
+	self.mutex.Lock()
+	agents := append([]*agentConn{}, self.agents...)
+	self.mutex.Unlock()
+
+	for _, agent := range agents {
+		self.send(agent, &message{Start: &key})
+	}
+
+	return &remoteContext{coordinator: self, key: key}, nil
+}
+
+// enumerate asks the first agent that can to list runners for the given key.
+func (self *Coordinator) enumerate(key runnerKey, seed int) ([]runnerInfo, error) {
+	self.mutex.Lock()
+	agents := append([]*agentConn{}, self.agents...)
+	self.mutex.Unlock()
+
+	err := errors.New("No agents left")
+	for _, agent := range agents {
+		self.mutex.Lock()
+		self.nextId++
+		id := self.nextId
+		ch := make(chan *runnersMessage, 1)
+		agent.enumerations[id] = ch
+		self.mutex.Unlock()
+
+		sendErr := self.send(agent, &message{Enumerate: &enumerateMessage{Id: id, Runner: key, Seed: seed}})
+		if sendErr != nil {
+			self.mutex.Lock()
+			delete(agent.enumerations, id)
+			self.mutex.Unlock()
+			continue
+		}
+
+		reply := <-ch
+		if reply.Error == "" {
+			return reply.Runners, nil
+		}
+		err = fmt.Errorf("Agent %s: %s", agent.name, reply.Error)
+	}
+
+	return nil, err
+}
+
+type remoteContext struct {
+	coordinator *Coordinator
+	key         runnerKey
+}
+
+func (self *remoteContext) EnumerateRunners(seed int) ([]tapjio.TraceEvent, []runner.TestRunner, error) {
+	infos, err := self.coordinator.enumerate(self.key, seed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var runners []runner.TestRunner
+	for _, info := range infos {
+		runners = append(runners, &remoteRunner{
+			coordinator: self.coordinator,
+			key:         self.key,
+			filters:     info.Filters,
+			count:       info.Count,
+		})
+	}
+
+	return nil, runners, nil
+}
+
+func (self *remoteContext) Close() error {
+	return nil
+}
+
+type remoteRunner struct {
+	coordinator *Coordinator
+	key         runnerKey
+	filters     []tapjio.TestFilter
+	count       int
+}
+
+func (self *remoteRunner) Dependencies() []runner.TestDependencyEntry {
+	return nil
+}
+
+func (self *remoteRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self *remoteRunner) TestCount() int {
+	return self.count
+}
+
+func (self *remoteRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	return &remoteRunner{
+		coordinator: self.coordinator,
+		key:         self.key,
+		filters:     append([]tapjio.TestFilter{}, filters...),
+		count:       len(filters),
+	}
+}
+
+// Run waits for a free worker on any agent and runs the tests there. The env given is ignored,
+// as agents give each of their workers an env of their own.
+func (self *remoteRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	c := self.coordinator
+	s, err := c.acquire()
+	if err != nil {
+		return err
+	}
+	defer c.release(s)
+
+	// Tests that ran earlier on the same agent's worker are the ones that may have affected
+	// these, whichever of our own workers they were given to.
+	worker := fmt.Sprintf("%s/%d", s.agent.name, s.worker)
+	address, errChan, err := c.srv.Decode(&tapjio.DecodingCallbacks{
+		OnTestBegin: func(event tapjio.TestBeginEvent) error {
+			event.Worker = worker
+			return visitor.TestBegin(event)
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			event.Worker = worker
+			return visitor.TestFinish(event)
+		},
+		OnTrace:       visitor.TraceEvent,
+		OnAwaitAttach: visitor.AwaitAttach,
+	})
+	if err != nil {
+		return err
+	}
+
+	done := make(chan error, 1)
+	c.mutex.Lock()
+	s.agent.pending[address] = done
+	c.mutex.Unlock()
+
+	err = c.send(s.agent, &message{Run: &runMessage{
+		Address: address,
+		Runner:  self.key,
+		Worker:  s.worker,
+		Seed:    seed,
+		Filters: self.filters,
+	}})
+	if err != nil {
+		c.mutex.Lock()
+		delete(s.agent.pending, address)
+		c.mutex.Unlock()
+
+		c.srv.Disconnect(address)
+		<-errChan
+		return err
+	}
+
+	var doneErr error
+	for waiting := true; waiting; {
+		select {
+		case <-quitChan:
+			quitChan = nil
+			c.send(s.agent, &message{Cancel: address})
+		case doneErr = <-done:
+			waiting = false
+		}
+	}
+
+	if doneErr != nil {
+		// In case the agent never connected to send results, or its connection was left open.
+		c.srv.Disconnect(address)
+		<-errChan
+		return doneErr
+	}
+
+	return <-errChan
+}
@@ -0,0 +1,77 @@
+package remote
+
+import (
+	"encoding/json"
+
+	"qa/tapjio"
+)
+
+// Agents connect to the coordinator's server and start with this token. After that, the
+// coordinator and agent exchange newline-delimited JSON messages on the connection. Test
+// results go to the same server, each run on a new connection that starts with the token in
+// the address the coordinator gave for it, just as local workers report their results.
+const agentToken = "qa-agent"
+
+// message holds exactly one of the messages below.
+type message struct {
+	// From agent to coordinator.
+	Hello   *helloMessage   `json:"hello,omitempty"`
+	Runners *runnersMessage `json:"runners,omitempty"`
+	Done    *doneMessage    `json:"done,omitempty"`
+
+	// From coordinator to agent.
+	Start     *runnerKey        `json:"start,omitempty"`
+	Enumerate *enumerateMessage `json:"enumerate,omitempty"`
+	Run       *runMessage       `json:"run,omitempty"`
+	Cancel    string            `json:"cancel,omitempty"`
+	Reject    string            `json:"reject,omitempty"`
+}
+
+// runnerKey identifies a runner config. Agents build their own runner configs (and so, their
+// own contexts) from these, with their own flags.
+type runnerKey struct {
+	Name     string              `json:"name"`
+	Patterns []string            `json:"patterns"`
+	Filters  []tapjio.TestFilter `json:"filters,omitempty"`
+}
+
+func (self runnerKey) String() string {
+	b, _ := json.Marshal(self)
+	return string(b)
+}
+
+type helloMessage struct {
+	Name string `json:"name"`
+	Jobs int    `json:"jobs"`
+}
+
+type enumerateMessage struct {
+	Id     int       `json:"id"`
+	Runner runnerKey `json:"runner"`
+	Seed   int       `json:"seed"`
+}
+
+type runnerInfo struct {
+	Filters []tapjio.TestFilter `json:"filters"`
+	Count   int                 `json:"count"`
+}
+
+type runnersMessage struct {
+	Id      int          `json:"id"`
+	Runners []runnerInfo `json:"runners"`
+	Error   string       `json:"error,omitempty"`
+}
+
+type runMessage struct {
+	// Where to send the TAP-J events for this run.
+	Address string              `json:"address"`
+	Runner  runnerKey           `json:"runner"`
+	Worker  int                 `json:"worker"`
+	Seed    int                 `json:"seed"`
+	Filters []tapjio.TestFilter `json:"filters"`
+}
+
+type doneMessage struct {
+	Address string `json:"address"`
+	Error   string `json:"error,omitempty"`
+}
@@ -0,0 +1,173 @@
+package remote
+
+import (
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"sync"
+	"testing"
+
+	"qa/runner"
+	"qa/runner/server"
+	"qa/tapjio"
+)
+
+type fakeRunner struct {
+	filters []tapjio.TestFilter
+	ranOn   map[tapjio.TestFilter]string
+	mutex   *sync.Mutex
+}
+
+func (self *fakeRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	for _, filter := range self.filters {
+		begin := tapjio.NewTestBeginEvent()
+		begin.Label = filter.String()
+		begin.Filter = filter
+		err := visitor.TestBegin(*begin)
+		if err != nil {
+			return err
+		}
+
+		self.mutex.Lock()
+		self.ranOn[filter] = env["AGENT"]
+		self.mutex.Unlock()
+
+		err = visitor.TestFinish(tapjio.TestFinishEvent{Type: "test", Label: filter.String(), Filter: filter, Status: tapjio.Pass})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *fakeRunner) Dependencies() []runner.TestDependencyEntry {
+	return nil
+}
+
+func (self *fakeRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self *fakeRunner) TestCount() int {
+	return len(self.filters)
+}
+
+func (self *fakeRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	return &fakeRunner{filters: filters, ranOn: self.ranOn, mutex: self.mutex}
+}
+
+type fakeContext struct {
+	runners []runner.TestRunner
+}
+
+func (self *fakeContext) EnumerateRunners(seed int) ([]tapjio.TraceEvent, []runner.TestRunner, error) {
+	return nil, self.runners, nil
+}
+
+func (self *fakeContext) Close() error {
+	return nil
+}
+
+func TestCoordinatorAndAgents(t *testing.T) {
+	srv, err := server.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer srv.Close()
+
+	coordinator, err := NewCoordinator(srv, ioutil.Discard)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ranOn := make(map[tapjio.TestFilter]string)
+	mutex := &sync.Mutex{}
+	startContext := func(name string, patterns []string, filters []tapjio.TestFilter) (runner.Context, error) {
+		var runners []runner.TestRunner
+		for file := 0; file < 10; file++ {
+			runners = append(runners, &fakeRunner{
+				filters: []tapjio.TestFilter{
+					tapjio.TestFilter(fmt.Sprintf("%s/%d#a", name, file)),
+					tapjio.TestFilter(fmt.Sprintf("%s/%d#b", name, file)),
+				},
+				ranOn: ranOn,
+				mutex: mutex,
+			})
+		}
+		return &fakeContext{runners: runners}, nil
+	}
+
+	agentErrs := make(chan error, 2)
+	for _, name := range []string{"one", "two"} {
+		agent := &Agent{
+			Name:         name,
+			WorkerEnvs:   []map[string]string{{"AGENT": name}, {"AGENT": name}},
+			Log:          ioutil.Discard,
+			StartContext: startContext,
+		}
+		go func() {
+			agentErrs <- agent.Serve(srv.Address())
+		}()
+	}
+
+	workers, err := coordinator.WaitForAgents(2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if workers != 4 {
+		t.Fatalf("Expected 4 workers, got %d", workers)
+	}
+
+	ctx, err := coordinator.StartContext(runner.Config{Name: "fake", FileLister: runner.NewFileGlob("", []string{"*"})})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, runners, err := ctx.EnumerateRunners(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runners) != 10 {
+		t.Fatalf("Expected 10 runners, got %d", len(runners))
+	}
+
+	// Check that agents can run part of a runner, as when retrying.
+	runners[0] = runners[0].Subset(runners[0].Filters()[1:])
+
+	var finished []tapjio.TestFinishEvent
+	tally := &tapjio.ResultTally{}
+	err = runner.RunAll(&tapjio.DecodingCallbacks{
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			finished = append(finished, event)
+			return nil
+		},
+	}, make([]map[string]string, workers), tally, 1, 0, 0, nil, runners)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if tally.Total != 19 || tally.Pass != 19 {
+		t.Fatalf("Expected 19 passing tests, got %#v: %#v", tally, finished)
+	}
+
+	agents := make(map[string]bool)
+	for _, agent := range ranOn {
+		agents[agent] = true
+	}
+	if len(ranOn) != 19 || !agents["one"] || !agents["two"] {
+		t.Fatalf("Expected tests to be spread across both agents, got %v", ranOn)
+	}
+	for _, event := range finished {
+		if !strings.HasPrefix(event.Worker, ranOn[event.Filter]+"/") {
+			t.Fatalf("Expected %s to be reported as run on a worker of agent %s, got %q", event.Filter, ranOn[event.Filter], event.Worker)
+		}
+	}
+
+	coordinator.Close()
+	for ix := 0; ix < 2; ix++ {
+		if err := <-agentErrs; err != nil {
+			t.Fatal(err)
+		}
+	}
+}
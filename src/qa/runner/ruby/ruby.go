@@ -1,15 +1,21 @@
 package ruby
 
 import (
+	"encoding/json"
 	"errors"
 	"fmt"
+	"io/ioutil"
 	"os"
 	"os/exec"
 	"qa/runner"
 	"qa/runner/assets"
 	"qa/runner/server"
 	"qa/tapjio"
+	"strconv"
+	"strings"
 	"sync"
+	"syscall"
+	"time"
 )
 
 type ContextConfig struct {
@@ -19,37 +25,76 @@ type ContextConfig struct {
 }
 
 type context struct {
-	requestCh chan interface{}
-	srv       *server.Server
-	addresses []string
-	process   *os.Process
-	config    *ContextConfig
-	mutex     *sync.Mutex
+	requestCh  chan interface{}
+	srv        *server.Server
+	addresses  []string
+	process    *os.Process
+	config     *ContextConfig
+	workerEnvs []map[string]string
+	mutex      *sync.Mutex
+
+	// Closed once the ruby process that reads from requestCh exits.
+	processDone chan struct{}
+
+	// Incremented each time a ruby process is started for this context.
+	generation int
+
+	// How each generation's ruby process exited, if it did so on its own.
+	exits map[int]string
+
+	restarts     int
+	restartMutex *sync.Mutex
+	closed       bool
 }
 
+// How many times a context will replace a ruby process that exited unexpectedly.
+const maxContextRestarts = 3
+
 func StartContext(srv *server.Server, workerEnvs []map[string]string, cfg *ContextConfig) (*context, error) {
+	ctx := &context{
+		addresses:    []string{},
+		srv:          srv,
+		config:       cfg,
+		workerEnvs:   workerEnvs,
+		mutex:        &sync.Mutex{},
+		exits:        make(map[int]string),
+		restartMutex: &sync.Mutex{},
+	}
+
+	err := ctx.start()
+	if err != nil {
+		return nil, err
+	}
+
+	return ctx, nil
+}
+
+// start launches a new ruby process for the context, which loads every test file and then
+// waits for requests to run tests.
+func (self *context) start() error {
+	cfg := self.config
 	runnerCfg := cfg.RunnerConfig
 
 	files, err := runnerCfg.Files()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	sharedData, err := assets.Asset("ruby/shared.rb")
 	if err != nil {
-		return nil, err
+		return err
 	}
 	var sharedCode = string(sharedData)
 
 	runnerData, err := assets.Asset(cfg.RunnerAssetName)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	var runnerCode = string(runnerData)
 
-	address, requestCh, requestErrChan, err := srv.ExposeChannel()
+	address, requestCh, requestErrChan, err := self.srv.ExposeChannel()
 	if err != nil {
-		return nil, err
+		return err
 	}
 
 	args := []string{
@@ -83,53 +128,70 @@ func StartContext(srv *server.Server, workerEnvs []map[string]string, cfg *Conte
 	cmd.Stdout = os.Stderr
 	err = cmd.Start()
 	if err != nil {
-		return nil, err
+		self.srv.Cancel(address)
+		return err
 	}
 
 	// First request is a list of worker environments and list of all test files to require.
 	requestCh <- map[string](interface{}){
 		"rubylib":     cfg.Rubylib,
-		"workerEnvs":  workerEnvs,
+		"workerEnvs":  self.workerEnvs,
 		"files":       files,
 		"passthrough": runnerCfg.PassthroughConfig,
 	}
 
-	ctx := &context{
-		requestCh: requestCh,
-		addresses: []string{},
-		srv:       srv,
-		config:    cfg,
-		process:   cmd.Process,
-		mutex:     &sync.Mutex{},
-	}
+	processDone := make(chan struct{})
+
+	m := self.mutex
+	m.Lock()
+	self.requestCh = requestCh
+	self.processDone = processDone
+	self.process = cmd.Process
+	self.generation++
+	generation := self.generation
+	m.Unlock()
 
 	go func() {
 		_, ok := <-requestErrChan
 		if ok {
-			ctx.Close()
+			cmd.Process.Kill()
 		}
 	}()
 
 	go func() {
-		defer ctx.cleanupAfterProcessDone()
-		cmd.Process.Wait()
+		state, _ := cmd.Process.Wait()
+		close(processDone)
+		self.cleanupAfterProcessDone(generation, requestCh, state)
 	}()
 
-	return ctx, nil
+	return nil
 }
 
-func (self *context) cleanupAfterProcessDone() {
+func (self *context) cleanupAfterProcessDone(generation int, requestCh chan interface{}, state *os.ProcessState) {
 	m := self.mutex
 	m.Lock()
-	self.process = nil
-	m.Unlock()
+	if self.generation == generation {
+		self.process = nil
+		self.requestCh = nil
+	}
 
-	self.cancelAllVisitorSubscriptions()
+	if !self.closed {
+		exit := "unknown exit status"
+		if state != nil {
+			exit = state.String()
+		}
+		self.exits[generation] = exit
+	}
+	close(requestCh)
 
-	m.Lock()
-	close(self.requestCh)
-	self.requestCh = nil
+	// Nothing will connect to subscriptions made so far. Later ones are for a replacement.
+	addresses := self.addresses
+	self.addresses = []string{}
 	m.Unlock()
+
+	for _, address := range addresses {
+		self.srv.Cancel(address)
+	}
 }
 
 // TODO(adamb) Should also cancel all existing waitgroups
@@ -138,6 +200,7 @@ func (self *context) Close() error {
 	m.Lock()
 	defer m.Unlock()
 
+	self.closed = true
 	if self.process != nil {
 		err := self.process.Kill()
 		if err != nil {
@@ -148,6 +211,49 @@ func (self *context) Close() error {
 	return nil
 }
 
+// exitedSince returns how the ruby process of the given generation exited, if it exited on its
+// own.
+func (self *context) exitedSince(generation int) (string, bool) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	exit, ok := self.exits[generation]
+	return exit, ok
+}
+
+// restart replaces the given generation's ruby process, which exited unexpectedly. If another
+// runner already replaced it, there's nothing to do. Gives up after maxContextRestarts.
+func (self *context) restart(generation int) error {
+	self.restartMutex.Lock()
+	defer self.restartMutex.Unlock()
+
+	m := self.mutex
+	m.Lock()
+	closed := self.closed
+	current := self.generation
+	restarts := self.restarts
+	m.Unlock()
+
+	if closed {
+		return errors.New("Already closed")
+	}
+
+	if current != generation {
+		return nil
+	}
+
+	if restarts >= maxContextRestarts {
+		return fmt.Errorf("Not restarting ruby again after %d restarts", restarts)
+	}
+
+	m.Lock()
+	self.restarts++
+	m.Unlock()
+
+	return self.start()
+}
+
 func (self *context) EnumerateRunners(seed int) (traceEvents []tapjio.TraceEvent, testRunners []runner.TestRunner, err error) {
 	cfg := self.config
 	var currentRunner *rubyRunner
@@ -203,7 +309,7 @@ func (self *context) EnumerateRunners(seed int) (traceEvents []tapjio.TraceEvent
 		args = append(args, filter.String())
 	}
 
-	if err = self.request(map[string]string{}, args); err != nil {
+	if _, err = self.request(map[string]string{}, args); err != nil {
 		self.srv.Cancel(serverAddress)
 		return
 	}
@@ -230,19 +336,9 @@ func (self *context) subscribeVisitor(visitor tapjio.Visitor) (string, chan erro
 	return address, errChan, nil
 }
 
-func (self *context) cancelAllVisitorSubscriptions() {
-	m := self.mutex
-	m.Lock()
-	addresses := append([]string{}, self.addresses...)
-	self.addresses = []string{}
-	m.Unlock()
-
-	for _, address := range addresses {
-		self.srv.Cancel(address)
-	}
-}
-
-func (self *context) request(env map[string]string, args []string) error {
+// request asks the context's ruby process to fork a worker. Returns the generation of the ruby
+// process asked, so that failures can be attributed to it exiting.
+func (self *context) request(env map[string]string, args []string) (int, error) {
 	m := self.mutex
 	m.Lock()
 	defer m.Unlock()
@@ -251,10 +347,17 @@ func (self *context) request(env map[string]string, args []string) error {
 			env,
 			args,
 		}
-		self.requestCh <- r
-		return nil
+
+		// Nothing reads requests once the ruby process is gone, and it can't be cleaned up
+		// after while we hold the mutex, so don't wait for it forever.
+		select {
+		case self.requestCh <- r:
+			return self.generation, nil
+		case <-self.processDone:
+			return self.generation, errors.New("Ruby process exited")
+		}
 	} else {
-		return errors.New("Already closed")
+		return self.generation, errors.New("Already closed")
 	}
 }
 
@@ -269,6 +372,31 @@ func (self rubyRunner) TestCount() int {
 	return len(self.filters)
 }
 
+func (self rubyRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self rubyRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	keep := make(map[tapjio.TestFilter]bool)
+	for _, filter := range filters {
+		keep[filter] = true
+	}
+
+	depEntries := []runner.TestDependencyEntry{}
+	for _, depEntry := range self.depEntries {
+		if keep[depEntry.Filter] {
+			depEntries = append(depEntries, depEntry)
+		}
+	}
+
+	return rubyRunner{
+		ctx:        self.ctx,
+		file:       self.file,
+		filters:    append([]tapjio.TestFilter{}, filters...),
+		depEntries: depEntries,
+	}
+}
+
 // debitFilter returns a new slice, with the given string removed from the given slice. Returns
 // an error if the given string is not present.
 func debitFilter(filters []tapjio.TestFilter, filter tapjio.TestFilter, kind string, saw []tapjio.TestFilter) ([]tapjio.TestFilter, error) {
@@ -286,67 +414,558 @@ func (self rubyRunner) Dependencies() []runner.TestDependencyEntry {
 	return self.depEntries
 }
 
+// How long a timed out worker has to report on what it was doing before we kill it anyway.
+const timeoutGracePeriod = 5 * time.Second
+
+// The name of the trace event the ruby process emits when a worker it forked dies abnormally.
+const workerExitTraceName = "qa:worker-exit"
+
+// The name of the trace event a worker emits as soon as it connects, with its pid.
+const workerStartTraceName = "qa:worker-start"
+
+// How many times a runner will start over in a new worker after crashes.
+const maxWorkerCrashes = 3
+
 // Run executes the rubyRunner's tests with the given environment variables. Events triggered
 // by the run will be invoked on the given callbacks instance. Returns an error if anything
-// goes wrong before starting the tests or while processing the a test event.
+// goes wrong before starting the tests or while processing the a test event. If quitChan is
+// closed first, the worker running the tests is killed.
+//
+// If a test takes longer than the configured TestTimeout, it is reported as an error and the
+// remaining tests are run in a fresh worker. If the worker takes longer than TestTimeout to load
+// its tests, they are all reported as errors. If all tests take longer than RunnerTimeout, the
+// test in progress is reported as an error and the remaining tests are reported as not run.
+//
+// If the worker (or the ruby process it was forked from) dies, the test in progress is
+// reported as an error and the remaining tests are run in a fresh worker, restarting the ruby
+// process if needed. If the worker grows larger than WorkerMaxRSS, it exits after its current
+// test and the remaining tests are run in a fresh worker.
 // NOTE(adamb) It is not careful about ensuring the test is no longer running in the case of an
 //     error.
-func (self rubyRunner) Run(env map[string]string, seed int, visitor tapjio.Visitor) error {
-	var allowedBeginFilters, allowedFinishFilters []tapjio.TestFilter
-	allowedBeginFilters = append(allowedBeginFilters, self.filters...)
-	sawBeginFilters := []tapjio.TestFilter{}
-	allowedFinishFilters = append(allowedFinishFilters, self.filters...)
-	sawFinishFilters := []tapjio.TestFilter{}
+func (self rubyRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	cfg := self.ctx.config.RunnerConfig
+
+	var deadline time.Time
+	if cfg.RunnerTimeout > 0 {
+		deadline = time.Now().Add(cfg.RunnerTimeout)
+	}
+
+	crashes := 0
+	filters := self.filters
+	for len(filters) > 0 {
+		worker := newWorker()
+		generation, finished, err := self.runWorker(worker, env, seed, filters, quitChan, deadline, visitor)
+
+		contextExit, contextExited := "", false
+		switch worker.stop {
+		case workerRunning:
+			if err == nil {
+				return nil
+			}
+
+			if contextExit, contextExited = self.ctx.exitedSince(generation); contextExited {
+				worker.crash(fmt.Sprintf("Ruby process exited unexpectedly (%s)", contextExit))
+			} else if worker.exitStatus != "" {
+				worker.crash(fmt.Sprintf("Worker exited unexpectedly (%s)", worker.exitStatus))
+			} else {
+				return err
+			}
+		case workerCanceled:
+			return nil
+		case workerRecycled:
+			// The worker exited between tests. Carry on with the rest in a new one.
+			var remaining []tapjio.TestFilter
+			for _, filter := range filters {
+				if !finished[filter] {
+					remaining = append(remaining, filter)
+				}
+			}
+			filters = remaining
+			continue
+		}
+
+		// We gave up on the worker. If it didn't manage to report on the test it was stuck
+		// on, do it for it.
+		if stuck := worker.current; stuck != nil && !finished[stuck.Filter] {
+			finished[stuck.Filter] = true
+			exception := &tapjio.TestException{
+				Class:   tapjio.TimeoutExceptionClass,
+				Message: worker.stopMessage + ". The worker did not respond, so no backtrace is available.",
+			}
+			if worker.stop == workerCrashed {
+				exception = &tapjio.TestException{
+					Class:   tapjio.WorkerCrashExceptionClass,
+					Message: worker.stopMessage,
+				}
+			}
+
+			event := tapjio.TestFinishEvent{
+				Type:      "test",
+				Time:      time.Since(worker.began).Seconds(),
+				Runner:    cfg.Name,
+				Timestamp: stuck.Timestamp,
+				Label:     stuck.Label,
+				Subtype:   stuck.Subtype,
+				Status:    tapjio.Error,
+				Filter:    stuck.Filter,
+				File:      stuck.File,
+				Cases:     stuck.Cases,
+				Exception: exception,
+			}
+			if err := visitor.TestFinish(event); err != nil {
+				return err
+			}
+		}
+
+		var remaining []tapjio.TestFilter
+		for _, filter := range filters {
+			if !finished[filter] {
+				remaining = append(remaining, filter)
+			}
+		}
 
-	bothVisitors := tapjio.MultiVisitor(
-		[]tapjio.Visitor{
-			&tapjio.DecodingCallbacks{
-				OnTestBegin: func(event tapjio.TestBeginEvent) error {
-					var err error
-					allowedBeginFilters, err = debitFilter(allowedBeginFilters, event.Filter, "begin", sawBeginFilters)
-					sawBeginFilters = append(sawBeginFilters, event.Filter)
+		// A worker that timed out loading its tests would only do so again.
+		if worker.stop == workerLoadTimedOut {
+			for _, filter := range remaining {
+				event := tapjio.TestFinishEvent{
+					Type:   "test",
+					Runner: cfg.Name,
+					Label:  filter.String(),
+					Status: tapjio.Error,
+					Filter: filter,
+					Exception: &tapjio.TestException{
+						Class:   tapjio.TimeoutExceptionClass,
+						Message: worker.stopMessage,
+					},
+				}
+				if err := visitor.TestFinish(event); err != nil {
 					return err
-				},
-				OnTestFinish: func(event tapjio.TestFinishEvent) error {
-					var err error
-					allowedFinishFilters, err = debitFilter(allowedFinishFilters, event.Filter, "finish", sawFinishFilters)
-					sawFinishFilters = append(sawFinishFilters, event.Filter)
+				}
+			}
+			return nil
+		}
+
+		giveUp := worker.stop == workerRunnerTimedOut
+		if worker.stop == workerCrashed {
+			var crashErr error
+			crashes++
+			if crashes > maxWorkerCrashes {
+				crashErr = fmt.Errorf("Giving up after %d crashes", crashes)
+			} else if contextExited {
+				crashErr = self.ctx.restart(generation)
+			}
+
+			if crashErr != nil {
+				fmt.Fprintf(os.Stderr, "%s: %v\n", worker.stopMessage, crashErr)
+				giveUp = true
+			}
+		}
+
+		if giveUp {
+			for _, filter := range remaining {
+				if err := visitor.TestFinish(*runner.NewNotRunEvent(filter)); err != nil {
 					return err
-				},
-				OnEnd: func(reason error) error {
-					if reason != nil {
-						return nil
-					}
+				}
+			}
+			return nil
+		}
+
+		// Carry on with the rest of the tests in a new worker.
+		filters = remaining
+	}
+
+	return nil
+}
+
+// runWorker runs the given tests in a newly forked worker, returning the generation of the ruby
+// process asked to fork it and the filters of the tests that finished. The worker is stopped
+// early if quitChan is closed or if the tests take too long.
+func (self rubyRunner) runWorker(
+	worker *worker,
+	env map[string]string,
+	seed int,
+	filters []tapjio.TestFilter,
+	quitChan <-chan struct{},
+	deadline time.Time,
+	visitor tapjio.Visitor) (int, map[tapjio.TestFilter]bool, error) {
 
-					if len(allowedFinishFilters) != 0 {
-						return fmt.Errorf("Runner finished without emitting all expected tests. Never saw: %v. Did see: finish %v, begin %v", allowedFinishFilters, sawFinishFilters, sawBeginFilters)
+	cfg := self.ctx.config.RunnerConfig
+
+	var allowedBeginFilters, allowedFinishFilters []tapjio.TestFilter
+	allowedBeginFilters = append(allowedBeginFilters, filters...)
+	sawBeginFilters := []tapjio.TestFilter{}
+	allowedFinishFilters = append(allowedFinishFilters, filters...)
+	sawFinishFilters := []tapjio.TestFilter{}
+	finished := make(map[tapjio.TestFilter]bool)
+
+	callbacks := &tapjio.DecodingCallbacks{
+		OnSuiteBegin:  visitor.SuiteBegin,
+		OnSuiteFinish: visitor.SuiteFinish,
+		OnAwaitAttach: visitor.AwaitAttach,
+		OnTrace: func(event tapjio.TraceEvent) error {
+			if data := event.Data; data != nil && data.Args != nil {
+				var args struct {
+					Status string `json:"status"`
+					Pid    int    `json:"pid"`
+				}
+				if err := json.Unmarshal(*data.Args, &args); err == nil {
+					switch data.Name {
+					case workerExitTraceName:
+						worker.exited(args.Status)
+					case workerStartTraceName:
+						worker.started(args.Pid, cfg.TestTimeout)
 					}
+				}
+			}
 
-					return nil
-				},
-			},
-			visitor,
-		})
+			return visitor.TraceEvent(event)
+		},
+		OnTestBegin: func(event tapjio.TestBeginEvent) error {
+			var err error
+			allowedBeginFilters, err = debitFilter(allowedBeginFilters, event.Filter, "begin", sawBeginFilters)
+			sawBeginFilters = append(sawBeginFilters, event.Filter)
+			if err != nil {
+				return err
+			}
+
+			worker.begin(event, cfg.TestTimeout)
+			return visitor.TestBegin(event)
+		},
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			var err error
+			allowedFinishFilters, err = debitFilter(allowedFinishFilters, event.Filter, "finish", sawFinishFilters)
+			sawFinishFilters = append(sawFinishFilters, event.Filter)
+			if err != nil {
+				return err
+			}
+
+			worker.finish(event.Filter)
+			finished[event.Filter] = true
 
-	address, errChan, err := self.ctx.subscribeVisitor(bothVisitors)
+			// Only bother replacing a bloated worker if it has more tests to run.
+			if cfg.WorkerMaxRSS > 0 && len(allowedFinishFilters) > 0 {
+				worker.limitRSS(cfg.WorkerMaxRSS)
+			}
+
+			// The worker only knows it was asked to stop, not why.
+			if e := event.Exception; e != nil && e.Class == tapjio.TimeoutExceptionClass {
+				e.Message = worker.stopMessage
+				if event.Runner == "" {
+					event.Runner = cfg.Name
+				}
+			}
+
+			return visitor.TestFinish(event)
+		},
+		OnEnd: func(reason error) error {
+			err := visitor.End(reason)
+			if reason != nil || err != nil {
+				return err
+			}
+
+			if len(allowedFinishFilters) != 0 {
+				return fmt.Errorf("Runner finished without emitting all expected tests. Never saw: %v. Did see: finish %v, begin %v", allowedFinishFilters, sawFinishFilters, sawBeginFilters)
+			}
+
+			return nil
+		},
+	}
+
+	address, errChan, err := self.ctx.subscribeVisitor(callbacks)
 	if err != nil {
-		return err
+		return 0, finished, err
 	}
 
-	filterArgs := make([]string, len(self.filters))
-	for ix, filter := range self.filters {
-		filterArgs[ix] = string(filter)
+	args := []string{
+		"--seed", fmt.Sprintf("%v", seed),
+		"--tapj-sink", address,
+	}
+	for _, filter := range filters {
+		if filter == cfg.RunLast {
+			args = append(args, "--last", string(filter))
+		}
+	}
+	for _, filter := range filters {
+		args = append(args, string(filter))
 	}
 
-	if err = self.ctx.request(
-		env,
-		append([]string{
-			"--seed", fmt.Sprintf("%v", seed),
-			"--tapj-sink", address,
-		}, filterArgs...)); err != nil {
+	generation, err := self.ctx.request(env, args)
+	if err != nil {
 		self.ctx.srv.Cancel(address)
-		return err
+		return generation, finished, err
+	}
+
+	var runnerTimeoutChan <-chan time.Time
+	if !deadline.IsZero() {
+		timer := time.NewTimer(deadline.Sub(time.Now()))
+		defer timer.Stop()
+		runnerTimeoutChan = timer.C
 	}
 
-	return <-errChan
+	for {
+		select {
+		case err = <-errChan:
+			worker.done()
+			return generation, finished, err
+		case <-quitChan:
+			quitChan = nil
+			worker.cancel()
+			// If the worker hasn't connected yet, make sure it never does.
+			self.ctx.srv.Cancel(address)
+		case <-runnerTimeoutChan:
+			runnerTimeoutChan = nil
+			worker.timeOut(workerRunnerTimedOut, fmt.Sprintf("Runner timed out after %v", cfg.RunnerTimeout))
+			self.ctx.srv.Cancel(address)
+		}
+	}
+}
+
+type workerStop int
+
+const (
+	workerRunning workerStop = iota
+	workerCanceled
+	workerTestTimedOut
+	workerLoadTimedOut
+	workerRunnerTimedOut
+	workerCrashed
+	workerRecycled
+)
+
+// worker tracks the forked process running a rubyRunner's tests.
+type worker struct {
+	mutex *sync.Mutex
+
+	pid       int
+	current   *tapjio.TestBeginEvent
+	began     time.Time
+	testTimer *time.Timer
+	killTimer *time.Timer
+
+	// Why we stopped the worker, if we did.
+	stop        workerStop
+	stopMessage string
+
+	// How the worker exited, if it died abnormally.
+	exitStatus string
+
+	// Whether to kill the worker as soon as we learn its pid.
+	killOnPid bool
+	isDone    bool
+}
+
+func newWorker() *worker {
+	return &worker{mutex: &sync.Mutex{}}
+}
+
+// started notes the pid of a worker that just connected. Until its first test begins, it has
+// testTimeout to load its tests.
+func (self *worker) started(pid int, testTimeout time.Duration) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if !self.learnPid(pid) {
+		return
+	}
+
+	if testTimeout > 0 && self.stoppable() && self.current == nil && self.testTimer == nil {
+		self.testTimer = time.AfterFunc(testTimeout, func() {
+			self.timeOut(workerLoadTimedOut, fmt.Sprintf("Timed out after %v loading tests", testTimeout))
+		})
+	}
+}
+
+// learnPid remembers the worker's pid, killing it if we were waiting to. Returns whether the
+// worker is still alive.
+func (self *worker) learnPid(pid int) bool {
+	if pid != 0 {
+		self.pid = pid
+	}
+
+	if self.killOnPid && self.pid != 0 {
+		self.killOnPid = false
+		self.signal(os.Kill)
+		return false
+	}
+
+	return true
+}
+
+func (self *worker) begin(event tapjio.TestBeginEvent, testTimeout time.Duration) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if !self.learnPid(event.Pid) {
+		return
+	}
+
+	self.current = &event
+	self.began = time.Now()
+
+	// The worker is done loading.
+	if self.testTimer != nil {
+		self.testTimer.Stop()
+		self.testTimer = nil
+	}
+
+	if testTimeout > 0 && self.stoppable() {
+		self.testTimer = time.AfterFunc(testTimeout, func() {
+			self.timeOut(workerTestTimedOut, fmt.Sprintf("Test timed out after %v", testTimeout))
+		})
+	}
+}
+
+func (self *worker) finish(filter tapjio.TestFilter) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if self.current != nil && self.current.Filter == filter {
+		self.current = nil
+		if self.testTimer != nil {
+			self.testTimer.Stop()
+			self.testTimer = nil
+		}
+	}
+}
+
+// timeOut asks the worker to report what every thread is doing and exit. If it doesn't do so
+// promptly, it's killed.
+func (self *worker) timeOut(reason workerStop, message string) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if self.isDone || !self.stoppable() {
+		return
+	}
+
+	self.stop = reason
+	self.stopMessage = message
+
+	if self.pid == 0 {
+		self.killOnPid = true
+		return
+	}
+
+	self.signal(syscall.SIGQUIT)
+	self.killTimer = time.AfterFunc(timeoutGracePeriod, func() {
+		m.Lock()
+		defer m.Unlock()
+
+		if !self.isDone {
+			self.signal(os.Kill)
+		}
+	})
+}
+
+// cancel kills the worker. If we don't know its pid yet, it will be killed once we do.
+func (self *worker) cancel() {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if self.stoppable() {
+		self.stop = workerCanceled
+	}
+
+	if self.pid == 0 {
+		self.killOnPid = true
+		return
+	}
+
+	self.signal(os.Kill)
+}
+
+// stoppable is whether the worker is still expected to finish its current test.
+func (self *worker) stoppable() bool {
+	return self.stop == workerRunning || self.stop == workerRecycled
+}
+
+// limitRSS asks the worker to exit after its current test if its resident set size is larger
+// than maxRSS bytes.
+func (self *worker) limitRSS(maxRSS int64) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	if self.isDone || self.stop != workerRunning || self.pid == 0 {
+		return
+	}
+
+	rss, err := readRSS(self.pid)
+	if err != nil || rss <= maxRSS {
+		return
+	}
+
+	self.stop = workerRecycled
+	self.stopMessage = fmt.Sprintf("Worker RSS of %d MB exceeded %d MB", rss>>20, maxRSS>>20)
+	self.signal(syscall.SIGUSR2)
+}
+
+// readRSS returns the resident set size of the given process, in bytes. Only works on systems
+// with /proc.
+func readRSS(pid int) (int64, error) {
+	status, err := ioutil.ReadFile(fmt.Sprintf("/proc/%d/status", pid))
+	if err != nil {
+		return 0, err
+	}
+
+	for _, line := range strings.Split(string(status), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 3 && fields[0] == "VmRSS:" && fields[2] == "kB" {
+			kb, err := strconv.ParseInt(fields[1], 10, 64)
+			if err != nil {
+				return 0, err
+			}
+			return kb * 1024, nil
+		}
+	}
+
+	return 0, errors.New("No VmRSS in status of process " + strconv.Itoa(pid))
+}
+
+// exited notes that the worker died abnormally, as reported by the process that forked it.
+func (self *worker) exited(status string) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	self.exitStatus = status
+}
+
+// crash notes that the worker (or the process it was forked from) died while it was running.
+func (self *worker) crash(message string) {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	self.stop = workerCrashed
+	self.stopMessage = message
+}
+
+// done notes that the worker has exited, so it should no longer be signaled.
+func (self *worker) done() {
+	m := self.mutex
+	m.Lock()
+	defer m.Unlock()
+
+	self.isDone = true
+	for _, timer := range []*time.Timer{self.testTimer, self.killTimer} {
+		if timer != nil {
+			timer.Stop()
+		}
+	}
+}
+
+func (self *worker) signal(sig os.Signal) {
+	if self.pid == 0 {
+		return
+	}
+
+	if process, err := os.FindProcess(self.pid); err == nil {
+		process.Signal(sig)
+	}
 }
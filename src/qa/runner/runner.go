@@ -1,11 +1,13 @@
 package runner
 
 import (
-	"errors"
 	"qa/glob"
 	"qa/tapjio"
 	"sort"
+	"strconv"
+	"strings"
 	"sync"
+	"time"
 )
 
 //go:generate go-bindata -o $GOGENPATH/qa/runner/assets/bindata.go -pkg assets -prefix ../runner-assets/ ../runner-assets/...
@@ -18,9 +20,21 @@ type TestDependencyEntry struct {
 }
 
 type TestRunner interface {
-	Run(env map[string]string, seed int, visitor tapjio.Visitor) error
+	// Run runs tests with the given environment variables, visiting the events they emit. If
+	// quitChan is closed before all tests are done, Run should stop them as soon as possible.
+	// It needn't report the tests it didn't get to, since RunAll reports them as not run.
+	Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error
 	Dependencies() []TestDependencyEntry
+	Filters() []tapjio.TestFilter
 	TestCount() int
+
+	// Subset returns a runner for just the given tests, which must be among Filters().
+	Subset(filters []tapjio.TestFilter) TestRunner
+}
+
+// DurationEstimator predicts how long a test will take, e.g. based on archived runs.
+type DurationEstimator interface {
+	EstimateDuration(filter tapjio.TestFilter) (float64, bool)
 }
 
 type By func(r1, r2 *TestRunner) bool
@@ -47,6 +61,180 @@ func (s *testRunnerSorter) Less(i, j int) bool {
 	return s.by(&s.runners[i], &s.runners[j])
 }
 
+type runnersByDuration struct {
+	runners   []TestRunner
+	durations []float64
+}
+
+func (s *runnersByDuration) Len() int {
+	return len(s.runners)
+}
+
+func (s *runnersByDuration) Swap(i, j int) {
+	s.runners[i], s.runners[j] = s.runners[j], s.runners[i]
+	s.durations[i], s.durations[j] = s.durations[j], s.durations[i]
+}
+
+func (s *runnersByDuration) Less(i, j int) bool {
+	return s.durations[j] < s.durations[i]
+}
+
+// EstimateDurations returns the expected duration of each of the given runners. Tests that
+// the estimator knows nothing about are assumed to take as long as the average test it does
+// know about. Returns nil if the estimator knows nothing about any of the given tests.
+func EstimateDurations(estimator DurationEstimator, runners []TestRunner) []float64 {
+	if estimator == nil {
+		return nil
+	}
+
+	durations := make([]float64, len(runners))
+	unknowns := make([]int, len(runners))
+	known := 0
+	knownDuration := 0.0
+	for ix, testRunner := range runners {
+		for _, filter := range testRunner.Filters() {
+			if duration, ok := estimator.EstimateDuration(filter); ok {
+				durations[ix] += duration
+				knownDuration += duration
+				known++
+			} else {
+				unknowns[ix]++
+			}
+		}
+	}
+
+	if known == 0 {
+		return nil
+	}
+
+	averageDuration := knownDuration / float64(known)
+	for ix, unknown := range unknowns {
+		durations[ix] += float64(unknown) * averageDuration
+	}
+
+	return durations
+}
+
+// EstimateMakespan returns how long it would take numWorkers workers to get through runners
+// with the given durations, if each runner is handed in order to whichever worker is idle first.
+func EstimateMakespan(durations []float64, numWorkers int) float64 {
+	if numWorkers < 1 {
+		numWorkers = 1
+	}
+
+	workers := make([]float64, numWorkers)
+	for _, duration := range durations {
+		idlest := 0
+		for ix, busyUntil := range workers {
+			if busyUntil < workers[idlest] {
+				idlest = ix
+			}
+		}
+		workers[idlest] += duration
+	}
+
+	makespan := 0.0
+	for _, busyUntil := range workers {
+		if busyUntil > makespan {
+			makespan = busyUntil
+		}
+	}
+
+	return makespan
+}
+
+// Schedule orders the given runners so that numWorkers workers are unlikely to be idle near
+// the end of a run. If the estimator can predict how long runners will take, those expected
+// to take longest go first and the expected makespan (in seconds) is returned. Otherwise
+// runners with the most tests go first and the returned makespan is 0.
+func Schedule(runners []TestRunner, estimator DurationEstimator, numWorkers int) float64 {
+	durations := EstimateDurations(estimator, runners)
+	if durations == nil {
+		// Sort runners by test count. This heuristic helps our workers avoid being idle
+		// near the end of the run by running testRunners with the most tests first, avoiding
+		// scenarios where the last testRunner we run has many tests, causing the entire test
+		// run to drag on needlessly while other workers are idle.
+		By(func(r1, r2 *TestRunner) bool { return (*r2).TestCount() < (*r1).TestCount() }).Sort(runners)
+		return 0
+	}
+
+	sort.Sort(&runnersByDuration{runners: runners, durations: durations})
+	return EstimateMakespan(durations, numWorkers)
+}
+
+// Shard returns the runners that belong to the given shard when runners are split count ways,
+// where shard is 1-based. The split is deterministic, so that every shard given the same
+// runners agrees on which shard each runner belongs to. Runners are balanced by their estimated
+// duration if the estimator knows about them, and by their test count otherwise, so every shard
+// must be given the same estimator (or none).
+func Shard(runners []TestRunner, estimator DurationEstimator, shard, count int) []TestRunner {
+	if count <= 1 {
+		return runners
+	}
+
+	// Order by weight (heaviest first) so the greedy partition below balances well, falling
+	// back to each runner's first test to break ties the same way on every shard.
+	ordered := make([]TestRunner, len(runners))
+	copy(ordered, runners)
+	weights := EstimateDurations(estimator, ordered)
+	if weights == nil {
+		weights = make([]float64, len(ordered))
+		for ix, testRunner := range ordered {
+			weights[ix] = float64(testRunner.TestCount())
+		}
+	}
+	sort.Sort(&runnersByWeight{runners: ordered, weights: weights})
+
+	loads := make([]float64, count)
+	var assigned []TestRunner
+	for ix, testRunner := range ordered {
+		lightest := 0
+		for shardIx, load := range loads {
+			if load < loads[lightest] {
+				lightest = shardIx
+			}
+		}
+		loads[lightest] += weights[ix]
+
+		if lightest == shard-1 {
+			assigned = append(assigned, testRunner)
+		}
+	}
+
+	return assigned
+}
+
+type runnersByWeight struct {
+	runners []TestRunner
+	weights []float64
+}
+
+func (s *runnersByWeight) Len() int {
+	return len(s.runners)
+}
+
+func (s *runnersByWeight) Swap(i, j int) {
+	s.runners[i], s.runners[j] = s.runners[j], s.runners[i]
+	s.weights[i], s.weights[j] = s.weights[j], s.weights[i]
+}
+
+func (s *runnersByWeight) Less(i, j int) bool {
+	if s.weights[i] != s.weights[j] {
+		return s.weights[j] < s.weights[i]
+	}
+
+	return firstFilter(s.runners[i]) < firstFilter(s.runners[j])
+}
+
+func firstFilter(testRunner TestRunner) tapjio.TestFilter {
+	filters := testRunner.Filters()
+	if len(filters) == 0 {
+		return ""
+	}
+
+	return filters[0]
+}
+
 type FileGlob struct {
 	dir      string
 	patterns []string
@@ -102,6 +290,26 @@ type Config struct {
 	SquashPolicy      SquashPolicy
 	TraceProbes       []string
 	Filters           []tapjio.TestFilter
+
+	// If given, the test to run after every other test in the same worker, rather than
+	// wherever the seed would order it. Only Ruby runners support this.
+	RunLast tapjio.TestFilter
+
+	// If positive, how long a single test or an entire TestRunner may run before it's stopped.
+	TestTimeout   time.Duration
+	RunnerTimeout time.Duration
+
+	// If positive, the resident set size (in bytes) past which a worker is replaced by a fresh
+	// one once its current test is done.
+	WorkerMaxRSS int64
+
+	// If given, the command (and any leading arguments) of an external runner to run tests
+	// with, instead of a built-in runner. See qa/runner/external.
+	Command []string
+
+	// For tap runners, the command that runs a file and writes TAP to stdout. {file} is
+	// replaced by the file's path, which is added to the end if it isn't mentioned.
+	TapCommand string
 }
 
 func (f *Config) Files() ([]string, error) {
@@ -121,25 +329,170 @@ type eventUnion struct {
 	error  error
 }
 
+func isClosed(ch <-chan struct{}) bool {
+	select {
+	case <-ch:
+		return true
+	default:
+		return false
+	}
+}
+
+// NewNotRunEvent returns an event reporting that the test with the given filter never ran.
+func NewNotRunEvent(filter tapjio.TestFilter) *tapjio.TestFinishEvent {
+	return &tapjio.TestFinishEvent{
+		Type:   "test",
+		Label:  filter.String(),
+		Filter: filter,
+		Status: tapjio.NotRun,
+	}
+}
+
+// runAttempts runs the given runner on the given worker, re-running any failing or erroring
+// tests in a fresh worker process up to retries more times. Events are sent to eventChan.
+func runAttempts(
+	testRunner TestRunner,
+	worker int,
+	env map[string]string,
+	seed int,
+	retries int,
+	budgets *Budgets,
+	quitChan chan struct{},
+	eventChan chan eventUnion) {
+
+	workerId := strconv.Itoa(worker)
+	failedBefore := make(map[tapjio.TestFilter]bool)
+	for attempt := 1; ; attempt++ {
+		if isClosed(quitChan) {
+			for _, filter := range testRunner.Filters() {
+				event := NewNotRunEvent(filter)
+				if attempt > 1 {
+					event.Attempt = attempt
+				}
+				eventChan <- eventUnion{finish: event}
+			}
+			return
+		}
+
+		finished := make(map[tapjio.TestFilter]bool)
+		runnerFilters := make(map[tapjio.TestFilter]bool)
+		for _, filter := range testRunner.Filters() {
+			runnerFilters[filter] = true
+		}
+		var retry []tapjio.TestFilter
+		err := testRunner.Run(
+			env,
+			seed,
+			quitChan,
+			&tapjio.DecodingCallbacks{
+				OnTestBegin: func(test tapjio.TestBeginEvent) error {
+					// Runners that hand tests to workers of their own say which one.
+					if test.Worker == "" {
+						test.Worker = workerId
+					}
+					if attempt > 1 {
+						test.Attempt = attempt
+					}
+					eventChan <- eventUnion{begin: &test}
+					return nil
+				},
+				OnTestFinish: func(test tapjio.TestFinishEvent) error {
+					finished[test.Filter] = true
+					// Runners that only know their files until they run them, like tap's, report
+					// each file's tests as file#name. Once one is reported, so is the file.
+					if file := tapjio.TestFilter(strings.SplitN(test.Filter.String(), "#", 2)[0]); runnerFilters[file] {
+						finished[file] = true
+					}
+					if test.Worker == "" {
+						test.Worker = workerId
+					}
+					if attempt > 1 {
+						test.Attempt = attempt
+					}
+
+					budgets.CheckTest(&test)
+
+					// Going over budget isn't flakiness, so a quicker retry shouldn't excuse it.
+					overBudget := test.Budget != nil && !test.Budget.Warning
+					failed := test.Status == tapjio.Fail || test.Status == tapjio.Error
+					if failed && !overBudget && attempt <= retries {
+						test.Attempt = attempt
+						test.Retried = true
+						retry = append(retry, test.Filter)
+					} else if test.Status == tapjio.Pass && failedBefore[test.Filter] {
+						test.Flaky = true
+					}
+
+					eventChan <- eventUnion{finish: &test}
+					return nil
+				},
+				OnAwaitAttach: func(event tapjio.AwaitAttachEvent) error {
+					eventChan <- eventUnion{await: &event}
+					return nil
+				},
+				OnTrace: func(trace tapjio.TraceEvent) error {
+					eventChan <- eventUnion{trace: &trace}
+					return nil
+				},
+			})
+
+		// If we quit while this runner was busy, whatever it didn't get to was
+		// canceled. Any error it returned is a consequence of that.
+		if isClosed(quitChan) {
+			for _, filter := range testRunner.Filters() {
+				if !finished[filter] {
+					event := NewNotRunEvent(filter)
+					if attempt > 1 {
+						event.Attempt = attempt
+					}
+					eventChan <- eventUnion{finish: event}
+				}
+			}
+
+			for _, filter := range retry {
+				event := NewNotRunEvent(filter)
+				event.Attempt = attempt + 1
+				eventChan <- eventUnion{finish: event}
+			}
+			return
+		}
+
+		if err != nil {
+			eventChan <- eventUnion{error: err}
+		}
+
+		if len(retry) == 0 {
+			return
+		}
+
+		for _, filter := range retry {
+			failedBefore[filter] = true
+		}
+		testRunner = testRunner.Subset(retry)
+	}
+}
+
+// RunAll runs the given runners, in order, spread across one worker per workerEnv. Tests that
+// fail or error are retried up to retries more times. If failFast is positive, no new runners
+// are started (and those already running are canceled) once failFast tests have failed or
+// errored. Tests that are never run as a result are reported with a status of tapjio.NotRun.
+// Passing tests that go over their budget, if budgets is given, are reported as failing.
 func RunAll(
 	visitor tapjio.Visitor,
 	workerEnvs []map[string]string,
 	tally *tapjio.ResultTally,
 	seed int,
+	failFast int,
+	retries int,
+	budgets *Budgets,
 	runners []TestRunner) (err error) {
 
 	numWorkers := len(workerEnvs)
 
 	var testRunnerChan = make(chan TestRunner, numWorkers)
 
-	// Enqueue each testRunner on testRunnerChan
+	// Enqueue each testRunner on testRunnerChan, in the order given. See Schedule.
 	go func() {
-		// Sort runners by test count. This heuristic helps our workers avoid being idle
-		// near the end of the run by running testRunners with the most tests first, avoiding
-		// scenarios where the last testRunner we run has many tests, causing the entire test
-		// run to drag on needlessly while other workers are idle.
-		By(func(r1, r2 *TestRunner) bool { return (*r2).TestCount() < (*r1).TestCount() }).Sort(runners)
-
 		for _, testRunner := range runners {
 			testRunnerChan <- testRunner
 		}
@@ -152,45 +505,13 @@ func RunAll(
 	var awaitJobs sync.WaitGroup
 	awaitJobs.Add(numWorkers)
 
-	for _, workerEnv := range workerEnvs {
+	for ix, workerEnv := range workerEnvs {
+		worker := ix
 		env := workerEnv
 		go func() {
 			defer awaitJobs.Done()
 			for testRunner := range testRunnerChan {
-				select {
-				case <-quitChan:
-					for i := testRunner.TestCount(); i > 0; i-- {
-						eventChan <- eventUnion{error: errors.New("already aborted")}
-					}
-					continue
-				default:
-				}
-
-				err := testRunner.Run(
-					env,
-					seed,
-					&tapjio.DecodingCallbacks{
-						OnTestBegin: func(test tapjio.TestBeginEvent) error {
-							eventChan <- eventUnion{begin: &test}
-							return nil
-						},
-						OnTestFinish: func(test tapjio.TestFinishEvent) error {
-							eventChan <- eventUnion{finish: &test}
-							return nil
-						},
-						OnAwaitAttach: func(event tapjio.AwaitAttachEvent) error {
-							eventChan <- eventUnion{await: &event}
-							return nil
-						},
-						OnTrace: func(trace tapjio.TraceEvent) error {
-							eventChan <- eventUnion{trace: &trace}
-							return nil
-						},
-					})
-
-				if err != nil {
-					eventChan <- eventUnion{error: err}
-				}
+				runAttempts(testRunner, worker, env, seed, retries, budgets, quitChan, eventChan)
 			}
 		}()
 	}
@@ -240,12 +561,16 @@ func RunAll(
 			}
 		}
 
-		tally.Increment(test.Status)
+		tally.IncrementFor(*test)
 
 		err = visitor.TestFinish(*test)
 		if err != nil {
 			return
 		}
+
+		if failFast > 0 && tally.Fail+tally.Error >= failFast && !isClosed(quitChan) {
+			close(quitChan)
+		}
 	}
 
 	return
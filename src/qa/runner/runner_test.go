@@ -0,0 +1,269 @@
+package runner
+
+import (
+	"qa/tapjio"
+	"testing"
+	"time"
+)
+
+// fakeRunner passes tests, unless told otherwise. A test given more than one status takes the
+// next one each time it's run.
+type fakeRunner struct {
+	statuses map[tapjio.TestFilter][]tapjio.Status
+	filters  []tapjio.TestFilter
+}
+
+func newFakeRunner(statuses map[tapjio.TestFilter][]tapjio.Status, filters ...tapjio.TestFilter) fakeRunner {
+	return fakeRunner{statuses: statuses, filters: filters}
+}
+
+func (self fakeRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	for _, filter := range self.filters {
+		select {
+		case <-quitChan:
+			return nil
+		default:
+		}
+
+		status := tapjio.Pass
+		if statuses := self.statuses[filter]; len(statuses) > 0 {
+			status = statuses[0]
+			if len(statuses) > 1 {
+				self.statuses[filter] = statuses[1:]
+			}
+		}
+
+		err := visitor.TestFinish(tapjio.TestFinishEvent{
+			Type:   "test",
+			Label:  filter.String(),
+			Filter: filter,
+			Status: status,
+		})
+		if err != nil {
+			return err
+		}
+
+		// Give fail-fast a chance to cancel us before we go on.
+		if status == tapjio.Fail {
+			select {
+			case <-quitChan:
+			case <-time.After(time.Second):
+			}
+		}
+	}
+
+	return nil
+}
+
+func (self fakeRunner) Dependencies() []TestDependencyEntry {
+	return nil
+}
+
+func (self fakeRunner) Filters() []tapjio.TestFilter {
+	return self.filters
+}
+
+func (self fakeRunner) TestCount() int {
+	return len(self.filters)
+}
+
+func (self fakeRunner) Subset(filters []tapjio.TestFilter) TestRunner {
+	return fakeRunner{statuses: self.statuses, filters: filters}
+}
+
+func TestRunAllFailFast(t *testing.T) {
+	statuses := map[tapjio.TestFilter][]tapjio.Status{"a2": {tapjio.Fail}}
+	runners := []TestRunner{
+		newFakeRunner(statuses, "a1", "a2", "a3"),
+		newFakeRunner(statuses, "b1", "b2"),
+	}
+
+	var seen []tapjio.TestFinishEvent
+	tally := &tapjio.ResultTally{}
+	err := RunAll(
+		&tapjio.DecodingCallbacks{
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				seen = append(seen, event)
+				return nil
+			},
+		},
+		[]map[string]string{{}},
+		tally,
+		0,
+		1,
+		0,
+		nil,
+		runners)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(seen) != 5 {
+		t.Fatalf("Expected every test to be reported, got %v", seen)
+	}
+
+	expected := tapjio.ResultTally{Total: 5, Pass: 1, Fail: 1, NotRun: 3}
+	if *tally != expected {
+		t.Fatalf("Expected tally %#v, got %#v", expected, *tally)
+	}
+}
+
+// fileRunner is a fakeRunner whose filters are files, like the tap runner's, while the tests it
+// reports are file#name.
+type fileRunner struct {
+	fakeRunner
+	files []tapjio.TestFilter
+}
+
+func (self fileRunner) Filters() []tapjio.TestFilter {
+	return self.files
+}
+
+func TestRunAllFailFastFileFilters(t *testing.T) {
+	statuses := map[tapjio.TestFilter][]tapjio.Status{"a#2": {tapjio.Fail}}
+	runners := []TestRunner{
+		fileRunner{newFakeRunner(statuses, "a#1", "a#2"), []tapjio.TestFilter{"a", "b"}},
+	}
+
+	var notRun []tapjio.TestFilter
+	tally := &tapjio.ResultTally{}
+	err := RunAll(
+		&tapjio.DecodingCallbacks{
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				if event.Status == tapjio.NotRun {
+					notRun = append(notRun, event.Filter)
+				}
+				return nil
+			},
+		},
+		[]map[string]string{{}},
+		tally,
+		0,
+		1,
+		0,
+		nil,
+		runners)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(notRun) != 1 || notRun[0] != "b" {
+		t.Fatalf("Expected only the file that didn't run to be reported as not run, got %v", notRun)
+	}
+}
+
+func TestRunAllRetries(t *testing.T) {
+	statuses := map[tapjio.TestFilter][]tapjio.Status{
+		"flaky":  {tapjio.Fail, tapjio.Pass},
+		"broken": {tapjio.Error},
+	}
+	runners := []TestRunner{newFakeRunner(statuses, "flaky", "broken", "fine")}
+
+	attempts := make(map[tapjio.TestFilter][]tapjio.TestFinishEvent)
+	tally := &tapjio.ResultTally{}
+	err := RunAll(
+		&tapjio.DecodingCallbacks{
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				attempts[event.Filter] = append(attempts[event.Filter], event)
+				return nil
+			},
+		},
+		[]map[string]string{{}},
+		tally,
+		0,
+		0,
+		2,
+		nil,
+		runners)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := tapjio.ResultTally{Total: 3, Pass: 1, Error: 1, Flaky: 1}
+	if *tally != expected {
+		t.Fatalf("Expected tally %#v, got %#v", expected, *tally)
+	}
+
+	flaky := attempts["flaky"]
+	if len(flaky) != 2 || !flaky[0].Retried || flaky[0].Attempt != 1 || !flaky[1].Flaky || flaky[1].Attempt != 2 {
+		t.Fatalf("Unexpected attempts for flaky test: %#v", flaky)
+	}
+
+	if len(attempts["broken"]) != 3 || len(attempts["fine"]) != 1 {
+		t.Fatalf("Unexpected attempts: %#v", attempts)
+	}
+}
+
+func TestScheduleByDuration(t *testing.T) {
+	estimates := fakeEstimator{"a": 1, "b": 5, "c": 2, "d": 1}
+	runners := []TestRunner{
+		newFakeRunner(nil, "a"),
+		newFakeRunner(nil, "b"),
+		newFakeRunner(nil, "c", "d"),
+	}
+
+	makespan := Schedule(runners, estimates, 2)
+	if makespan != 5 {
+		t.Fatalf("Expected makespan of 5, got %v", makespan)
+	}
+
+	first := runners[0].Filters()[0]
+	if first != "b" {
+		t.Fatalf("Expected slowest runner first, got %v", first)
+	}
+}
+
+func TestShard(t *testing.T) {
+	newRunners := func() []TestRunner {
+		return []TestRunner{
+			newFakeRunner(nil, "a1", "a2", "a3", "a4"),
+			newFakeRunner(nil, "b1"),
+			newFakeRunner(nil, "c1", "c2"),
+			newFakeRunner(nil, "d1", "d2"),
+			newFakeRunner(nil, "e1", "e2", "e3"),
+		}
+	}
+
+	for _, estimator := range []DurationEstimator{nil, fakeEstimator{"b1": 10}} {
+		seen := make(map[tapjio.TestFilter]int)
+		var shards [][]TestRunner
+		for shard := 1; shard <= 3; shard++ {
+			runners := newRunners()
+			// Input order shouldn't matter.
+			runners[0], runners[4] = runners[4], runners[0]
+
+			shardRunners := Shard(runners, estimator, shard, 3)
+			shards = append(shards, shardRunners)
+			for _, testRunner := range shardRunners {
+				seen[testRunner.Filters()[0]]++
+			}
+		}
+
+		if len(seen) != 5 {
+			t.Fatalf("Expected every runner to be in some shard, got %v", seen)
+		}
+
+		for filter, n := range seen {
+			if n != 1 {
+				t.Fatalf("Expected %v to be in exactly one shard, was in %d", filter, n)
+			}
+		}
+
+		for ix, shardRunners := range shards {
+			if len(shardRunners) == 0 {
+				t.Fatalf("Expected shard %d to have runners (estimator %v)", ix+1, estimator)
+			}
+		}
+	}
+
+	if len(Shard(newRunners(), nil, 1, 1)) != 5 {
+		t.Fatalf("Expected a single shard to have every runner")
+	}
+}
+
+type fakeEstimator map[tapjio.TestFilter]float64
+
+func (self fakeEstimator) EstimateDuration(filter tapjio.TestFilter) (float64, bool) {
+	d, ok := self[filter]
+	return d, ok
+}
@@ -20,6 +20,11 @@ type registerCallbackEntry struct {
 	errChan chan error
 }
 
+type registerHandlerEntry struct {
+	token   string
+	handler func(conn net.Conn, reader *bufio.Reader)
+}
+
 type registerChannelEntry struct {
 	token   string
 	ch      chan interface{}
@@ -35,12 +40,20 @@ type Server struct {
 	listener      net.Listener
 
 	cancelChan           chan string
+	disconnectChan       chan string
 	registerCallbackChan chan registerCallbackEntry
 	visitorEntries       map[string]registerCallbackEntry
 
+	// Connections events are being decoded from, by token.
+	decodingConns      map[string]net.Conn
+	decodingConnsMutex *sync.Mutex
+
 	registerChannelChan chan registerChannelEntry
 	exposedChannels     map[string]registerChannelEntry
 
+	registerHandlerChan chan registerHandlerEntry
+	handlers            map[string]registerHandlerEntry
+
 	isRunningMutex *sync.Mutex
 	isRunning      bool
 }
@@ -82,10 +95,15 @@ func Listen(netProto, listenAddress string) (*Server, error) {
 		isRunningMutex:       &sync.Mutex{},
 		listener:             listener,
 		cancelChan:           make(chan string),
+		disconnectChan:       make(chan string),
+		decodingConns:        make(map[string]net.Conn),
+		decodingConnsMutex:   &sync.Mutex{},
 		registerCallbackChan: make(chan registerCallbackEntry),
 		visitorEntries:       make(map[string]registerCallbackEntry),
 		exposedChannels:      make(map[string]registerChannelEntry),
 		registerChannelChan:  make(chan registerChannelEntry),
+		handlers:             make(map[string]registerHandlerEntry),
+		registerHandlerChan:  make(chan registerHandlerEntry),
 	}
 	go srv.run()
 
@@ -133,6 +151,7 @@ func (s *Server) run() error {
 		isRunningMutex.Lock()
 		close(s.registerCallbackChan)
 		close(s.registerChannelChan)
+		close(s.registerHandlerChan)
 		s.isRunning = false
 		isRunningMutex.Unlock()
 
@@ -150,6 +169,11 @@ func (s *Server) run() error {
 
 		reason := errors.New("No longer running")
 
+		go func() {
+			for _ = range s.registerHandlerChan {
+			}
+		}()
+
 		go func() {
 			for entry := range s.registerChannelChan {
 				entry.errChan <- reason
@@ -229,30 +253,21 @@ func (s *Server) run() error {
 			fmt.Fprintf(os.Stderr, "Fatal error in server: %v\n", err)
 			s.listener.Close()
 			return err
-		case address, k := <-s.cancelChan:
-			if !k {
-				fmt.Fprintf(os.Stderr, "s.cancelChan unexpectedly closed: %#v\n", s.cancelChan)
-			}
-			visitorEntry, ok := s.visitorEntries[address]
+		case token := <-s.disconnectChan:
+			s.decodingConnsMutex.Lock()
+			conn, ok := s.decodingConns[token]
+			s.decodingConnsMutex.Unlock()
 			if ok {
-				delete(s.visitorEntries, address)
-				reason := errors.New("Canceled")
-				err := visitorEntry.visitor.End(reason)
-				if err != nil {
-					visitorEntry.errChan <- err
-				} else {
-					visitorEntry.errChan <- reason
-				}
-				close(visitorEntry.errChan)
+				conn.Close()
+				break
 			}
 
-			exposedEntry, ok := s.exposedChannels[address]
-			if ok {
-				reason := errors.New("Canceled")
-				delete(s.exposedChannels, address)
-				exposedEntry.errChan <- reason
-				close(exposedEntry.errChan)
+			s.cancel(token)
+		case address, k := <-s.cancelChan:
+			if !k {
+				fmt.Fprintf(os.Stderr, "s.cancelChan unexpectedly closed: %#v\n", s.cancelChan)
 			}
+			s.cancel(address)
 		case entry, k := <-s.registerCallbackChan:
 			if !k {
 				fmt.Fprintf(os.Stderr, "s.registerCallbackChan unexpectedly closed: %#v\n", s.registerCallbackChan)
@@ -263,6 +278,11 @@ func (s *Server) run() error {
 				fmt.Fprintf(os.Stderr, "s.registerChannelChan unexpectedly closed: %#v\n", s.registerChannelChan)
 			}
 			s.exposedChannels[entry.token] = entry
+		case entry, k := <-s.registerHandlerChan:
+			if !k {
+				fmt.Fprintf(os.Stderr, "s.registerHandlerChan unexpectedly closed: %#v\n", s.registerHandlerChan)
+			}
+			s.handlers[entry.token] = entry
 		case conn, ok := <-acceptConnChan:
 			if !ok {
 				return nil
@@ -293,8 +313,17 @@ func (s *Server) run() error {
 			if ok {
 				delete(s.visitorEntries, token)
 
+				s.decodingConnsMutex.Lock()
+				s.decodingConns[token] = accept.conn
+				s.decodingConnsMutex.Unlock()
+
 				go func(decoder *json.Decoder, closer io.Closer, entry registerCallbackEntry) {
 					defer close(entry.errChan)
+					defer func() {
+						s.decodingConnsMutex.Lock()
+						delete(s.decodingConns, entry.token)
+						s.decodingConnsMutex.Unlock()
+					}()
 					defer closer.Close()
 					err := tapjio.Decode(decoder, entry.visitor)
 					if err != nil {
@@ -320,6 +349,16 @@ func (s *Server) run() error {
 				}(json.NewEncoder(accept.conn), accept.conn, exposedEntry)
 				break
 			}
+
+			// Handler tokens may be used any number of times.
+			handlerEntry, ok := s.handlers[token]
+			if ok {
+				go handlerEntry.handler(accept.conn, accept.reader)
+				break
+			}
+
+			// Token was canceled (or never existed), so no one is listening.
+			accept.conn.Close()
 		}
 	}
 
@@ -347,6 +386,44 @@ func (s *Server) Cancel(address string) {
 	}
 }
 
+// Disconnect is like Cancel, but if something already connected to the given address from
+// Decode, it closes the connection, so decoding ends even if the other end never does.
+func (s *Server) Disconnect(address string) {
+	split := strings.SplitN(address, "@", 2)
+	token := split[0]
+
+	isRunningMutex := s.isRunningMutex
+	isRunningMutex.Lock()
+	defer isRunningMutex.Unlock()
+	if s.isRunning {
+		s.disconnectChan <- token
+	}
+}
+
+// cancel ends whatever is waiting for a connection with the given token. Only called from run.
+func (s *Server) cancel(token string) {
+	visitorEntry, ok := s.visitorEntries[token]
+	if ok {
+		delete(s.visitorEntries, token)
+		reason := errors.New("Canceled")
+		err := visitorEntry.visitor.End(reason)
+		if err != nil {
+			visitorEntry.errChan <- err
+		} else {
+			visitorEntry.errChan <- reason
+		}
+		close(visitorEntry.errChan)
+	}
+
+	exposedEntry, ok := s.exposedChannels[token]
+	if ok {
+		reason := errors.New("Canceled")
+		delete(s.exposedChannels, token)
+		exposedEntry.errChan <- reason
+		close(exposedEntry.errChan)
+	}
+}
+
 // Decode returns a server address that can be used by a test runner to
 // stream tapj results.
 func (s *Server) Decode(callbacks tapjio.Visitor) (string, chan error, error) {
@@ -388,3 +465,23 @@ func (s *Server) ExposeChannel() (string, chan interface{}, chan error, error) {
 		return "", nil, nil, fmt.Errorf("Server is not running; can't expose a channel")
 	}
 }
+
+// Serve passes each connection that starts with the given token to handler, along with a
+// reader for whatever follows the token. Unlike the tokens in addresses from Decode and
+// ExposeChannel, the token may be used any number of times. The handler owns the connection.
+func (s *Server) Serve(token string, handler func(conn net.Conn, reader *bufio.Reader)) error {
+	isRunningMutex := s.isRunningMutex
+	isRunningMutex.Lock()
+	defer isRunningMutex.Unlock()
+	if s.isRunning {
+		s.registerHandlerChan <- registerHandlerEntry{token, handler}
+		return nil
+	} else {
+		return fmt.Errorf("Server is not running; can't serve %s", token)
+	}
+}
+
+// Address returns the address the server is listening on.
+func (s *Server) Address() string {
+	return s.listener.Addr().String()
+}
@@ -0,0 +1,320 @@
+package tap
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"qa/runner"
+	"qa/tapjio"
+)
+
+// The tap runner runs each test file with a command that writes TAP to stdout, like
+// 'bats {file}' or 'node {file}'. Since a file's tests aren't known until it runs, each file
+// is treated as a single test when scheduling, retrying, and so on. The tests it reports are
+// still reported one by one, with filters like file#description.
+
+type context struct {
+	config runner.Config
+}
+
+// StartContext returns a context that runs each of the given runner config's files with its
+// TapCommand.
+func StartContext(runnerConfig runner.Config) (runner.Context, error) {
+	if strings.TrimSpace(runnerConfig.TapCommand) == "" {
+		return nil, errors.New("No TAP command given, expected e.g. 'bats {file}':test/*.bats")
+	}
+
+	return &context{config: runnerConfig}, nil
+}
+
+func (self *context) Close() error {
+	return nil
+}
+
+// fileFilter returns the filter for all of a file's tests.
+func fileFilter(file string) tapjio.TestFilter {
+	return tapjio.TestFilter(file)
+}
+
+// fileOf returns the file that the test with the given filter is in.
+func fileOf(filter tapjio.TestFilter) string {
+	return strings.SplitN(filter.String(), "#", 2)[0]
+}
+
+func (self *context) EnumerateRunners(seed int) (traceEvents []tapjio.TraceEvent, testRunners []runner.TestRunner, err error) {
+	cfg := self.config
+	files, err := cfg.Files()
+	if err != nil {
+		return
+	}
+
+	var only map[tapjio.TestFilter]bool
+	if len(cfg.Filters) > 0 {
+		only = make(map[tapjio.TestFilter]bool)
+		for _, filter := range cfg.Filters {
+			only[filter] = true
+		}
+	}
+
+	var currentRunner *tapRunner
+	for _, file := range files {
+		if only != nil && !only[fileFilter(file)] && !hasFilterIn(only, file) {
+			continue
+		}
+
+		if currentRunner == nil || cfg.SquashPolicy != runner.SquashAll {
+			if currentRunner != nil {
+				testRunners = append(testRunners, *currentRunner)
+			}
+			currentRunner = &tapRunner{ctx: self, only: only}
+		}
+		currentRunner.files = append(currentRunner.files, file)
+	}
+	if currentRunner != nil {
+		testRunners = append(testRunners, *currentRunner)
+	}
+
+	return
+}
+
+func hasFilterIn(filters map[tapjio.TestFilter]bool, file string) bool {
+	for filter := range filters {
+		if fileOf(filter) == file {
+			return true
+		}
+	}
+
+	return false
+}
+
+type tapRunner struct {
+	ctx   *context
+	files []string
+
+	// If not nil, only these tests (or all the tests in these files) are reported.
+	only map[tapjio.TestFilter]bool
+}
+
+func (self tapRunner) TestCount() int {
+	return len(self.files)
+}
+
+func (self tapRunner) Filters() []tapjio.TestFilter {
+	var filters []tapjio.TestFilter
+	for _, file := range self.files {
+		filters = append(filters, fileFilter(file))
+	}
+
+	return filters
+}
+
+func (self tapRunner) Dependencies() []runner.TestDependencyEntry {
+	return nil
+}
+
+// Subset returns a runner for the files of the given tests, which reports only those tests.
+func (self tapRunner) Subset(filters []tapjio.TestFilter) runner.TestRunner {
+	only := make(map[tapjio.TestFilter]bool)
+	var files []string
+	for _, filter := range filters {
+		only[filter] = true
+		files = appendUnique(files, fileOf(filter))
+	}
+
+	return tapRunner{ctx: self.ctx, files: files, only: only}
+}
+
+func appendUnique(values []string, value string) []string {
+	for _, existing := range values {
+		if existing == value {
+			return values
+		}
+	}
+
+	return append(values, value)
+}
+
+func (self tapRunner) reports(file string, filter tapjio.TestFilter) bool {
+	return self.only == nil || self.only[fileFilter(file)] || self.only[filter]
+}
+
+func (self tapRunner) Run(env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	for _, file := range self.files {
+		select {
+		case <-quitChan:
+			return nil
+		default:
+		}
+
+		err := self.runFile(file, env, seed, quitChan, visitor)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// command returns the command that runs the given file.
+func (self tapRunner) command(file string) *exec.Cmd {
+	cfg := self.ctx.config
+	args := strings.Fields(cfg.TapCommand)
+	mentioned := false
+	for ix, arg := range args {
+		if strings.Contains(arg, "{file}") {
+			args[ix] = strings.Replace(arg, "{file}", file, -1)
+			mentioned = true
+		}
+	}
+	if !mentioned {
+		args = append(args, file)
+	}
+
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Dir = cfg.Dir
+	cmd.Stderr = os.Stderr
+
+	// So that the command can be killed along with anything it starts.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+
+	return cmd
+}
+
+// runFile runs a file's command, reporting the tests it writes. If the command exits with an
+// error without having reported a failing test, or is stopped because it takes too long, the
+// file itself is reported as an error. The test timeout applies to the time between tests.
+func (self tapRunner) runFile(file string, env map[string]string, seed int, quitChan <-chan struct{}, visitor tapjio.Visitor) error {
+	cfg := self.ctx.config
+	cmd := self.command(file)
+	cmd.Env = os.Environ()
+	for name, value := range cfg.EnvVars {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	for name, value := range env {
+		cmd.Env = append(cmd.Env, name+"="+value)
+	}
+	cmd.Env = append(cmd.Env, "QA_SEED="+strconv.Itoa(seed))
+
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return err
+	}
+	err = cmd.Start()
+	if err != nil {
+		return err
+	}
+
+	progress := make(chan struct{}, 1)
+	failed := false
+	decoded := make(chan error, 1)
+	go func() {
+		err := tapjio.DecodeTap(stdout, tapjio.FilePath(file), &tapjio.DecodingCallbacks{
+			OnTestFinish: func(event tapjio.TestFinishEvent) error {
+				select {
+				case progress <- struct{}{}:
+				default:
+				}
+
+				if !self.reports(file, event.Filter) {
+					return nil
+				}
+				if event.Status == tapjio.Fail || event.Status == tapjio.Error {
+					failed = true
+				}
+				event.Runner = cfg.Name
+				return visitor.TestFinish(event)
+			},
+		})
+		// Keep reading after a bail out, so the command isn't stuck writing.
+		io.Copy(ioutil.Discard, stdout)
+		decoded <- err
+	}()
+
+	var runnerTimedOut <-chan time.Time
+	if cfg.RunnerTimeout > 0 {
+		timer := time.NewTimer(cfg.RunnerTimeout)
+		defer timer.Stop()
+		runnerTimedOut = timer.C
+	}
+
+	var testTimer *time.Timer
+	var testTimedOut <-chan time.Time
+	if cfg.TestTimeout > 0 {
+		testTimer = time.NewTimer(cfg.TestTimeout)
+		defer testTimer.Stop()
+		testTimedOut = testTimer.C
+	}
+
+	var exception *tapjio.TestException
+	var decodeErr error
+	for running := true; running; {
+		select {
+		case decodeErr = <-decoded:
+			running = false
+		case <-progress:
+			if testTimer != nil {
+				if !testTimer.Stop() {
+					select {
+					case <-testTimer.C:
+					default:
+					}
+				}
+				testTimer.Reset(cfg.TestTimeout)
+			}
+		case <-quitChan:
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			<-decoded
+			cmd.Wait()
+			return nil
+		case <-runnerTimedOut:
+			exception = &tapjio.TestException{
+				Class:   tapjio.TimeoutExceptionClass,
+				Message: fmt.Sprintf("Runner timed out after %v", cfg.RunnerTimeout),
+			}
+		case <-testTimedOut:
+			exception = &tapjio.TestException{
+				Class:   tapjio.TimeoutExceptionClass,
+				Message: fmt.Sprintf("Test timed out after %v", cfg.TestTimeout),
+			}
+		}
+
+		if exception != nil {
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			decodeErr = <-decoded
+			running = false
+		}
+	}
+	exitErr := cmd.Wait()
+
+	if decodeErr != nil {
+		return decodeErr
+	}
+
+	if exception == nil && exitErr != nil && !failed {
+		exception = &tapjio.TestException{
+			Class:   tapjio.WorkerCrashExceptionClass,
+			Message: fmt.Sprintf("%s exited without reporting a failing test: %v", cfg.TapCommand, exitErr),
+		}
+	}
+	if exception == nil {
+		return nil
+	}
+
+	return visitor.TestFinish(tapjio.TestFinishEvent{
+		Type:      "test",
+		Label:     file,
+		Runner:    cfg.Name,
+		Filter:    fileFilter(file),
+		File:      tapjio.FilePath(file),
+		Status:    tapjio.Error,
+		Exception: exception,
+	})
+}
@@ -0,0 +1,121 @@
+package tap
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"qa/runner"
+	"qa/tapjio"
+)
+
+var testFiles = map[string]string{
+	"pass.sh": `echo 1..2
+echo "ok 1 - first"
+echo "ok 2 - second seeded $QA_SEED"
+`,
+	"fail.sh": `echo 1..2
+echo "ok 1 - first"
+echo "not ok 2 - second"
+exit 1
+`,
+	"crash.sh": `echo 1..1
+echo "ok 1 - only"
+exit 2
+`,
+	"hang.sh": `echo 1..2
+echo "ok 1 - before"
+sleep 60
+`,
+}
+
+func TestTapRunner(t *testing.T) {
+	dir, err := ioutil.TempDir("", "tap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	for name, content := range testFiles {
+		err = ioutil.WriteFile(filepath.Join(dir, name), []byte(content), 0644)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx, err := StartContext(runner.Config{
+		Name:         "tap",
+		FileLister:   runner.NewFileGlob(dir, []string{"*.sh"}),
+		Dir:          dir,
+		SquashPolicy: runner.SquashByFile,
+		TestTimeout:  time.Second,
+		TapCommand:   "sh {file}",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ctx.Close()
+
+	_, runners, err := ctx.EnumerateRunners(1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(runners) != 4 {
+		t.Fatalf("Expected a runner for each file, got %d", len(runners))
+	}
+
+	results := make(map[tapjio.TestFilter]tapjio.TestFinishEvent)
+	visitor := &tapjio.DecodingCallbacks{
+		OnTestFinish: func(event tapjio.TestFinishEvent) error {
+			results[event.Filter] = event
+			return nil
+		},
+	}
+	for _, testRunner := range runners {
+		err = testRunner.Run(nil, 7, make(chan struct{}), visitor)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	expected := map[string]string{
+		"pass.sh#first":           "pass",
+		"pass.sh#second seeded 7": "pass",
+		"fail.sh#first":           "pass",
+		"fail.sh#second":          "fail " + tapjio.TapFailureExceptionClass,
+		"crash.sh#only":           "pass",
+		"crash.sh":                "error " + tapjio.WorkerCrashExceptionClass,
+		"hang.sh#before":          "pass",
+		"hang.sh#2":               "error " + tapjio.TapMissingExceptionClass,
+		"hang.sh":                 "error " + tapjio.TimeoutExceptionClass,
+	}
+	if len(results) != len(expected) {
+		t.Fatalf("Expected %d results, got %#v", len(expected), results)
+	}
+	for filter, outcome := range expected {
+		event := results[tapjio.TestFilter(filter)]
+		actual := string(event.Status)
+		if event.Exception != nil {
+			actual += " " + event.Exception.Class
+		}
+		if actual != outcome {
+			t.Fatalf("Expected %s for %s, got %s", outcome, filter, actual)
+		}
+		if event.Runner != "tap" {
+			t.Fatalf("Expected runner to be recorded for %s, got %s", filter, event.Runner)
+		}
+	}
+
+	// Retrying one test reruns its file, but only reports that test.
+	results = make(map[tapjio.TestFilter]tapjio.TestFinishEvent)
+	retry := tapjio.TestFilter("fail.sh#second")
+	err = runners[0].Subset([]tapjio.TestFilter{retry}).Run(nil, 7, make(chan struct{}), visitor)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 1 || results[retry].Status != tapjio.Fail {
+		t.Fatalf("Expected only the retried test to be reported, got %#v", results)
+	}
+}
@@ -0,0 +1,212 @@
+package tapjio
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+type DiffOp byte
+
+const (
+	DiffSame    DiffOp = ' '
+	DiffRemoved DiffOp = '-'
+	DiffAdded   DiffOp = '+'
+
+	// The start of a hunk, e.g. "@@ -3,7 +3,8 @@".
+	DiffHunk DiffOp = '@'
+)
+
+// A DiffLine is a line of a unified diff from what was expected to what was actually given.
+type DiffLine struct {
+	Op   DiffOp
+	Text string
+}
+
+// How many unchanged lines are shown around each change.
+const diffContext = 3
+
+// Values with more lines than this are only diffed up to here, to keep diffing them quick.
+const maxDiffInputLines = 1000
+
+// diffable reports whether two compared values can be shown as a diff, rather than by the
+// assertion's message alone.
+func diffable(expected, actual *ComparedValue) bool {
+	if expected == nil || actual == nil || expected.Kind != actual.Kind {
+		return false
+	}
+
+	switch expected.Kind {
+	case "string", "array", "hash":
+		return true
+	}
+
+	return false
+}
+
+// lines breaks a value into the lines it's diffed by. Arrays have an element per line and hashes
+// an entry per line, sorted by key since their order doesn't matter when comparing them.
+func (self *ComparedValue) lines() []string {
+	var lines []string
+	switch self.Kind {
+	case "string":
+		lines = strings.Split(self.String, "\n")
+		if self.Truncated {
+			lines = append(lines, "…")
+		}
+	case "array":
+		lines = append(lines, "[")
+		for _, element := range self.Elements {
+			lines = append(lines, "  "+element+",")
+		}
+		if self.Truncated {
+			lines = append(lines, "  …")
+		}
+		lines = append(lines, "]")
+	case "hash":
+		entries := make([]string, 0, len(self.Entries))
+		for _, entry := range self.Entries {
+			entries = append(entries, "  "+entry[0]+" => "+entry[1]+",")
+		}
+		sort.Strings(entries)
+		lines = append(lines, "{")
+		lines = append(lines, entries...)
+		if self.Truncated {
+			lines = append(lines, "  …")
+		}
+		lines = append(lines, "}")
+	default:
+		lines = strings.Split(self.Inspect, "\n")
+	}
+
+	if len(lines) > maxDiffInputLines {
+		lines = append(lines[:maxDiffInputLines], "…")
+	}
+
+	return lines
+}
+
+// diffLines finds the fewest lines to remove from a and add to it to get b, and returns every
+// line of both in order.
+func diffLines(a, b []string) []DiffLine {
+	prefix := 0
+	for prefix < len(a) && prefix < len(b) && a[prefix] == b[prefix] {
+		prefix++
+	}
+	suffix := 0
+	for suffix < len(a)-prefix && suffix < len(b)-prefix && a[len(a)-1-suffix] == b[len(b)-1-suffix] {
+		suffix++
+	}
+
+	var diff []DiffLine
+	for _, line := range a[:prefix] {
+		diff = append(diff, DiffLine{Op: DiffSame, Text: line})
+	}
+
+	// The longest common subsequence of what's left, by the length of each from i and j on.
+	middleA, middleB := a[prefix:len(a)-suffix], b[prefix:len(b)-suffix]
+	n, m := len(middleA), len(middleB)
+	lengths := make([][]int32, n+1)
+	for i := range lengths {
+		lengths[i] = make([]int32, m+1)
+	}
+	for i := n - 1; i >= 0; i-- {
+		for j := m - 1; j >= 0; j-- {
+			if middleA[i] == middleB[j] {
+				lengths[i][j] = lengths[i+1][j+1] + 1
+			} else if lengths[i+1][j] >= lengths[i][j+1] {
+				lengths[i][j] = lengths[i+1][j]
+			} else {
+				lengths[i][j] = lengths[i][j+1]
+			}
+		}
+	}
+
+	i, j := 0, 0
+	for i < n || j < m {
+		switch {
+		case i < n && j < m && middleA[i] == middleB[j]:
+			diff = append(diff, DiffLine{Op: DiffSame, Text: middleA[i]})
+			i++
+			j++
+		case j == m || (i < n && lengths[i+1][j] >= lengths[i][j+1]):
+			diff = append(diff, DiffLine{Op: DiffRemoved, Text: middleA[i]})
+			i++
+		default:
+			diff = append(diff, DiffLine{Op: DiffAdded, Text: middleB[j]})
+			j++
+		}
+	}
+
+	for _, line := range a[len(a)-suffix:] {
+		diff = append(diff, DiffLine{Op: DiffSame, Text: line})
+	}
+
+	return diff
+}
+
+// Diff returns a unified diff of what a failed equality assertion expected and what it was
+// actually given, or nil if the exception isn't from one, or its values can't be diffed.
+func Diff(exception *TestException) []DiffLine {
+	if exception == nil || !diffable(exception.Expected, exception.Actual) {
+		return nil
+	}
+
+	lines := diffLines(exception.Expected.lines(), exception.Actual.lines())
+
+	// How many lines of each value come before each line of the diff.
+	before := make([][2]int, len(lines)+1)
+	for ix, line := range lines {
+		before[ix+1] = before[ix]
+		if line.Op != DiffAdded {
+			before[ix+1][0]++
+		}
+		if line.Op != DiffRemoved {
+			before[ix+1][1]++
+		}
+	}
+
+	var diff []DiffLine
+	for ix := 0; ix < len(lines); {
+		if lines[ix].Op == DiffSame {
+			ix++
+			continue
+		}
+
+		// Changes close enough together to share their context go in the same hunk.
+		lastChange := ix
+		for next := ix; next < len(lines) && next-lastChange <= 2*diffContext; next++ {
+			if lines[next].Op != DiffSame {
+				lastChange = next
+			}
+		}
+
+		start := ix - diffContext
+		if start < 0 {
+			start = 0
+		}
+		stop := lastChange + diffContext + 1
+		if stop > len(lines) {
+			stop = len(lines)
+		}
+
+		header := fmt.Sprintf("@@ -%d,%d +%d,%d @@",
+			before[start][0]+1, before[stop][0]-before[start][0],
+			before[start][1]+1, before[stop][1]-before[start][1])
+		diff = append(diff, DiffLine{Op: DiffHunk, Text: header})
+		diff = append(diff, lines[start:stop]...)
+
+		ix = stop
+	}
+
+	return diff
+}
+
+// String formats a line the way it would appear in a unified diff.
+func (self DiffLine) String() string {
+	if self.Op == DiffHunk {
+		return self.Text
+	}
+
+	return string(self.Op) + self.Text
+}
@@ -0,0 +1,71 @@
+package tapjio
+
+import (
+	"strings"
+	"testing"
+)
+
+func diffText(diff []DiffLine) string {
+	var lines []string
+	for _, line := range diff {
+		lines = append(lines, line.String())
+	}
+
+	return strings.Join(lines, "\n")
+}
+
+func TestDiff(t *testing.T) {
+	cases := []struct {
+		name             string
+		expected, actual *ComparedValue
+		diff             string
+	}{
+		{
+			name:     "strings",
+			expected: &ComparedValue{Kind: "string", String: "one\ntwo\nthree"},
+			actual:   &ComparedValue{Kind: "string", String: "one\n2\nthree\nfour"},
+			diff:     "@@ -1,3 +1,4 @@\n one\n-two\n+2\n three\n+four",
+		},
+		{
+			name:     "arrays",
+			expected: &ComparedValue{Kind: "array", Elements: []string{"1", "2", "3", "4", "5", "6", "7", "8", "9", "10", "11"}},
+			actual:   &ComparedValue{Kind: "array", Elements: []string{"0", "1", "2", "3", "4", "5", "6", "7", "8", "9", "10"}},
+			diff: "@@ -1,4 +1,5 @@\n [\n+  0,\n   1,\n   2,\n   3,\n" +
+				"@@ -9,5 +10,4 @@\n   8,\n   9,\n   10,\n-  11,\n ]",
+		},
+		{
+			name: "hashes, whatever order their keys are in",
+			expected: &ComparedValue{Kind: "hash", Entries: [][2]string{
+				{`"b"`, "2"}, {`"a"`, "1"}}},
+			actual: &ComparedValue{Kind: "hash", Entries: [][2]string{
+				{`"a"`, "1"}, {`"b"`, "3"}}, Truncated: true},
+			diff: "@@ -1,4 +1,5 @@\n {\n   \"a\" => 1,\n-  \"b\" => 2,\n+  \"b\" => 3,\n+  …\n }",
+		},
+		{
+			name:     "objects",
+			expected: &ComparedValue{Kind: "object", Inspect: "1"},
+			actual:   &ComparedValue{Kind: "object", Inspect: "2"},
+		},
+		{
+			name:     "different kinds",
+			expected: &ComparedValue{Kind: "string", String: "1", Inspect: `"1"`},
+			actual:   &ComparedValue{Kind: "object", Inspect: "1"},
+		},
+		{
+			name:     "values that look the same",
+			expected: &ComparedValue{Kind: "array", Elements: []string{"1.0"}},
+			actual:   &ComparedValue{Kind: "array", Elements: []string{"1.0"}},
+		},
+	}
+
+	for _, c := range cases {
+		diff := Diff(&TestException{Expected: c.expected, Actual: c.actual})
+		if text := diffText(diff); text != c.diff {
+			t.Errorf("Diff of %s: expected:\n%s\ngot:\n%s", c.name, c.diff, text)
+		}
+	}
+
+	if Diff(&TestException{Message: "boom"}) != nil {
+		t.Error("Expected no diff for an exception without compared values")
+	}
+}
@@ -29,6 +29,9 @@ const (
 	Omit  Status = "omit"
 	Fail  Status = "fail"
 	Error Status = "error"
+
+	// NotRun marks a test that was never run, e.g. because the run was cut short.
+	NotRun Status = "notrun"
 )
 
 func (s Status) String() string {
@@ -48,6 +51,10 @@ type SuiteBeginEvent struct {
 	Rev     int    `json:"rev"`
 	Label   string `json:"label,omitempty"`
 	Coderef string `json:"coderef,omitempty"`
+
+	// Shard and ShardCount are set when only part of a suite is run, e.g. by -shard 3/8.
+	Shard      int `json:"qa:shard,omitempty"`
+	ShardCount int `json:"qa:shard-count,omitempty"`
 }
 
 func NewSuiteBeginEvent(startTime time.Time, count int, seed int) *SuiteBeginEvent {
@@ -101,6 +108,33 @@ type TestException struct {
 	//  "test/skip-test.rb:5"
 	// ]
 	Backtrace []BacktraceLocation `json:"backtrace"`
+
+	// Where every other thread was, if known. Included when a test is timed out.
+	Threads []ThreadBacktrace `json:"threads,omitempty"`
+
+	// What a failed equality assertion expected, and what it was given instead.
+	Expected *ComparedValue `json:"expected,omitempty"`
+	Actual   *ComparedValue `json:"actual,omitempty"`
+}
+
+// A ComparedValue is one side of a failed equality assertion. Strings, arrays and hashes come
+// with their parts, so they can be diffed line by line.
+type ComparedValue struct {
+	// One of "string", "array", "hash" or "object".
+	Kind    string `json:"kind"`
+	Inspect string `json:"inspect"`
+
+	String   string      `json:"string,omitempty"`
+	Elements []string    `json:"elements,omitempty"`
+	Entries  [][2]string `json:"entries,omitempty"`
+
+	// Whether the value was too large to be sent whole.
+	Truncated bool `json:"truncated,omitempty"`
+}
+
+type ThreadBacktrace struct {
+	Label     string              `json:"label"`
+	Backtrace []BacktraceLocation `json:"backtrace"`
 }
 
 type BacktraceLocation struct {
@@ -144,6 +178,9 @@ type TestBeginEvent struct {
 	Subtype   string     `json:"qa:subtype"`
 	Filter    TestFilter `json:"qa:filter"`
 	File      FilePath   `json:"qa:file"`
+	Pid       int        `json:"qa:pid,omitempty"`
+	Attempt   int        `json:"qa:attempt,omitempty"`
+	Worker    string     `json:"qa:worker,omitempty"`
 
 	Cases []CaseEvent `json:"-"`
 }
@@ -225,8 +262,43 @@ type TestFinishEvent struct {
 
 	Dependencies *TestDependencies `json:"dependencies,omitempty"`
 	Exception    *TestException    `json:"exception,omitempty"`
+
+	// Set for tests that were run more than once. Attempt counts from 1. Retried is true for
+	// every attempt but the last, and Flaky is true if the last attempt passed.
+	Attempt int  `json:"qa:attempt,omitempty"`
+	Retried bool `json:"qa:retried,omitempty"`
+	Flaky   bool `json:"qa:flaky,omitempty"`
+
+	// Worker identifies which of the run's workers ran the test, counting from 0 like
+	// QA_WORKER. Tests that ran earlier on the same worker may have affected this one.
+	Worker string `json:"qa:worker,omitempty"`
+
+	// Set if the test passed, but took longer than its duration budget allows.
+	Budget *BudgetOverrun `json:"qa:budget,omitempty"`
 }
 
+// A BudgetOverrun records that a test, or a whole suite, took longer than its duration budget.
+// Unless it's only a warning, a test over budget is reported as failing, and a suite over
+// budget hasn't passed.
+type BudgetOverrun struct {
+	// The pattern the budget was given for. Empty for the suite's budget.
+	Pattern string  `json:"pattern,omitempty"`
+	Budget  float64 `json:"budget"`
+	Warning bool    `json:"warning,omitempty"`
+}
+
+// BudgetExceptionClass is the class of the exception given to tests that fail for going over
+// their duration budget.
+const BudgetExceptionClass = "Qa::OverBudget"
+
+// TimeoutExceptionClass is the class of the exception given to tests that a runner stopped for
+// taking too long.
+const TimeoutExceptionClass = "Qa::Timeout"
+
+// WorkerCrashExceptionClass is the class of the exception given to tests that weren't reported
+// before the process running them exited or crashed.
+const WorkerCrashExceptionClass = "Qa::WorkerCrash"
+
 type OutcomeDigest string
 
 var NoOutcome = OutcomeDigest("")
@@ -387,6 +459,9 @@ type ResultTally struct {
 	Error int `json:"error"`
 	Omit  int `json:"omit"`
 	Todo  int `json:"todo"`
+
+	NotRun int `json:"notrun,omitempty"`
+	Flaky  int `json:"flaky,omitempty"`
 }
 
 func (r *ResultTally) IncrementAll(other *ResultTally) {
@@ -396,6 +471,8 @@ func (r *ResultTally) IncrementAll(other *ResultTally) {
 	r.Error += other.Error
 	r.Omit += other.Omit
 	r.Todo += other.Todo
+	r.NotRun += other.NotRun
+	r.Flaky += other.Flaky
 }
 
 func (r *ResultTally) Increment(status Status) {
@@ -412,9 +489,27 @@ func (r *ResultTally) Increment(status Status) {
 		r.Omit += 1
 	case Todo:
 		r.Todo += 1
+	case NotRun:
+		r.NotRun += 1
 	}
 }
 
+// IncrementFor counts the outcome of the given test. Attempts that were retried aren't counted,
+// and a test that passed only after being retried is counted as flaky rather than passing.
+func (r *ResultTally) IncrementFor(test TestFinishEvent) {
+	if test.Retried {
+		return
+	}
+
+	if test.Flaky {
+		r.Total += 1
+		r.Flaky += 1
+		return
+	}
+
+	r.Increment(test.Status)
+}
+
 type SuiteFinishEvent struct {
 	Type      string         `json:"type"`
 	Time      float64        `json:"time"`
@@ -422,9 +517,27 @@ type SuiteFinishEvent struct {
 	Stats     map[string]int `json:"qa:stats,omitempty"`
 	MetaStats map[string]int `json:"-"`
 
+	// Set if the suite took longer than its duration budget allows.
+	Budget *BudgetOverrun `json:"qa:budget,omitempty"`
+
 	Suite *SuiteBeginEvent `json:"-"`
 }
 
+// EstimatedMakespanStat names the stat holding how long, in milliseconds, the suite was
+// expected to take based on archived test durations.
+const EstimatedMakespanStat = "estimated-makespan-ms"
+
+// MakespanStat names the stat holding how long, in milliseconds, the suite's tests took from
+// the first one starting to the last one finishing. Unlike the suite's time, it leaves out
+// starting workers and listing tests, so it can be compared with EstimatedMakespanStat.
+const MakespanStat = "makespan-ms"
+
+// WorkerRecyclesStat names the stat counting how many workers were replaced for using too much
+// memory. Each replacement is also recorded as a trace event named WorkerRecycleTraceName.
+const WorkerRecyclesStat = "worker-recycles"
+
+const WorkerRecycleTraceName = "qa:worker-recycle"
+
 func NewSuiteFinishEvent(suite *SuiteBeginEvent) *SuiteFinishEvent {
 	return &SuiteFinishEvent{
 		Type:      "final", // TODO(adamb) Figure out how to make Type implied.
@@ -598,8 +711,12 @@ func (s *DecodingCallbacks) End(reason error) error {
 }
 
 func (self SuiteFinishEvent) Passed() bool {
+	if self.Budget != nil && !self.Budget.Warning {
+		return false
+	}
+
 	c := self.Counts
-	return c.Total == c.Pass+c.Omit+c.Todo
+	return c.Total == c.Pass+c.Omit+c.Todo+c.Flaky
 }
 
 func (self *AwaitAttachEvent) String() string {
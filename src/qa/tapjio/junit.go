@@ -0,0 +1,340 @@
+package tapjio
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type junitTestSuites struct {
+	XMLName  xml.Name          `xml:"testsuites"`
+	Name     string            `xml:"name,attr,omitempty"`
+	Tests    int               `xml:"tests,attr"`
+	Failures int               `xml:"failures,attr"`
+	Errors   int               `xml:"errors,attr"`
+	Skipped  int               `xml:"skipped,attr"`
+	Time     string            `xml:"time,attr"`
+	Suites   []*junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name      string            `xml:"name,attr"`
+	Tests     int               `xml:"tests,attr"`
+	Failures  int               `xml:"failures,attr"`
+	Errors    int               `xml:"errors,attr"`
+	Skipped   int               `xml:"skipped,attr"`
+	Time      string            `xml:"time,attr"`
+	Timestamp string            `xml:"timestamp,attr,omitempty"`
+	Suites    []*junitTestSuite `xml:"testsuite"`
+	Cases     []*junitTestCase  `xml:"testcase"`
+
+	time     float64
+	children map[string]*junitTestSuite
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	Classname string        `xml:"classname,attr"`
+	File      string        `xml:"file,attr,omitempty"`
+	Line      int           `xml:"line,attr,omitempty"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitProblem `xml:"failure"`
+	Error     *junitProblem `xml:"error"`
+	Skipped   *junitSkipped `xml:"skipped"`
+	SystemOut *junitText    `xml:"system-out"`
+	SystemErr *junitText    `xml:"system-err"`
+}
+
+type junitProblem struct {
+	Message string `xml:"message,attr"`
+	Type    string `xml:"type,attr"`
+	Text    string `xml:",cdata"`
+}
+
+type junitText struct {
+	Text string `xml:",cdata"`
+}
+
+// newJunitText returns text that's safe to put in CDATA, or nil if there isn't any.
+func newJunitText(text string) *junitText {
+	if text == "" {
+		return nil
+	}
+
+	return &junitText{Text: junitSanitize(text)}
+}
+
+// junitSanitize replaces characters that XML doesn't allow, like the escape characters of
+// terminal colors, with U+FFFD.
+func junitSanitize(text string) string {
+	return strings.Map(func(r rune) rune {
+		if r == '\t' || r == '\n' || r == '\r' ||
+			r >= 0x20 && r <= 0xD7FF ||
+			r >= 0xE000 && r <= 0xFFFD ||
+			r >= 0x10000 && r <= 0x10FFFF {
+			return r
+		}
+		return '\uFFFD'
+	}, text)
+}
+
+type junitSkipped struct {
+	Message string `xml:"message,attr,omitempty"`
+}
+
+func junitSeconds(seconds float64) string {
+	return strconv.FormatFloat(seconds, 'f', 6, 64)
+}
+
+func newJunitTestSuite(name string) *junitTestSuite {
+	return &junitTestSuite{Name: name, children: make(map[string]*junitTestSuite)}
+}
+
+func (self *junitTestSuite) child(name string) *junitTestSuite {
+	child, ok := self.children[name]
+	if !ok {
+		child = newJunitTestSuite(name)
+		self.children[name] = child
+		self.Suites = append(self.Suites, child)
+	}
+
+	return child
+}
+
+// finish fills in the counts and times of the suite, and those of the suites within it.
+func (self *junitTestSuite) finish() {
+	for _, suite := range self.Suites {
+		suite.finish()
+		self.Tests += suite.Tests
+		self.Failures += suite.Failures
+		self.Errors += suite.Errors
+		self.Skipped += suite.Skipped
+		self.time += suite.time
+	}
+	self.Time = junitSeconds(self.time)
+}
+
+// The name of the suite holding tests that aren't part of any case, e.g. those from runners
+// that don't report cases.
+func junitSuiteName(test TestFinishEvent) string {
+	if test.File != "" {
+		return test.File.String()
+	}
+	if test.Runner != "" {
+		return test.Runner
+	}
+
+	return "qa"
+}
+
+// junitProblemText describes where an exception happened: its backtrace, along with the code
+// around each line that has a snippet. Failed equality assertions get a diff of their values too.
+func junitProblemText(exception *TestException) string {
+	buffer := &bytes.Buffer{}
+	fmt.Fprintf(buffer, "%s\n", exception.Message)
+
+	if diff := Diff(exception); diff != nil {
+		fmt.Fprintf(buffer, "\n--- expected\n+++ actual\n")
+		for _, line := range diff {
+			fmt.Fprintf(buffer, "%s\n", line)
+		}
+	}
+
+	if len(exception.Backtrace) > 0 {
+		fmt.Fprintf(buffer, "\n")
+	}
+	for _, entry := range exception.Backtrace {
+		if entry.Method != "" {
+			fmt.Fprintf(buffer, "%s:%d in %s\n", entry.File, entry.Line, entry.Method)
+		} else {
+			fmt.Fprintf(buffer, "%s:%d\n", entry.File, entry.Line)
+		}
+
+		lines, ok := exception.Snippets[entry.File]
+		if !ok {
+			continue
+		}
+		for i := entry.Line - 3; i <= entry.Line+3; i++ {
+			lineText, ok := lines[strconv.Itoa(i)]
+			if !ok {
+				continue
+			}
+			marker := " "
+			if i == entry.Line {
+				marker = ">"
+			}
+			fmt.Fprintf(buffer, "  %s %4d  %s\n", marker, i, lineText)
+		}
+	}
+
+	return buffer.String()
+}
+
+type junit struct {
+	writer io.Writer
+	closer io.Closer
+	suites *junitTestSuites
+
+	// The suite of the run in progress, if it has a timestamp.
+	timestamp string
+	roots     map[string]*junitTestSuite
+}
+
+// NewJunitEmitCloser returns a visitor that writes the tests it visits to writer as JUnit
+// XML, then closes it. Nothing is written until the visitor's End method is called.
+func NewJunitEmitCloser(writer io.WriteCloser) *junit {
+	return &junit{writer: writer, closer: writer, suites: &junitTestSuites{}, roots: make(map[string]*junitTestSuite)}
+}
+
+func NewJunitEmitter(writer io.Writer) *junit {
+	return &junit{writer: writer, suites: &junitTestSuites{}, roots: make(map[string]*junitTestSuite)}
+}
+
+func (self *junit) TraceEvent(event TraceEvent) error {
+	return nil
+}
+
+func (self *junit) AwaitAttach(event AwaitAttachEvent) error {
+	return nil
+}
+
+func (self *junit) SuiteBegin(event SuiteBeginEvent) error {
+	if self.suites.Name == "" {
+		self.suites.Name = event.Label
+	}
+
+	self.timestamp = ""
+	if start, err := time.ParseInLocation("2006-01-02 15:04:05", event.Start, time.Local); err == nil {
+		self.timestamp = start.Format("2006-01-02T15:04:05")
+	}
+
+	// Each run of the suite gets suites of its own.
+	self.roots = make(map[string]*junitTestSuite)
+
+	return nil
+}
+
+func (self *junit) TestBegin(event TestBeginEvent) error {
+	return nil
+}
+
+func (self *junit) root(name string) *junitTestSuite {
+	suite, ok := self.roots[name]
+	if !ok {
+		suite = newJunitTestSuite(name)
+		suite.Timestamp = self.timestamp
+		self.roots[name] = suite
+		self.suites.Suites = append(self.suites.Suites, suite)
+	}
+
+	return suite
+}
+
+func (self *junit) TestFinish(event TestFinishEvent) error {
+	// Only the last attempt at a test counts.
+	if event.Retried {
+		return nil
+	}
+
+	var suite *junitTestSuite
+	var classnames []string
+	for _, kase := range event.Cases {
+		if suite == nil {
+			suite = self.root(kase.Label)
+		} else {
+			suite = suite.child(kase.Label)
+		}
+		classnames = append(classnames, kase.Label)
+	}
+	if suite == nil {
+		suite = self.root(junitSuiteName(event))
+		classnames = append(classnames, suite.Name)
+	}
+
+	testCase := &junitTestCase{
+		Name:      event.Label,
+		Classname: strings.Join(classnames, "."),
+		File:      event.File.String(),
+		Line:      event.Line,
+		Time:      junitSeconds(event.Time),
+		SystemOut: newJunitText(event.Stdout),
+		SystemErr: newJunitText(event.Stderr),
+	}
+
+	exception := event.Exception
+	if exception == nil {
+		exception = &TestException{}
+	}
+	problem := &junitProblem{
+		Message: exception.Message,
+		Type:    exception.Class,
+		Text:    junitSanitize(junitProblemText(exception)),
+	}
+
+	suite.Tests++
+	suite.time += event.Time
+	switch event.Status {
+	case Fail:
+		testCase.Failure = problem
+		suite.Failures++
+	case Error:
+		testCase.Error = problem
+		suite.Errors++
+	case Omit, Todo:
+		testCase.Skipped = &junitSkipped{Message: exception.Message}
+		if testCase.Skipped.Message == "" && event.Status == Todo {
+			testCase.Skipped.Message = "todo"
+		}
+		suite.Skipped++
+	case NotRun:
+		testCase.Skipped = &junitSkipped{Message: "not run"}
+		suite.Skipped++
+	}
+	if event.Flaky {
+		testCase.SystemOut = newJunitText("Flaky: passed after being retried.\n" + event.Stdout)
+	}
+
+	suite.Cases = append(suite.Cases, testCase)
+	return nil
+}
+
+func (self *junit) SuiteFinish(event SuiteFinishEvent) error {
+	return nil
+}
+
+func (self *junit) End(reason error) error {
+	suites := self.suites
+	total := 0.0
+	for _, suite := range suites.Suites {
+		suite.finish()
+		suites.Tests += suite.Tests
+		suites.Failures += suite.Failures
+		suites.Errors += suite.Errors
+		suites.Skipped += suite.Skipped
+		total += suite.time
+	}
+	suites.Time = junitSeconds(total)
+
+	_, err := io.WriteString(self.writer, xml.Header)
+	if err == nil {
+		encoder := xml.NewEncoder(self.writer)
+		encoder.Indent("", "  ")
+		err = encoder.Encode(suites)
+	}
+	if err == nil {
+		_, err = io.WriteString(self.writer, "\n")
+	}
+
+	if self.closer != nil {
+		closeErr := self.closer.Close()
+		if err == nil {
+			err = closeErr
+		}
+	}
+
+	return err
+}
@@ -0,0 +1,297 @@
+package tapjio
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The exception classes reported for JUnit failures and errors that don't give a type.
+const JunitFailureExceptionClass = "JUnit::Failure"
+const JunitErrorExceptionClass = "JUnit::Error"
+
+// Patterns for the stack frames found in the text of failures. Java's look like
+// "at com.example.MathTest.adds(MathTest.java:12)", Python's like
+// "File "tests/test_math.py", line 12, in test_adds", and most others like
+// "test/math_test.rb:12:in `test_adds'" or "spec/user_spec.rb:12 in block (2 levels)".
+var junitJavaFramePattern = regexp.MustCompile(`^\s*at (\S+)\(([^:()]+):(\d+)\)\s*$`)
+var junitPythonFramePattern = regexp.MustCompile(`^\s*File "([^"]+)", line (\d+)(?:, in (.*))?$`)
+var junitFramePattern = regexp.MustCompile("^\\s*([^\\s:]+\\.\\w+):(\\d+)(?::\\d+)?(?::in `([^']*)'| in (.*))?\\s*$")
+
+type junitReadSuite struct {
+	XMLName   xml.Name
+	Name      string           `xml:"name,attr"`
+	File      string           `xml:"file,attr"`
+	Timestamp string           `xml:"timestamp,attr"`
+	Suites    []junitReadSuite `xml:"testsuite"`
+	Cases     []junitReadCase  `xml:"testcase"`
+}
+
+type junitReadCase struct {
+	Name      string             `xml:"name,attr"`
+	Classname string             `xml:"classname,attr"`
+	File      string             `xml:"file,attr"`
+	Line      string             `xml:"line,attr"`
+	Time      string             `xml:"time,attr"`
+	Failures  []junitReadProblem `xml:"failure"`
+	Errors    []junitReadProblem `xml:"error"`
+	Skipped   *junitReadProblem  `xml:"skipped"`
+	SystemOut string             `xml:"system-out"`
+	SystemErr string             `xml:"system-err"`
+
+	// Earlier attempts at the test, as Maven Surefire reports them when it reruns tests.
+	FlakyFailures []junitReadProblem `xml:"flakyFailure"`
+	FlakyErrors   []junitReadProblem `xml:"flakyError"`
+	RerunFailures []junitReadProblem `xml:"rerunFailure"`
+	RerunErrors   []junitReadProblem `xml:"rerunError"`
+}
+
+type junitReadProblem struct {
+	Message   string `xml:"message,attr"`
+	Type      string `xml:"type,attr"`
+	Text      string `xml:",chardata"`
+	SystemOut string `xml:"system-out"`
+	SystemErr string `xml:"system-err"`
+}
+
+// JunitFilter returns the filter for a JUnit test, classname#name. Tests without a classname
+// are identified by file#name instead, or by their name alone if there's no file either.
+func JunitFilter(classname string, file FilePath, name string) TestFilter {
+	prefix := classname
+	if prefix == "" {
+		prefix = file.String()
+	}
+	if prefix == "" {
+		return TestFilter(name)
+	}
+
+	return TestFilter(prefix + "#" + name)
+}
+
+// junitTimestamp parses the timestamp of a testsuite. Timestamps without a time zone are taken
+// to be local.
+func junitTimestamp(text string) (time.Time, bool) {
+	text = strings.TrimSpace(text)
+	if t, err := time.Parse(time.RFC3339Nano, text); err == nil {
+		return t, true
+	}
+	if t, err := time.ParseInLocation("2006-01-02T15:04:05.999999999", text, time.Local); err == nil {
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// junitParseSeconds parses a time attribute. Some tools write times like 1,234.5.
+func junitParseSeconds(text string) float64 {
+	seconds, _ := strconv.ParseFloat(strings.Replace(strings.TrimSpace(text), ",", "", -1), 64)
+	return seconds
+}
+
+// junitBacktrace finds the stack frames in the text of a failure. The lines before the first
+// frame are returned as well, as they usually describe the failure.
+func junitBacktrace(text string) (backtrace []BacktraceLocation, preamble string) {
+	var lines []string
+	for _, line := range strings.Split(text, "\n") {
+		location := BacktraceLocation{User: true}
+		if match := junitJavaFramePattern.FindStringSubmatch(line); match != nil {
+			location.Method = match[1]
+			location.File = match[2]
+			location.Line, _ = strconv.Atoi(match[3])
+		} else if match := junitPythonFramePattern.FindStringSubmatch(line); match != nil {
+			location.File = match[1]
+			location.Line, _ = strconv.Atoi(match[2])
+			location.Method = match[3]
+		} else if match := junitFramePattern.FindStringSubmatch(line); match != nil {
+			location.File = match[1]
+			location.Line, _ = strconv.Atoi(match[2])
+			location.Method = match[3] + match[4]
+		} else {
+			if len(backtrace) == 0 {
+				lines = append(lines, line)
+			}
+			continue
+		}
+
+		backtrace = append(backtrace, location)
+	}
+
+	return backtrace, strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+func junitException(problem junitReadProblem, class string) *TestException {
+	backtrace, preamble := junitBacktrace(problem.Text)
+
+	exception := &TestException{
+		Class:     problem.Type,
+		Message:   problem.Message,
+		Backtrace: backtrace,
+	}
+	if exception.Class == "" {
+		exception.Class = class
+	}
+	if exception.Message == "" {
+		exception.Message = preamble
+	}
+
+	return exception
+}
+
+type junitDecoder struct {
+	file    FilePath
+	visitor Visitor
+	start   time.Time
+}
+
+func (self *junitDecoder) suite(suite junitReadSuite, cases []CaseEvent) error {
+	if suite.Name != "" {
+		cases = append(cases[:len(cases):len(cases)], CaseEvent{Type: "case", Label: suite.Name, Level: len(cases)})
+	}
+	if start, ok := junitTimestamp(suite.Timestamp); ok && (self.start.IsZero() || start.Before(self.start)) {
+		self.start = start
+	}
+
+	for _, kase := range suite.Cases {
+		file := self.file
+		if kase.File != "" {
+			file = FilePath(kase.File)
+		} else if suite.File != "" {
+			file = FilePath(suite.File)
+		}
+
+		err := self.test(kase, file, cases)
+		if err != nil {
+			return err
+		}
+	}
+
+	for _, child := range suite.Suites {
+		err := self.suite(child, cases)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+func (self *junitDecoder) test(kase junitReadCase, file FilePath, cases []CaseEvent) error {
+	// Tools like pytest put every test in one suite and tell them apart by classname, so
+	// the classname gets a case of its own unless it just repeats the suite's.
+	if kase.Classname != "" && len(cases) > 0 {
+		var labels []string
+		for _, c := range cases {
+			labels = append(labels, c.Label)
+		}
+		if kase.Classname != labels[len(labels)-1] && kase.Classname != strings.Join(labels, ".") {
+			cases = append(cases[:len(cases):len(cases)], CaseEvent{Type: "case", Label: kase.Classname, Level: len(cases)})
+		}
+	} else if kase.Classname != "" {
+		cases = []CaseEvent{{Type: "case", Label: kase.Classname}}
+	}
+
+	line, _ := strconv.Atoi(kase.Line)
+	event := TestFinishEvent{
+		Type:   "test",
+		Label:  kase.Name,
+		Filter: JunitFilter(kase.Classname, file, kase.Name),
+		File:   file,
+		Line:   line,
+		Time:   junitParseSeconds(kase.Time),
+		Stdout: kase.SystemOut,
+		Stderr: kase.SystemErr,
+		Cases:  cases,
+	}
+
+	var attempts []TestFinishEvent
+	addAttempts := func(problems []junitReadProblem, status Status, class string) {
+		for _, problem := range problems {
+			attempt := event
+			attempt.Time = 0
+			attempt.Status = status
+			attempt.Exception = junitException(problem, class)
+			attempt.Stdout = problem.SystemOut
+			attempt.Stderr = problem.SystemErr
+			attempt.Retried = true
+			attempts = append(attempts, attempt)
+		}
+	}
+	addAttempts(kase.FlakyFailures, Fail, JunitFailureExceptionClass)
+	addAttempts(kase.FlakyErrors, Error, JunitErrorExceptionClass)
+	addAttempts(kase.RerunFailures, Fail, JunitFailureExceptionClass)
+	addAttempts(kase.RerunErrors, Error, JunitErrorExceptionClass)
+
+	switch {
+	case len(kase.Errors) > 0:
+		event.Status = Error
+		event.Exception = junitException(kase.Errors[0], JunitErrorExceptionClass)
+	case len(kase.Failures) > 0:
+		event.Status = Fail
+		event.Exception = junitException(kase.Failures[0], JunitFailureExceptionClass)
+	case kase.Skipped != nil:
+		event.Status = Omit
+		if kase.Skipped.Message != "" {
+			event.Exception = &TestException{Message: kase.Skipped.Message}
+		}
+	default:
+		event.Status = Pass
+	}
+
+	if len(attempts) > 0 {
+		for ix := range attempts {
+			attempts[ix].Attempt = ix + 1
+		}
+		event.Attempt = len(attempts) + 1
+		event.Flaky = event.Status == Pass
+	}
+
+	for _, attempt := range append(attempts, event) {
+		err := self.visitor.TestFinish(attempt)
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// DecodeJunit reads JUnit XML from reader, visiting a TestFinishEvent for each testcase. Each
+// enclosing testsuite becomes one of the test's cases. Tests are in the file their testcase
+// or testsuite gives, or file if neither does (see JunitFilter). Earlier attempts at tests
+// that were rerun, as Maven Surefire reports them, are visited as retried tests.
+//
+// Like DecodeTap, DecodeJunit doesn't visit suite events or call the visitor's End method. It
+// returns the earliest timestamp of the testsuites read, or the zero time if they don't have
+// one.
+func DecodeJunit(reader io.Reader, file FilePath, visitor Visitor) (start time.Time, err error) {
+	var root junitReadSuite
+	err = xml.NewDecoder(reader).Decode(&root)
+	if err != nil {
+		return
+	}
+
+	decoder := &junitDecoder{file: file, visitor: visitor}
+	switch root.XMLName.Local {
+	case "testsuites":
+		for _, suite := range root.Suites {
+			err = decoder.suite(suite, nil)
+			if err != nil {
+				return
+			}
+		}
+	case "testsuite":
+		err = decoder.suite(root, nil)
+		if err != nil {
+			return
+		}
+	default:
+		err = fmt.Errorf("Expected testsuites or testsuite element, got %s", root.XMLName.Local)
+		return
+	}
+
+	return decoder.start, nil
+}
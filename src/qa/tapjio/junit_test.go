@@ -0,0 +1,231 @@
+package tapjio
+
+import (
+	"bytes"
+	"encoding/xml"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestJunitEmitter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	emitter := NewJunitEmitter(buffer)
+
+	models := CaseEvent{Type: "case", Label: "Models", Level: 0}
+	user := CaseEvent{Type: "case", Label: "User", Level: 1}
+
+	suite := NewSuiteBeginEvent(time.Date(2016, 3, 4, 5, 6, 7, 0, time.Local), 5, 1)
+	suite.Label = "app"
+	events := []TestFinishEvent{
+		{Type: "test", Label: "saves", Status: Pass, Time: 0.5, Cases: []CaseEvent{models, user}, File: "spec/user_spec.rb", Line: 3},
+		{Type: "test", Label: "validates", Status: Fail, Time: 0.25, Cases: []CaseEvent{models, user}, Stdout: "some output\n\x1b[0m",
+			Exception: &TestException{
+				Class:     "RSpec::Expectations::ExpectationNotMetError",
+				Message:   "expected valid",
+				Backtrace: []BacktraceLocation{{File: "spec/user_spec.rb", Line: 12, Method: "block (2 levels)"}},
+				Snippets:  map[string]map[string]string{"spec/user_spec.rb": {"11": "it 'validates' do", "12": "  expect(user).to be_valid"}},
+			}},
+		{Type: "test", Label: "loads", Status: Error, Retried: true, Cases: []CaseEvent{models}},
+		{Type: "test", Label: "loads", Status: Error, Cases: []CaseEvent{models}, Stderr: "warning\n",
+			Exception: &TestException{Class: "NameError", Message: "undefined"}},
+		{Type: "test", Label: "later", Status: Todo, File: "test/later_test.rb"},
+		{Type: "test", Label: "never", Status: NotRun, File: "test/later_test.rb"},
+	}
+
+	err := emitter.SuiteBegin(*suite)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, event := range events {
+		err = emitter.TestFinish(event)
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	err = emitter.SuiteFinish(*NewSuiteFinishEvent(suite))
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = emitter.End(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var parsed junitTestSuites
+	err = xml.Unmarshal(buffer.Bytes(), &parsed)
+	if err != nil {
+		t.Fatalf("%v:\n%s", err, buffer.String())
+	}
+
+	if parsed.Name != "app" || parsed.Tests != 5 || parsed.Failures != 1 || parsed.Errors != 1 || parsed.Skipped != 2 {
+		t.Fatalf("Unexpected totals:\n%s", buffer.String())
+	}
+	if len(parsed.Suites) != 2 || parsed.Suites[0].Name != "Models" || parsed.Suites[1].Name != "test/later_test.rb" {
+		t.Fatalf("Expected a suite for the top-level case and one for the file:\n%s", buffer.String())
+	}
+
+	modelsSuite := parsed.Suites[0]
+	if modelsSuite.Tests != 3 || modelsSuite.Time != "0.750000" || modelsSuite.Timestamp != "2016-03-04T05:06:07" {
+		t.Fatalf("Unexpected suite for Models:\n%s", buffer.String())
+	}
+	if len(modelsSuite.Suites) != 1 || modelsSuite.Suites[0].Name != "User" || len(modelsSuite.Suites[0].Cases) != 2 {
+		t.Fatalf("Expected User to be nested in Models:\n%s", buffer.String())
+	}
+	if len(modelsSuite.Cases) != 1 || modelsSuite.Cases[0].Error == nil || modelsSuite.Cases[0].SystemErr.Text != "warning\n" {
+		t.Fatalf("Expected only the last attempt of loads, as an error:\n%s", buffer.String())
+	}
+
+	validates := modelsSuite.Suites[0].Cases[1]
+	if validates.Classname != "Models.User" || validates.Time != "0.250000" || validates.SystemOut.Text != "some output\n\uFFFD[0m" {
+		t.Fatalf("Unexpected test case for validates:\n%s", buffer.String())
+	}
+	failure := validates.Failure
+	if failure == nil || failure.Message != "expected valid" || failure.Type != "RSpec::Expectations::ExpectationNotMetError" {
+		t.Fatalf("Unexpected failure for validates:\n%s", buffer.String())
+	}
+	if !strings.Contains(failure.Text, "spec/user_spec.rb:12 in block (2 levels)") ||
+		!strings.Contains(failure.Text, ">   12    expect(user).to be_valid") {
+		t.Fatalf("Expected backtrace and snippet in failure, got:\n%s", failure.Text)
+	}
+
+	later := parsed.Suites[1].Cases
+	if len(later) != 2 || later[0].Skipped == nil || later[1].Skipped == nil || later[1].Skipped.Message != "not run" {
+		t.Fatalf("Expected todo and not run tests to be skipped:\n%s", buffer.String())
+	}
+}
+
+const junitReport = `<?xml version="1.0" encoding="UTF-8"?>
+<testsuites>
+  <testsuite name="com.example.MathTest" tests="4" timestamp="2016-03-04T05:06:07">
+    <testcase name="adds" classname="com.example.MathTest" time="1,234.5"/>
+    <testcase name="subtracts" classname="com.example.MathTest" time="0.25">
+      <failure message="expected:&lt;2&gt; but was:&lt;3&gt;" type="java.lang.AssertionError"><![CDATA[java.lang.AssertionError: expected:<2> but was:<3>
+	at org.junit.Assert.fail(Assert.java:88)
+	at com.example.MathTest.subtracts(MathTest.java:12)
+]]></failure>
+      <system-out>some output</system-out>
+    </testcase>
+    <testcase name="divides" classname="com.example.MathTest">
+      <skipped message="not on this platform"/>
+    </testcase>
+    <testcase name="multiplies" classname="com.example.MathTest">
+      <flakyFailure message="timed out" type="java.util.concurrent.TimeoutException">
+        <system-out>first attempt</system-out>
+      </flakyFailure>
+    </testcase>
+  </testsuite>
+  <testsuite name="pytest" timestamp="2016-03-03T01:02:03">
+    <testcase name="test_loads" classname="tests.test_io.TestLoad" file="tests/test_io.py" line="7">
+      <error message="">Traceback (most recent call last):
+  File "tests/test_io.py", line 9, in test_loads
+NameError: name 'x' is not defined</error>
+    </testcase>
+  </testsuite>
+</testsuites>
+`
+
+func TestDecodeJunit(t *testing.T) {
+	var events []TestFinishEvent
+	start, err := DecodeJunit(strings.NewReader(junitReport), "", &DecodingCallbacks{
+		OnTestFinish: func(event TestFinishEvent) error {
+			events = append(events, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if start.Format("2006-01-02 15:04:05") != "2016-03-03 01:02:03" {
+		t.Fatalf("Expected the earliest timestamp, got %v", start)
+	}
+
+	expected := []struct {
+		filter string
+		status Status
+	}{
+		{"com.example.MathTest#adds", Pass},
+		{"com.example.MathTest#subtracts", Fail},
+		{"com.example.MathTest#divides", Omit},
+		{"com.example.MathTest#multiplies", Fail},
+		{"com.example.MathTest#multiplies", Pass},
+		{"tests.test_io.TestLoad#test_loads", Error},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %#v", len(expected), len(events), events)
+	}
+	for ix, e := range expected {
+		if events[ix].Filter.String() != e.filter || events[ix].Status != e.status {
+			t.Fatalf("Expected %s to be %s, got %s %s", e.filter, e.status, events[ix].Filter, events[ix].Status)
+		}
+	}
+
+	if events[0].Time != 1234.5 || len(events[0].Cases) != 1 || events[0].Cases[0].Label != "com.example.MathTest" {
+		t.Fatalf("Unexpected test: %#v", events[0])
+	}
+
+	subtracts := events[1]
+	if subtracts.Exception.Message != "expected:<2> but was:<3>" || subtracts.Exception.Class != "java.lang.AssertionError" {
+		t.Fatalf("Unexpected exception: %#v", subtracts.Exception)
+	}
+	if len(subtracts.Exception.Backtrace) != 2 || subtracts.Exception.Backtrace[1].File != "MathTest.java" ||
+		subtracts.Exception.Backtrace[1].Line != 12 || subtracts.Exception.Backtrace[1].Method != "com.example.MathTest.subtracts" {
+		t.Fatalf("Unexpected backtrace: %#v", subtracts.Exception.Backtrace)
+	}
+	if subtracts.Stdout != "some output" {
+		t.Fatalf("Expected system-out to be kept, got %q", subtracts.Stdout)
+	}
+
+	retried, flaky := events[3], events[4]
+	if !retried.Retried || retried.Attempt != 1 || retried.Stdout != "first attempt" || !flaky.Flaky || flaky.Attempt != 2 {
+		t.Fatalf("Expected a retried attempt and a flaky pass, got %#v and %#v", retried, flaky)
+	}
+
+	loads := events[5]
+	if loads.File != "tests/test_io.py" || loads.Line != 7 || len(loads.Cases) != 2 || loads.Cases[1].Label != "tests.test_io.TestLoad" {
+		t.Fatalf("Unexpected test: %#v", loads)
+	}
+	if loads.Exception.Class != JunitErrorExceptionClass || loads.Exception.Message != "Traceback (most recent call last):" ||
+		len(loads.Exception.Backtrace) != 1 || loads.Exception.Backtrace[0].Line != 9 {
+		t.Fatalf("Unexpected exception: %#v", loads.Exception)
+	}
+}
+
+func TestDecodeJunitFromEmitter(t *testing.T) {
+	buffer := &bytes.Buffer{}
+	emitter := NewJunitEmitter(buffer)
+
+	models := CaseEvent{Type: "case", Label: "Models", Level: 0}
+	user := CaseEvent{Type: "case", Label: "User", Level: 1}
+	emitter.TestFinish(TestFinishEvent{Type: "test", Label: "validates", Status: Fail, Cases: []CaseEvent{models, user},
+		Exception: &TestException{
+			Class:     "RSpec::Expectations::ExpectationNotMetError",
+			Message:   "expected valid",
+			Backtrace: []BacktraceLocation{{File: "spec/user_spec.rb", Line: 12, Method: "block (2 levels)"}},
+			Snippets:  map[string]map[string]string{"spec/user_spec.rb": {"12": "  expect(user).to be_valid"}},
+		}})
+	err := emitter.End(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var events []TestFinishEvent
+	_, err = DecodeJunit(buffer, "", &DecodingCallbacks{
+		OnTestFinish: func(event TestFinishEvent) error {
+			events = append(events, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(events) != 1 || len(events[0].Cases) != 2 || events[0].Cases[1].Label != "User" || events[0].Filter != "Models.User#validates" {
+		t.Fatalf("Unexpected events: %#v", events)
+	}
+	backtrace := events[0].Exception.Backtrace
+	if len(backtrace) != 1 || backtrace[0].File != "spec/user_spec.rb" || backtrace[0].Line != 12 || backtrace[0].Method != "block (2 levels)" {
+		t.Fatalf("Unexpected backtrace: %#v", backtrace)
+	}
+}
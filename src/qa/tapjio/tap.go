@@ -0,0 +1,420 @@
+package tapjio
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// The exception class reported for tests that fail without saying what kind of failure it was.
+const TapFailureExceptionClass = "TAP::Failure"
+
+// The exception class reported for tests that a TAP stream's plan promised but that were never
+// reported, e.g. because the stream bailed out.
+const TapMissingExceptionClass = "TAP::Missing"
+
+var tapVersionPattern = regexp.MustCompile(`^TAP version \d+\s*$`)
+var tapPlanPattern = regexp.MustCompile(`^1\.\.(\d+)\s*(#.*)?$`)
+var tapTestPattern = regexp.MustCompile(`^(not )?ok\b\s*(\d+)?\s*(-\s*)?(.*)$`)
+var tapDirectivePattern = regexp.MustCompile(`(?i)\s*#\s*(skip|todo)\S*\s*(.*)$`)
+var tapBailOutPattern = regexp.MustCompile(`^Bail out!\s*(.*)$`)
+var tapYamlStartPattern = regexp.MustCompile(`^(\s+)---\s*$`)
+var tapLocationPattern = regexp.MustCompile(`^(.*?):(\d+)(:\d+)?$`)
+var yamlEntryPattern = regexp.MustCompile(`^([^\s#:-][^:]*):(\s+(.*))?$`)
+
+// YAML diagnostics keys that are reported some way other than as part of a failure's message.
+var tapReservedYamlKeys = map[string]bool{
+	"message":     true,
+	"severity":    true,
+	"at":          true,
+	"file":        true,
+	"line":        true,
+	"stack":       true,
+	"duration_ms": true,
+	"type":        true,
+}
+
+type yamlEntry struct {
+	key   string
+	value string
+}
+
+// parseYaml parses the simple YAML found in TAP diagnostics blocks: a map of scalars, block
+// scalars (| and >), and nested collections, which are kept as their (dedented) text.
+func parseYaml(lines []string) []yamlEntry {
+	lines = dedent(lines)
+
+	var entries []yamlEntry
+	for ix := 0; ix < len(lines); ix++ {
+		match := yamlEntryPattern.FindStringSubmatch(lines[ix])
+		if match == nil {
+			continue
+		}
+
+		var nested []string
+		for ix+1 < len(lines) && (strings.TrimSpace(lines[ix+1]) == "" || indentation(lines[ix+1]) > 0) {
+			ix++
+			nested = append(nested, lines[ix])
+		}
+		for len(nested) > 0 && strings.TrimSpace(nested[len(nested)-1]) == "" {
+			nested = nested[:len(nested)-1]
+		}
+		nested = dedent(nested)
+
+		value := strings.TrimSpace(match[3])
+		switch {
+		case strings.HasPrefix(value, "|"):
+			value = strings.Join(nested, "\n")
+		case strings.HasPrefix(value, ">"):
+			value = strings.Join(nested, " ")
+		case value == "":
+			value = strings.Join(nested, "\n")
+		default:
+			value = unquoteYaml(value)
+		}
+
+		entries = append(entries, yamlEntry{key: strings.TrimSpace(match[1]), value: value})
+	}
+
+	return entries
+}
+
+func unquoteYaml(value string) string {
+	if len(value) >= 2 && value[0] == '"' && value[len(value)-1] == '"' {
+		if unquoted, err := strconv.Unquote(value); err == nil {
+			return unquoted
+		}
+	}
+	if len(value) >= 2 && value[0] == '\'' && value[len(value)-1] == '\'' {
+		return strings.Replace(value[1:len(value)-1], "''", "'", -1)
+	}
+
+	return value
+}
+
+func indentation(line string) int {
+	return len(line) - len(strings.TrimLeft(line, " \t"))
+}
+
+// dedent removes the indentation shared by every non-blank line.
+func dedent(lines []string) []string {
+	common := -1
+	for _, line := range lines {
+		if strings.TrimSpace(line) == "" {
+			continue
+		}
+		if indent := indentation(line); common == -1 || indent < common {
+			common = indent
+		}
+	}
+
+	dedented := make([]string, len(lines))
+	for ix, line := range lines {
+		if len(line) >= common && common > 0 {
+			line = line[common:]
+		}
+		dedented[ix] = strings.TrimRight(line, " \t")
+	}
+
+	return dedented
+}
+
+func yamlValue(entries []yamlEntry, key string) (string, bool) {
+	for _, entry := range entries {
+		if entry.key == key {
+			return entry.value, true
+		}
+	}
+
+	return "", false
+}
+
+// tapLocation finds where a failure happened, given either an at: of file:line, an at: with
+// file and line entries (as node-tap writes), or file and line entries of its own.
+func tapLocation(entries []yamlEntry) (BacktraceLocation, bool) {
+	at, ok := yamlValue(entries, "at")
+	if ok && !strings.Contains(at, "\n") {
+		if match := tapLocationPattern.FindStringSubmatch(at); match != nil {
+			line, _ := strconv.Atoi(match[2])
+			return BacktraceLocation{File: match[1], Line: line, User: true}, true
+		}
+	}
+	if ok {
+		entries = parseYaml(strings.Split(at, "\n"))
+	}
+
+	file, ok := yamlValue(entries, "file")
+	if !ok {
+		return BacktraceLocation{}, false
+	}
+	lineValue, _ := yamlValue(entries, "line")
+	line, _ := strconv.Atoi(lineValue)
+
+	return BacktraceLocation{File: file, Line: line, User: true}, true
+}
+
+type tapDecoder struct {
+	file    FilePath
+	visitor Visitor
+
+	// The test most recently reported, which isn't visited until everything about it is read.
+	pending     *TestFinishEvent
+	pendingYaml []string
+
+	// Lines that belong to whichever test is reported next, e.g. those of its subtests.
+	preamble []string
+
+	planned  int
+	count    int
+	reported map[int]bool
+}
+
+// TapFilter returns the filter given to the test with the given number and description in a
+// TAP stream read from file. If file is empty, the filter is just the description.
+func TapFilter(file FilePath, number int, description string) TestFilter {
+	prefix := ""
+	if file != "" {
+		prefix = file.String() + "#"
+	}
+
+	if description == "" {
+		return TestFilter(prefix + strconv.Itoa(number))
+	}
+
+	return TestFilter(prefix + description)
+}
+
+// DecodeTap reads a TAP stream (version 12 or 13) from reader, visiting a TestFinishEvent for
+// each test it reports. Each test's File is file, and its filter is file#description (see
+// TapFilter). YAML diagnostics are used to describe failures, along with any comments that
+// follow them. Tests promised by the stream's plan but never reported are visited as errors.
+//
+// Unlike Decode, DecodeTap doesn't visit suite events or call the visitor's End method, so
+// that several streams can be combined into one suite.
+func DecodeTap(reader io.Reader, file FilePath, visitor Visitor) error {
+	decoder := &tapDecoder{
+		file:     file,
+		visitor:  visitor,
+		reported: make(map[int]bool),
+	}
+
+	scanner := bufio.NewScanner(reader)
+	scanner.Buffer(make([]byte, 64*1024), 16*1024*1024)
+
+	var yamlIndent string
+	inYaml := false
+	bailOut := ""
+
+	// A test's YAML block must immediately follow it. Others belong to subtests.
+	afterTest := false
+	for scanner.Scan() {
+		line := strings.TrimRight(scanner.Text(), "\r")
+		wasAfterTest := afterTest
+		afterTest = false
+
+		if inYaml {
+			if strings.TrimSpace(line) == "..." && strings.HasPrefix(line, yamlIndent) {
+				inYaml = false
+			} else {
+				decoder.pendingYaml = append(decoder.pendingYaml, line)
+			}
+			continue
+		}
+
+		if match := tapYamlStartPattern.FindStringSubmatch(line); match != nil && wasAfterTest {
+			inYaml = true
+			yamlIndent = match[1]
+			decoder.pendingYaml = []string{}
+			continue
+		}
+
+		if indentation(line) > 0 {
+			decoder.preamble = append(decoder.preamble, line)
+			continue
+		}
+
+		if match := tapTestPattern.FindStringSubmatch(line); match != nil {
+			err := decoder.flush()
+			if err != nil {
+				return err
+			}
+			decoder.test(match[1] == "", match[2], match[4])
+			afterTest = true
+			continue
+		}
+
+		if match := tapPlanPattern.FindStringSubmatch(line); match != nil {
+			decoder.planned, _ = strconv.Atoi(match[1])
+			continue
+		}
+
+		if match := tapBailOutPattern.FindStringSubmatch(line); match != nil {
+			bailOut = match[1]
+			if bailOut == "" {
+				bailOut = "Bailed out"
+			} else {
+				bailOut = "Bailed out: " + bailOut
+			}
+			break
+		}
+
+		if strings.HasPrefix(line, "#") {
+			comment := strings.TrimPrefix(strings.TrimPrefix(line, "#"), " ")
+			// Comments about a test follow it, except for those that name the next test's subtests.
+			if decoder.pending != nil && !strings.HasPrefix(comment, "Subtest:") {
+				decoder.pending.Stdout += comment + "\n"
+			} else {
+				decoder.preamble = append(decoder.preamble, line)
+			}
+			continue
+		}
+
+		if tapVersionPattern.MatchString(line) || line == "" {
+			continue
+		}
+
+		// Anything else is output that isn't TAP. Keep it with the next test.
+		decoder.preamble = append(decoder.preamble, line)
+	}
+
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+
+	err := decoder.flush()
+	if err != nil {
+		return err
+	}
+
+	return decoder.reportMissing(bailOut)
+}
+
+func (self *tapDecoder) test(ok bool, numberText string, rest string) {
+	self.count++
+	number := self.count
+	if numberText != "" {
+		number, _ = strconv.Atoi(numberText)
+		self.count = number
+	}
+	self.reported[number] = true
+
+	description := strings.TrimSpace(rest)
+	directive := ""
+	reason := ""
+	if match := tapDirectivePattern.FindStringSubmatchIndex(description); match != nil {
+		directive = strings.ToLower(description[match[2]:match[3]])
+		reason = description[match[4]:match[5]]
+		description = strings.TrimSpace(description[:match[0]])
+	}
+	description = strings.Replace(description, `\#`, "#", -1)
+
+	label := description
+	if label == "" {
+		label = fmt.Sprintf("test %d", number)
+	}
+
+	event := &TestFinishEvent{
+		Type:   "test",
+		Label:  label,
+		Filter: TapFilter(self.file, number, description),
+		File:   self.file,
+		Stdout: strings.Join(dedent(self.preamble), "\n"),
+	}
+	if event.Stdout != "" {
+		event.Stdout += "\n"
+	}
+	self.preamble = nil
+
+	switch {
+	case directive == "todo":
+		event.Status = Todo
+	case directive == "skip":
+		event.Status = Omit
+	case ok:
+		event.Status = Pass
+	default:
+		event.Status = Fail
+		event.Exception = &TestException{Class: TapFailureExceptionClass, Message: description}
+	}
+	if reason != "" {
+		event.Stdout += reason + "\n"
+	}
+
+	self.pending = event
+	self.pendingYaml = nil
+}
+
+// flush visits the pending test, if any, using its YAML diagnostics to fill in the details.
+func (self *tapDecoder) flush() error {
+	event := self.pending
+	if event == nil {
+		return nil
+	}
+	self.pending = nil
+
+	entries := parseYaml(self.pendingYaml)
+	self.pendingYaml = nil
+
+	if value, ok := yamlValue(entries, "duration_ms"); ok {
+		if ms, err := strconv.ParseFloat(value, 64); err == nil {
+			event.Time = ms / 1000
+		}
+	}
+
+	if exception := event.Exception; exception != nil {
+		if message, ok := yamlValue(entries, "message"); ok && message != "" {
+			exception.Message = message
+		}
+		if class, ok := yamlValue(entries, "type"); ok && class != "" {
+			exception.Class = class
+		}
+		for _, entry := range entries {
+			if tapReservedYamlKeys[entry.key] {
+				continue
+			}
+			if strings.Contains(entry.value, "\n") {
+				exception.Message += "\n" + entry.key + ":\n  " + strings.Replace(entry.value, "\n", "\n  ", -1)
+			} else {
+				exception.Message += "\n" + entry.key + ": " + entry.value
+			}
+		}
+		if location, ok := tapLocation(entries); ok {
+			exception.Backtrace = []BacktraceLocation{location}
+		}
+		if stack, ok := yamlValue(entries, "stack"); ok {
+			event.Stderr = stack + "\n"
+		}
+	}
+
+	return self.visitor.TestFinish(*event)
+}
+
+// reportMissing visits an error for each test that the plan promised but that wasn't reported.
+func (self *tapDecoder) reportMissing(reason string) error {
+	if reason == "" {
+		reason = "Planned but not reported"
+	}
+
+	for number := 1; number <= self.planned; number++ {
+		if self.reported[number] {
+			continue
+		}
+
+		err := self.visitor.TestFinish(TestFinishEvent{
+			Type:      "test",
+			Label:     fmt.Sprintf("test %d", number),
+			Filter:    TapFilter(self.file, number, ""),
+			File:      self.file,
+			Status:    Error,
+			Exception: &TestException{Class: TapMissingExceptionClass, Message: reason},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,112 @@
+package tapjio
+
+import (
+	"strings"
+	"testing"
+)
+
+const tapStream = `TAP version 13
+1..7
+ok 1 - adds numbers
+not ok 2 - subtracts numbers
+  ---
+  message: 'expected 2, got 3'
+  severity: fail
+  data:
+    got: 3
+    wanted: 2
+  at:
+    file: test/math.js
+    line: 12
+    column: 3
+  duration_ms: 15.5
+  ...
+# an explanation of the failure
+ok 3 - divides by zero # SKIP not on this platform
+not ok 4 - multiplies # TODO later
+# Subtest: nested
+    1..2
+    ok 1 - inner one
+    not ok 2 - inner two
+      ---
+      at: test/math.js:40
+      ...
+not ok 5 - nested
+not ok 6 - escaped \# hash
+  ---
+  message: |
+    first line
+    second line
+  at: test/math.js:50:7
+  ...
+Bail out! database went away
+ok 7 - never seen
+`
+
+func TestDecodeTap(t *testing.T) {
+	var events []TestFinishEvent
+	err := DecodeTap(strings.NewReader(tapStream), "test/math.js", &DecodingCallbacks{
+		OnTestFinish: func(event TestFinishEvent) error {
+			events = append(events, event)
+			return nil
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []struct {
+		filter string
+		status Status
+	}{
+		{"test/math.js#adds numbers", Pass},
+		{"test/math.js#subtracts numbers", Fail},
+		{"test/math.js#divides by zero", Omit},
+		{"test/math.js#multiplies", Todo},
+		{"test/math.js#nested", Fail},
+		{"test/math.js#escaped # hash", Fail},
+		{"test/math.js#7", Error},
+	}
+	if len(events) != len(expected) {
+		t.Fatalf("Expected %d events, got %d: %#v", len(expected), len(events), events)
+	}
+	for ix, e := range expected {
+		if events[ix].Filter.String() != e.filter || events[ix].Status != e.status {
+			t.Fatalf("Expected %s to be %s, got %s %s", e.filter, e.status, events[ix].Filter, events[ix].Status)
+		}
+		if events[ix].File != "test/math.js" {
+			t.Fatalf("Expected file for %s, got %s", e.filter, events[ix].File)
+		}
+	}
+
+	subtracts := events[1]
+	if subtracts.Time != 0.0155 {
+		t.Fatalf("Expected duration_ms to be used, got %v", subtracts.Time)
+	}
+	if subtracts.Exception.Message != "expected 2, got 3\ndata:\n  got: 3\n  wanted: 2" {
+		t.Fatalf("Unexpected message: %q", subtracts.Exception.Message)
+	}
+	if len(subtracts.Exception.Backtrace) != 1 ||
+		subtracts.Exception.Backtrace[0].File != "test/math.js" ||
+		subtracts.Exception.Backtrace[0].Line != 12 {
+		t.Fatalf("Unexpected backtrace: %#v", subtracts.Exception.Backtrace)
+	}
+	if subtracts.Stdout != "an explanation of the failure\n" {
+		t.Fatalf("Expected comments to be kept, got %q", subtracts.Stdout)
+	}
+
+	nested := events[4]
+	if !strings.Contains(nested.Stdout, "not ok 2 - inner two") || nested.Exception.Message != "nested" {
+		t.Fatalf("Expected subtest output to be kept, got %#v", nested)
+	}
+
+	escaped := events[5].Exception
+	if escaped.Message != "first line\nsecond line" || escaped.Backtrace[0].Line != 50 {
+		t.Fatalf("Unexpected exception: %#v", escaped)
+	}
+
+	missing := events[6].Exception
+	if missing.Class != TapMissingExceptionClass || missing.Message != "Bailed out: database went away" {
+		t.Fatalf("Unexpected exception for missing test: %#v", missing)
+	}
+}